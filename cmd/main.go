@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"github.com/Roll-Play/togglelabs/pkg/api"
 	"github.com/Roll-Play/togglelabs/pkg/config"
 	"github.com/Roll-Play/togglelabs/pkg/logger"
+	"github.com/Roll-Play/togglelabs/pkg/migrations"
 	"github.com/Roll-Play/togglelabs/pkg/storage"
 	"github.com/joho/godotenv"
 )
@@ -32,7 +34,14 @@ func main() {
 		log.Panic(err)
 	}
 
-	app := api.NewApp(os.Getenv("PORT"), storage, logger)
+	if err := migrations.Run(context.Background(), storage.DB(), logger); err != nil {
+		log.Panic(err)
+	}
+
+	app, err := api.NewApp(os.Getenv("PORT"), storage, logger)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	log.Panic(app.Listen())
 }
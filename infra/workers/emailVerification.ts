@@ -1,23 +1,36 @@
 import { SQSEvent } from "aws-lambda";
 
+// Published by the queue transport of the Go mailer package
+type Message = {
+  from: string;
+  to: string[];
+  subject: string;
+  text: string;
+  html: string;
+};
+
 export const handler = async (event: SQSEvent) => {
-  const res = await fetch("https://api.resend.com/emails", {
-    method: "POST",
-    headers: {
-      "Content-Type": "application/json",
-      Authorization: `Bearer RESEND_API_KEY`,
-    },
-    body: JSON.stringify({
-      from: "Toggle Labs <onboarding@togglelabs.net>",
-      to: [""],
-      subject: "hello world",
-      html: "<strong>Onboarding</strong>",
-    }),
-  });
+  for (const record of event.Records) {
+    const message: Message = JSON.parse(record.body);
 
-  if (!res.ok) {
-    // send to queue/logger
-  }
+    const res = await fetch("https://api.resend.com/emails", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Authorization: `Bearer ${process.env.RESEND_API_KEY}`,
+      },
+      body: JSON.stringify({
+        from: message.from,
+        to: message.to,
+        subject: message.subject,
+        text: message.text,
+        html: message.html,
+      }),
+    });
 
-  return;
+    if (!res.ok) {
+      // Failing the batch makes SQS redeliver it
+      throw new Error(`email delivery failed with status ${res.status}`);
+    }
+  }
 };
@@ -7,7 +7,11 @@ type AuthResponse struct {
 	Email     string             `json:"email" `
 	FirstName string             `json:"first_name,omitempty" `
 	LastName  string             `json:"last_name,omitempty" `
-	Token     string             `json:"token"`
+	// Clients prompt for verification while it is false
+	EmailVerified bool   `json:"email_verified"`
+	Token         string `json:"token"`
+	// Dashboard location an OAuth sign-in started from
+	RedirectTo string `json:"redirect_to,omitempty"`
 }
 
 type Tuple[T comparable, U comparable] struct {
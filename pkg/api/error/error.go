@@ -9,12 +9,37 @@ import (
 type ErrorMessage = string
 
 const (
-	NotFoundError       ErrorMessage = "record not found"
-	InternalServerError ErrorMessage = "internal server error"
-	EmailConflictError  ErrorMessage = "email already in use"
-	UnauthorizedError   ErrorMessage = "user lacks valid authentication credentials"
-	BadRequestError     ErrorMessage = "malformed request"
-	ForbiddenError      ErrorMessage = "forbidden action"
+	NotFoundError             ErrorMessage = "record not found"
+	InternalServerError       ErrorMessage = "internal server error"
+	EmailConflictError        ErrorMessage = "email already in use"
+	UnauthorizedError         ErrorMessage = "user lacks valid authentication credentials"
+	BadRequestError           ErrorMessage = "malformed request"
+	ForbiddenError            ErrorMessage = "forbidden action"
+	FlagKeyConflictError      ErrorMessage = "feature flag key already in use"
+	ExportDeliveryError       ErrorMessage = "export delivery is not configured"
+	NotificationError         ErrorMessage = "notification delivery failed"
+	InvalidTokenError         ErrorMessage = "invalid or expired token"
+	EmailNotVerifiedError     ErrorMessage = "email address not verified"
+	EmailVerifiedError        ErrorMessage = "email address already verified"
+	TooManyRequestsError      ErrorMessage = "too many requests"
+	InvalidPasswordError      ErrorMessage = "invalid password"
+	TwoFactorEnabledError     ErrorMessage = "two-factor authentication already enabled"
+	TwoFactorRequiredError    ErrorMessage = "two-factor authentication required"
+	InvalidTwoFactorCodeError ErrorMessage = "invalid two-factor code"
+	InvalidOIDCProviderError  ErrorMessage = "identity provider unavailable or misconfigured"
+	InvalidOAuthStateError    ErrorMessage = "invalid or expired sign-in attempt"
+	InvalidRedirectError      ErrorMessage = "redirect location not allowed"
+	AccountLinkRequiredError  ErrorMessage = "an account with this email exists, sign in to link this provider"
+	IdentityInUseError        ErrorMessage = "identity linked to another account"
+	IdentityLinkedError       ErrorMessage = "an identity of this provider is already linked"
+	LastSignInMethodError     ErrorMessage = "the last sign-in method can't be removed"
+	ReauthenticationError     ErrorMessage = "sign in again to continue"
+	PolicyViolationError      ErrorMessage = "change blocked by environment policy"
+	OrganizationSignInError   ErrorMessage = "ask an admin of the organization to add you before signing in"
+	LastAdminError            ErrorMessage = "the organization needs an admin"
+	AccountLockedError        ErrorMessage = "too many failed sign-ins, try again later"
+	IPNotAllowedError         ErrorMessage = "IP address not allowed by the organization"
+	IPAllowlistLockoutError   ErrorMessage = "the dashboard allowlist has to include your IP address"
 )
 
 type Error struct {
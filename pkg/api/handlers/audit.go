@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/export"
+	auditmodel "github.com/Roll-Play/togglelabs/pkg/models/audit"
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+type AuditHandler struct {
+	db       *mongo.Database
+	logger   *zap.Logger
+	uploader export.Uploader
+}
+
+// NewAuditHandler returns a handler delivering exports with uploader, which
+// may be nil when delivery isn't configured.
+func NewAuditHandler(db *mongo.Database, logger *zap.Logger, uploader export.Uploader) *AuditHandler {
+	return &AuditHandler{
+		db:       db,
+		logger:   logger,
+		uploader: uploader,
+	}
+}
+
+const (
+	AuditExportSource    = "audit"
+	TimelineExportSource = "timeline"
+)
+
+// Entries buffered before flushing a streamed export to the client
+const exportFlushInterval = 100
+
+// Trailers of streamed exports, clients that don't receive a complete status
+// got a truncated export.
+const (
+	ExportRecordsTrailer = "X-Export-Records"
+	ExportStatusTrailer  = "X-Export-Status"
+	ExportComplete       = "complete"
+	ExportIncomplete     = "incomplete"
+)
+
+type AuditExportRequest struct {
+	Source string    `json:"source" query:"source" validate:"omitempty,oneof=audit timeline"`
+	Format string    `json:"format" query:"format" validate:"omitempty,oneof=jsonl csv"`
+	From   time.Time `json:"from" query:"from" validate:"required"`
+	To     time.Time `json:"to" query:"to" validate:"required,gtfield=From"`
+}
+
+type AuditExportResponse struct {
+	Key      string `json:"key"`
+	Location string `json:"location"`
+}
+
+type ListAuditResponse struct {
+	PageSize   int                      `json:"page_size"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	Data       []auditmodel.AuditRecord `json:"data"`
+}
+
+func (ah *AuditHandler) ListAuditLog(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, ah.logger)
+	if !ok {
+		return nil
+	}
+
+	filter, err := auditListFilter(c)
+	if err != nil {
+		ah.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	_, limit := apiutils.GetPaginationParams("", c.QueryParam("page_size"))
+
+	if cursorQuery := c.QueryParam("cursor"); cursorQuery != "" {
+		cursor, err := apiutils.ParseCursor(cursorQuery, auditmodel.AuditSort)
+		if err != nil {
+			ah.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusBadRequest,
+				apierrors.BadRequestError,
+			)
+		}
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{cursor.Filter(auditmodel.AuditSort)}})
+	}
+
+	model := auditmodel.New(ah.db)
+	records, err := model.FindMany(context.Background(), organization.ID, filter, limit)
+	if err != nil {
+		ah.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	nextCursor := ""
+	if len(records) == limit {
+		nextCursor, err = apiutils.NewCursor(records[len(records)-1], auditmodel.AuditSort)
+		if err != nil {
+			ah.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+	}
+
+	return c.JSON(http.StatusOK, ListAuditResponse{
+		PageSize:   limit,
+		NextCursor: nextCursor,
+		Data:       records,
+	})
+}
+
+func (ah *AuditHandler) VerifyAuditLog(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, ah.logger)
+	if !ok {
+		return nil
+	}
+
+	model := auditmodel.New(ah.db)
+	result, err := model.Verify(context.Background(), organization.ID)
+	if err != nil {
+		ah.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// ExportAuditLog streams the audit or timeline entries of a date range.
+func (ah *AuditHandler) ExportAuditLog(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, ah.logger)
+	if !ok {
+		return nil
+	}
+
+	request, format, ok := ah.bindExportRequest(c)
+	if !ok {
+		return nil
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, format.ContentType())
+	response.Header().Set(
+		echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=%q", exportFileName(request, format)),
+	)
+	response.Header().Set("Trailer", ExportRecordsTrailer+", "+ExportStatusTrailer)
+	response.WriteHeader(http.StatusOK)
+
+	// The status is already sent, a failure can only cut the export short and
+	// is reported in the trailers
+	count, err := ah.writeExport(organization.ID, request, format, response, response.Flush)
+	status := ExportComplete
+	if err != nil {
+		ah.logger.Error("Export interrupted",
+			zap.Error(err),
+		)
+		status = ExportIncomplete
+	}
+	response.Header().Set(ExportRecordsTrailer, strconv.Itoa(count))
+	response.Header().Set(ExportStatusTrailer, status)
+
+	return nil
+}
+
+// PostAuditExport writes the audit or timeline entries of a date range to the
+// configured bucket.
+func (ah *AuditHandler) PostAuditExport(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, ah.logger)
+	if !ok {
+		return nil
+	}
+
+	if ah.uploader == nil {
+		ah.logger.Debug("Client error",
+			zap.Error(errors.New(apierrors.ExportDeliveryError)),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusNotImplemented,
+			apierrors.ExportDeliveryError,
+		)
+	}
+
+	request, format, ok := ah.bindExportRequest(c)
+	if !ok {
+		return nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := ah.writeExport(organization.ID, request, format, writer, func() {})
+		writer.CloseWithError(err)
+	}()
+
+	key := organization.ID.Hex() + "/" + exportFileName(request, format)
+	location, err := ah.uploader.Upload(context.Background(), key, format.ContentType(), reader)
+	// Unblocks the writer when the upload stopped reading early
+	reader.Close()
+	if err != nil {
+		ah.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, AuditExportResponse{
+		Key:      key,
+		Location: location,
+	})
+}
+
+func (ah *AuditHandler) bindExportRequest(c echo.Context) (*AuditExportRequest, export.Format, bool) {
+	request := new(AuditExportRequest)
+	if err := c.Bind(request); err != nil {
+		ah.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+		return nil, "", false
+	}
+
+	validate := validator.New()
+
+	if err := validate.Struct(request); err != nil {
+		ah.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+		return nil, "", false
+	}
+
+	if request.Source == "" {
+		request.Source = AuditExportSource
+	}
+
+	// The format is already validated
+	format, _ := export.ParseFormat(request.Format)
+
+	return request, format, true
+}
+
+// writeExport encodes every entry of the export to w, calling flush
+// periodically so clients receive the export while it's being read. It
+// returns the number of entries written.
+func (ah *AuditHandler) writeExport(
+	organizationID primitive.ObjectID,
+	request *AuditExportRequest,
+	format export.Format,
+	w io.Writer,
+	flush func(),
+) (int, error) {
+	filter := bson.D{{Key: "timestamp", Value: bson.M{
+		"$gte": primitive.NewDateTimeFromTime(request.From),
+		"$lt":  primitive.NewDateTimeFromTime(request.To),
+	}}}
+
+	header := export.AuditHeader
+	if request.Source == TimelineExportSource {
+		header = export.TimelineHeader
+	}
+
+	encoder, err := export.NewEncoder(w, format, header)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	encode := func(value interface{}, row []string) error {
+		if err := encoder.Encode(value, row); err != nil {
+			return err
+		}
+
+		count++
+		if count%exportFlushInterval == 0 {
+			if err := encoder.Flush(); err != nil {
+				return err
+			}
+			flush()
+		}
+
+		return nil
+	}
+
+	if request.Source == TimelineExportSource {
+		model := timelinemodel.New(ah.db)
+		err = model.Each(context.Background(), organizationID, filter, func(record *timelinemodel.TimelineRecord) error {
+			return encode(record, export.TimelineRow(record))
+		})
+	} else {
+		model := auditmodel.New(ah.db)
+		err = model.Each(context.Background(), organizationID, filter, func(record *auditmodel.AuditRecord) error {
+			return encode(record, export.AuditRow(record))
+		})
+	}
+	if err != nil {
+		return count, err
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return count, err
+	}
+	flush()
+
+	return count, nil
+}
+
+func exportFileName(request *AuditExportRequest, format export.Format) string {
+	return fmt.Sprintf(
+		"%s-%s-%s.%s",
+		request.Source,
+		request.From.UTC().Format("20060102T150405Z"),
+		request.To.UTC().Format("20060102T150405Z"),
+		format.Extension(),
+	)
+}
+
+func auditListFilter(c echo.Context) (bson.D, error) {
+	filter := bson.D{}
+
+	if actor := c.QueryParam("actor"); actor != "" {
+		actorID, err := primitive.ObjectIDFromHex(actor)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "actor_id", Value: actorID})
+	}
+
+	if action := c.QueryParam("action"); action != "" {
+		filter = append(filter, bson.E{Key: "action", Value: action})
+	}
+
+	timestamp, err := timestampRangeFilter(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// organizationFromContext returns the user and the organization the
+// permission middlewares of the route let them into. When it can't, the route
+// is missing its middleware and the error response has already been written.
+func organizationFromContext(
+	c echo.Context,
+	logger *zap.Logger,
+) (primitive.ObjectID, *organizationmodel.OrganizationRecord, bool) {
+	userID, err := apiutils.GetUserFromContext(c)
+	if err != nil {
+		logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(
+			c,
+			http.StatusUnauthorized,
+			apierrors.UnauthorizedError,
+		)
+		return primitive.NilObjectID, nil, false
+	}
+
+	organization, err := apiutils.GetOrganizationRecordFromContext(c)
+	if err != nil {
+		logger.Error("Route without permission middleware",
+			zap.Error(err),
+			zap.String("path", c.Path()),
+		)
+		_ = apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+		return primitive.NilObjectID, nil, false
+	}
+
+	return userID, organization, true
+}
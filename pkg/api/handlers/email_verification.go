@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+	tokenmodel "github.com/Roll-Play/togglelabs/pkg/models/token"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const EmailVerificationTTL = 24 * time.Hour
+
+type EmailVerificationHandler struct {
+	db           *mongo.Database
+	logger       *zap.Logger
+	mailer       *mailer.Sender
+	dashboardURL string
+}
+
+func NewEmailVerificationHandler(
+	db *mongo.Database,
+	logger *zap.Logger,
+	sender *mailer.Sender,
+	dashboardURL string,
+) *EmailVerificationHandler {
+	return &EmailVerificationHandler{
+		db:           db,
+		logger:       logger,
+		mailer:       sender,
+		dashboardURL: dashboardURL,
+	}
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (eh *EmailVerificationHandler) PostVerifyEmail(c echo.Context) error {
+	request := new(VerifyEmailRequest)
+	if err := c.Bind(request); err != nil {
+		eh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		eh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	tokenModel := tokenmodel.New(eh.db)
+	token, err := tokenModel.Consume(context.Background(), tokenmodel.EmailVerification, request.Token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			eh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusBadRequest,
+				apierrors.InvalidTokenError,
+			)
+		}
+
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	userModel := usermodel.New(eh.db)
+	err = userModel.UpdateOne(context.Background(), token.UserID, bson.D{{Key: "email_verified", Value: true}})
+	if err != nil {
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	c.Set("user", token.UserID.Hex())
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (eh *EmailVerificationHandler) PostResendVerification(c echo.Context) error {
+	userID, err := apiutils.GetUserFromContext(c)
+	if err != nil {
+		eh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusUnauthorized,
+			apierrors.UnauthorizedError,
+		)
+	}
+
+	userModel := usermodel.New(eh.db)
+	user, err := userModel.FindByID(context.Background(), userID)
+	if err != nil {
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	if user.EmailVerified {
+		eh.logger.Debug("Client error",
+			zap.String("cause", apierrors.EmailVerifiedError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusConflict,
+			apierrors.EmailVerifiedError,
+		)
+	}
+
+	tokenModel := tokenmodel.New(eh.db)
+	now := time.Now().UTC()
+	recent, err := tokenModel.FindLatestSince(
+		context.Background(),
+		userID,
+		tokenmodel.EmailVerification,
+		now.Add(-time.Hour),
+	)
+	if err != nil {
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	if retryAfter := tokenRetryAfter(recent, now); retryAfter > 0 {
+		eh.logger.Debug("Client error",
+			zap.String("cause", apierrors.TooManyRequestsError),
+		)
+		apiutils.SetRetryAfter(c, retryAfter)
+		return apierrors.CustomError(c,
+			http.StatusTooManyRequests,
+			apierrors.TooManyRequestsError,
+		)
+	}
+
+	// Only the latest link works, older emails may have leaked
+	if err := tokenModel.Revoke(context.Background(), userID, tokenmodel.EmailVerification); err != nil {
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	if err := sendVerificationEmail(context.Background(), eh.db, eh.mailer, eh.dashboardURL, user); err != nil {
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// sendVerificationEmail stores a new verification token of user and queues
+// the email with its link.
+func sendVerificationEmail(
+	ctx context.Context,
+	db *mongo.Database,
+	sender *mailer.Sender,
+	dashboardURL string,
+	user *usermodel.UserRecord,
+) error {
+	token, hash, err := tokenmodel.Generate()
+	if err != nil {
+		return err
+	}
+
+	tokenModel := tokenmodel.New(db)
+	record := tokenmodel.NewTokenRecord(user.ID, tokenmodel.EmailVerification, hash, EmailVerificationTTL)
+	if _, err := tokenModel.InsertOne(ctx, record); err != nil {
+		return err
+	}
+
+	name := user.FirstName
+	if name == "" {
+		name = user.Email
+	}
+
+	return sender.Send(mailer.VerificationTemplate, user.Email, mailer.VerificationData{
+		Name:      name,
+		Link:      strings.TrimRight(dashboardURL, "/") + "/verify-email?token=" + token,
+		ExpiresIn: EmailVerificationTTL,
+	})
+}
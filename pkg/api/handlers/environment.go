@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var EmptyEnvironmentList = []organizationmodel.Environment{}
+
+// EnvironmentHandler manages the environments of an organization and the
+// policies that protect them.
+type EnvironmentHandler struct {
+	db     *mongo.Database
+	logger *zap.Logger
+}
+
+type EnvironmentRequest struct {
+	Description string                                   `json:"description"`
+	Protection  *organizationmodel.EnvironmentProtection `json:"protection" validate:"omitempty"`
+}
+
+// PolicyErrorResponse tells which policy of a protected environment blocked a
+// change.
+type PolicyErrorResponse struct {
+	Error     string                             `json:"error"`
+	Message   apierrors.ErrorMessage             `json:"message"`
+	Violation *organizationmodel.PolicyViolation `json:"violation"`
+}
+
+func (eh *EnvironmentHandler) ListEnvironments(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, eh.logger)
+	if !ok {
+		return nil
+	}
+
+	environments := organization.Environments
+	if environments == nil {
+		environments = EmptyEnvironmentList
+	}
+
+	return c.JSON(http.StatusOK, environments)
+}
+
+// PutEnvironment creates the environment, or replaces its description and
+// protection when it exists.
+func (eh *EnvironmentHandler) PutEnvironment(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, eh.logger)
+	if !ok {
+		return nil
+	}
+
+	request := new(EnvironmentRequest)
+	if err := c.Bind(request); err != nil {
+		eh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		eh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if request.Protection != nil {
+		for _, roleID := range request.Protection.AllowedRoles {
+			if organization.Role(roleID) == nil {
+				eh.logger.Debug("Client error",
+					zap.String("role", roleID.Hex()),
+				)
+				return apierrors.CustomError(c,
+					http.StatusBadRequest,
+					apierrors.BadRequestError,
+				)
+			}
+		}
+	}
+
+	environment := &organizationmodel.Environment{
+		Name:        c.Param("name"),
+		Description: request.Description,
+		Protection:  request.Protection,
+	}
+
+	organizationModel := organizationmodel.New(eh.db)
+	if err := organizationModel.PutEnvironment(context.Background(), organization.ID, environment); err != nil {
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, environment)
+}
+
+func (eh *EnvironmentHandler) DeleteEnvironment(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, eh.logger)
+	if !ok {
+		return nil
+	}
+
+	name := c.Param("name")
+	organizationModel := organizationmodel.New(eh.db)
+	if err := organizationModel.RemoveEnvironment(context.Background(), organization.ID, name); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			eh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
+
+		eh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	eh.logger.Info("Environment deleted",
+		zap.String("name", name),
+	)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// policyError answers that violation keeps the change from happening.
+func policyError(c echo.Context, logger *zap.Logger, violation *organizationmodel.PolicyViolation) error {
+	logger.Debug("Client error",
+		zap.Error(violation),
+	)
+	return c.JSON(http.StatusForbidden, PolicyErrorResponse{
+		Error:     http.StatusText(http.StatusForbidden),
+		Message:   apierrors.PolicyViolationError,
+		Violation: violation,
+	})
+}
+
+func NewEnvironmentHandler(db *mongo.Database, logger *zap.Logger) *EnvironmentHandler {
+	return &EnvironmentHandler{
+		db:     db,
+		logger: logger,
+	}
+}
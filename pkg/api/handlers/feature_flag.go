@@ -3,14 +3,18 @@ package handlers
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"time"
 
 	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/events"
 	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
 	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
 	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	"github.com/Roll-Play/togglelabs/pkg/storage"
 	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
@@ -21,18 +25,21 @@ import (
 )
 
 type FeatureFlagHandler struct {
-	db     *mongo.Database
-	logger *zap.Logger
+	db        *mongo.Database
+	logger    *zap.Logger
+	publisher events.Publisher
 }
 
-func NewFeatureFlagHandler(db *mongo.Database, logger *zap.Logger) *FeatureFlagHandler {
+func NewFeatureFlagHandler(db *mongo.Database, logger *zap.Logger, publisher events.Publisher) *FeatureFlagHandler {
 	return &FeatureFlagHandler{
-		db:     db,
-		logger: logger,
+		db:        db,
+		logger:    logger,
+		publisher: publisher,
 	}
 }
 
 type PostFeatureFlagRequest struct {
+	Key          string                     `json:"key" validate:"required"`
 	Name         string                     `json:"name" validate:"required"`
 	DefaultValue string                     `json:"default_value" validate:"required"`
 	Environment  string                     `json:"environment" validate:"required"`
@@ -47,15 +54,27 @@ type PatchFeatureFlagRequest struct {
 	Rules        []featureflagmodel.Rule `json:"rules" validate:"dive,required"`
 }
 
+type PatchFeatureFlagNameRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
 type PatchFeatureFlagTagsRequest struct {
 	Tags []string `json:"tags"`
 }
 
+type GetFeatureFlagResponse struct {
+	featureflagmodel.FeatureFlagRecord
+	LiveRevision *featureflagmodel.Revision     `json:"live_revision"`
+	Drafts       []featureflagmodel.Revision    `json:"drafts"`
+	Timeline     []timelinemodel.TimelineRecord `json:"timeline"`
+}
+
 type ListFeatureFlagResponse struct {
-	Page     int                                  `json:"page"`
-	PageSize int                                  `json:"page_size"`
-	Total    int                                  `json:"total"`
-	Data     []featureflagmodel.FeatureFlagRecord `json:"data"`
+	Page       int                                  `json:"page"`
+	PageSize   int                                  `json:"page_size"`
+	Total      int                                  `json:"total"`
+	NextCursor string                               `json:"next_cursor,omitempty"`
+	Data       []featureflagmodel.FeatureFlagRecord `json:"data"`
 }
 
 func (ffh *FeatureFlagHandler) ListFeatureFlags(c echo.Context) error {
@@ -64,7 +83,13 @@ func (ffh *FeatureFlagHandler) ListFeatureFlags(c echo.Context) error {
 
 	page, limit := apiutils.GetPaginationParams(pageQuery, limitQuery)
 
-	userID, err := apiutils.GetUserFromContext(c)
+	_, organization, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organization.ID
+
+	filter, err := featureFlagListFilter(c)
 	if err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
@@ -76,10 +101,10 @@ func (ffh *FeatureFlagHandler) ListFeatureFlags(c echo.Context) error {
 		)
 	}
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
+	sort, ok := featureFlagListSorts[c.QueryParam("sort")]
+	if !ok {
 		ffh.logger.Debug("Client error",
-			zap.Error(err),
+			zap.String("sort", c.QueryParam("sort")),
 		)
 		return apierrors.CustomError(
 			c,
@@ -88,8 +113,9 @@ func (ffh *FeatureFlagHandler) ListFeatureFlags(c echo.Context) error {
 		)
 	}
 
-	organizationModel := organizationmodel.New(ffh.db)
-	organization, err := organizationModel.FindByID(context.Background(), organizationID)
+	model := featureflagmodel.New(ffh.db)
+
+	total, err := model.CountMany(context.Background(), organizationID, filter)
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
@@ -101,24 +127,25 @@ func (ffh *FeatureFlagHandler) ListFeatureFlags(c echo.Context) error {
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organization, organizationmodel.ReadOnly)
-	if !permission {
-		ffh.logger.Debug("Client error",
-			zap.Error(errors.New(apierrors.ForbiddenError)),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
+	query := filter
+	if cursorQuery := c.QueryParam("cursor"); cursorQuery != "" {
+		cursor, err := apiutils.ParseCursor(cursorQuery, sort)
+		if err != nil {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusBadRequest,
+				apierrors.BadRequestError,
+			)
+		}
+		// Cursors replace the page offset, the next page starts after the cursor
+		page = 1
+		query = append(query, bson.E{Key: "$and", Value: bson.A{cursor.Filter(sort)}})
 	}
 
-	model := featureflagmodel.New(ffh.db)
-
-	featureFlags, err := model.FindMany(context.Background(), organizationID, page, limit, bson.D{{
-		Key:   "timestamps.created_at",
-		Value: -1,
-	}})
+	featureFlags, err := model.FindMany(context.Background(), organizationID, query, page, limit, sort)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return c.JSON(http.StatusOK, ListFeatureFlagResponse{
@@ -138,62 +165,187 @@ func (ffh *FeatureFlagHandler) ListFeatureFlags(c echo.Context) error {
 		)
 	}
 
+	nextCursor := ""
+	if len(featureFlags) == limit {
+		nextCursor, err = apiutils.NewCursor(featureFlags[len(featureFlags)-1], sort)
+		if err != nil {
+			ffh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+	}
+
 	return c.JSON(http.StatusOK, ListFeatureFlagResponse{
-		Data:     featureFlags,
-		Page:     page,
-		PageSize: limit,
-		Total:    len(featureFlags),
+		Data:       featureFlags,
+		Page:       page,
+		PageSize:   limit,
+		Total:      int(total),
+		NextCursor: nextCursor,
 	})
 }
 
-func (ffh *FeatureFlagHandler) PostFeatureFlag(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+// The _id is always used as a tiebreaker so pages stay stable between requests
+var featureFlagListSorts = map[string]bson.D{
+	"": {
+		{Key: "timestamps.created_at", Value: -1},
+		{Key: "_id", Value: -1},
+	},
+	"created_at": {
+		{Key: "timestamps.created_at", Value: 1},
+		{Key: "_id", Value: 1},
+	},
+	"-created_at": {
+		{Key: "timestamps.created_at", Value: -1},
+		{Key: "_id", Value: -1},
+	},
+	"updated_at": {
+		{Key: "timestamps.updated_at", Value: 1},
+		{Key: "_id", Value: 1},
+	},
+	"-updated_at": {
+		{Key: "timestamps.updated_at", Value: -1},
+		{Key: "_id", Value: -1},
+	},
+	"name": {
+		{Key: "name", Value: 1},
+		{Key: "_id", Value: 1},
+	},
+	"-name": {
+		{Key: "name", Value: -1},
+		{Key: "_id", Value: -1},
+	},
+}
+
+var errInvalidEnabledFilter = errors.New("enabled filter requires an environment")
+
+func featureFlagListFilter(c echo.Context) (bson.D, error) {
+	filter := bson.D{}
+
+	if tags := c.QueryParams()["tag"]; len(tags) > 0 {
+		filter = append(filter, bson.E{Key: "tags", Value: bson.M{"$all": tags}})
+	}
+
+	if project := c.QueryParam("project"); project != "" {
+		projectID, err := primitive.ObjectIDFromHex(project)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "project._id", Value: projectID})
+	}
+
+	if flagType := c.QueryParam("type"); flagType != "" {
+		filter = append(filter, bson.E{Key: "type", Value: flagType})
+	}
+
+	if creator := c.QueryParam("created_by"); creator != "" {
+		creatorID, err := primitive.ObjectIDFromHex(creator)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "user_id", Value: creatorID})
+	}
+
+	environment := c.QueryParam("environment")
+	enabled := c.QueryParam("enabled")
+	switch {
+	case enabled != "":
+		if environment == "" {
+			return nil, errInvalidEnabledFilter
+		}
+		isEnabled, err := strconv.ParseBool(enabled)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "environments", Value: bson.M{
+			"$elemMatch": bson.M{"name": environment, "is_enabled": isEnabled},
+		}})
+	case environment != "":
+		filter = append(filter, bson.E{Key: "environments.name", Value: environment})
+	}
+
+	if search := c.QueryParam("search"); search != "" {
+		// Case sensitive prefixes of lowercase fields use their indexes
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(featureflagmodel.SearchName(search))}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"search_name": prefix},
+			bson.M{"key": prefix},
+		}})
+	}
+
+	return filter, nil
+}
+
+func (ffh *FeatureFlagHandler) GetFeatureFlag(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
 	}
+	organizationID := organization.ID
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
+	model := featureflagmodel.New(ffh.db)
+	featureFlagRecord, err := model.FindByIdentifier(
+		context.Background(),
+		organizationID,
+		c.Param("featureFlagID"),
+	)
 	if err != nil {
-		ffh.logger.Debug("Client error",
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
+		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
 		return apierrors.CustomError(
 			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
 		)
 	}
 
-	organizationModel := organizationmodel.New(ffh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
+	timelineModel := timelinemodel.New(ffh.db)
+	timeline, err := timelineModel.FindMany(
+		context.Background(),
+		organizationID,
+		bson.D{{Key: "feature_flag_id", Value: featureFlagRecord.ID}},
+		config.TimelineRecentEntries,
+	)
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(c,
+		return apierrors.CustomError(
+			c,
 			http.StatusInternalServerError,
 			apierrors.InternalServerError,
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		ffh.logger.Debug("Client error",
-			zap.Error(errors.New(apierrors.ForbiddenError)),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
+	return c.JSON(http.StatusOK, GetFeatureFlagResponse{
+		FeatureFlagRecord: *featureFlagRecord,
+		LiveRevision:      featureFlagRecord.LiveRevision(),
+		Drafts:            featureFlagRecord.DraftRevisions(),
+		Timeline:          timeline,
+	})
+}
+
+func (ffh *FeatureFlagHandler) PostFeatureFlag(c echo.Context) error {
+	userID, organizationRecord, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
 	}
+	organizationID := organizationRecord.ID
 
 	request := new(PostFeatureFlagRequest)
 	if err := c.Bind(request); err != nil {
@@ -219,17 +371,48 @@ func (ffh *FeatureFlagHandler) PostFeatureFlag(c echo.Context) error {
 		)
 	}
 
-	if len(request.Tags) > 0 {
-		err = organizationModel.UpdateOne(
-			context.Background(),
-			bson.D{{Key: "_id", Value: organizationID}},
-			bson.D{{Key: "$addToSet",
-				Value: bson.M{"tags": bson.M{"$each": request.Tags}},
-			}},
+	if !featureflagmodel.IsValidKey(request.Key) {
+		ffh.logger.Debug("Client error",
+			zap.String("key", request.Key),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
 		)
 	}
 
-	if err != nil {
+	var projectID primitive.ObjectID
+	if request.Project != nil {
+		projectID = request.Project.ID
+	}
+
+	if !apiutils.UserIsAllowed(userID, organizationRecord, organizationmodel.FlagEdit, organizationmodel.Scope{
+		ProjectID:   projectID,
+		Environment: request.Environment,
+	}) {
+		ffh.logger.Debug("Client error",
+			zap.String("cause", apierrors.ForbiddenError),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusForbidden,
+			apierrors.ForbiddenError,
+		)
+	}
+
+	featureFlagModel := featureflagmodel.New(ffh.db)
+	_, err := featureFlagModel.FindByKey(context.Background(), organizationID, request.Key)
+	if err == nil {
+		ffh.logger.Debug("Client error",
+			zap.Error(errors.New(apierrors.FlagKeyConflictError)),
+		)
+		return apierrors.CustomError(c,
+			http.StatusConflict,
+			apierrors.FlagKeyConflictError,
+		)
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -239,8 +422,8 @@ func (ffh *FeatureFlagHandler) PostFeatureFlag(c echo.Context) error {
 		)
 	}
 
-	featureFlagModel := featureflagmodel.New(ffh.db)
 	featureFlagRecord := featureflagmodel.NewFeatureFlagRecord(
+		request.Key,
 		request.Name,
 		request.DefaultValue,
 		request.Type,
@@ -252,40 +435,50 @@ func (ffh *FeatureFlagHandler) PostFeatureFlag(c echo.Context) error {
 		request.Tags,
 	)
 
-	featureFlagID, err := featureFlagModel.InsertOne(context.Background(), featureFlagRecord)
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
-	}
-
+	organizationModel := organizationmodel.New(ffh.db)
 	timelineModel := timelinemodel.New(ffh.db)
-	_, err = timelineModel.InsertOne(context.Background(),
-		&timelinemodel.TimelineRecord{
-			FeatureFlagID: featureFlagID,
-			Entries:       []timelinemodel.TimelineEntry{},
-		})
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
-	}
+	var timelineRecord *timelinemodel.TimelineRecord
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		if len(request.Tags) > 0 {
+			err := organizationModel.UpdateOne(
+				ctx,
+				bson.D{{Key: "_id", Value: organizationID}},
+				bson.D{{Key: "$addToSet",
+					Value: bson.M{"tags": bson.M{"$each": request.Tags}},
+				}},
+			)
+			if err != nil {
+				return err
+			}
+		}
 
-	timelineEntry := timelinemodel.NewTimelineEntry(
-		userID,
-		timelinemodel.Created,
-	)
-	err = timelineModel.UpdateOne(context.Background(), featureFlagID, timelineEntry)
+		featureFlagID, err := featureFlagModel.InsertOne(ctx, featureFlagRecord)
+		if err != nil {
+			return err
+		}
+
+		timelineRecord = timelinemodel.NewTimelineRecord(
+			organizationID,
+			featureFlagID,
+			userID,
+			timelinemodel.FeatureFlagCreated,
+		)
+		timelineRecord.RevisionID = &featureFlagRecord.Revisions[0].ID
+		timelineRecord.After = timelinemodel.NewSnapshot(featureFlagRecord)
+		_, err = timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
+		// Another request may have taken the key after the lookup above
+		if mongo.IsDuplicateKeyError(err) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusConflict,
+				apierrors.FlagKeyConflictError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -295,11 +488,18 @@ func (ffh *FeatureFlagHandler) PostFeatureFlag(c echo.Context) error {
 		)
 	}
 
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
 	return c.JSON(http.StatusCreated, featureFlagRecord)
 }
 
 func (ffh *FeatureFlagHandler) PatchFeatureFlag(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
+	userID, organizationRecord, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organizationRecord.ID
+
+	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
 	if err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
@@ -311,8 +511,8 @@ func (ffh *FeatureFlagHandler) PatchFeatureFlag(c echo.Context) error {
 		)
 	}
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
+	request := new(PatchFeatureFlagRequest)
+	if err := c.Bind(request); err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
 		)
@@ -323,82 +523,61 @@ func (ffh *FeatureFlagHandler) PatchFeatureFlag(c echo.Context) error {
 		)
 	}
 
-	organizationModel := organizationmodel.New(ffh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
+	featureFlagModel := featureflagmodel.New(ffh.db)
+	featureFlagRecord, err := featureFlagModel.FindByID(context.Background(), organizationID, featureFlagID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
-	}
-
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		ffh.logger.Debug("Client error",
-			zap.Error(errors.New(apierrors.ForbiddenError)),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
-	}
-
-	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
 		return apierrors.CustomError(
 			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
 		)
 	}
 
-	request := new(PatchFeatureFlagRequest)
-	if err := c.Bind(request); err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+	if !ffh.authorizeFeatureFlag(c, userID, organizationRecord, featureFlagRecord, organizationmodel.FlagEdit, "") {
+		return nil
 	}
 
-	featureFlagModel := featureflagmodel.New(ffh.db)
-
 	revision := featureflagmodel.NewRevisionRecord(
 		request.DefaultValue,
 		request.Rules,
 		userID,
 	)
-	err = featureFlagModel.UpdateOne(
-		context.Background(),
-		bson.M{"$and": []bson.M{
-			{"_id": featureFlagID},
-			{"organization_id": organizationID},
-		}},
-		bson.D{{Key: "$push", Value: bson.M{"revisions": revision}}},
+	timelineModel := timelinemodel.New(ffh.db)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		organizationID,
+		featureFlagID,
+		userID,
+		timelinemodel.RevisionCreated,
 	)
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
-	}
+	timelineRecord.RevisionID = &revision.ID
+	timelineRecord.After = timelinemodel.NewSnapshot(featureFlagRecord)
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		err := featureFlagModel.UpdateOne(
+			ctx,
+			organizationID,
+			featureFlagID,
+			bson.D{{Key: "$push", Value: bson.M{"revisions": revision}}},
+		)
+		if err != nil {
+			return err
+		}
 
-	timelineModel := timelinemodel.New(ffh.db)
-	timelineEntry := timelinemodel.NewTimelineEntry(userID, timelinemodel.RevisionCreated)
-	err = timelineModel.UpdateOne(context.Background(), featureFlagID, timelineEntry)
+		_, err = timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
@@ -409,11 +588,18 @@ func (ffh *FeatureFlagHandler) PatchFeatureFlag(c echo.Context) error {
 		)
 	}
 
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
 	return c.JSON(http.StatusOK, revision)
 }
 
 func (ffh *FeatureFlagHandler) ApproveRevision(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
+	userID, organizationRecord, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organizationRecord.ID
+
+	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
 	if err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
@@ -425,7 +611,7 @@ func (ffh *FeatureFlagHandler) ApproveRevision(c echo.Context) error {
 		)
 	}
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
+	revisionID, err := primitive.ObjectIDFromHex(c.Param("revisionID"))
 	if err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
@@ -437,65 +623,72 @@ func (ffh *FeatureFlagHandler) ApproveRevision(c echo.Context) error {
 		)
 	}
 
-	organizationModel := organizationmodel.New(ffh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
+	model := featureflagmodel.New(ffh.db)
+	featureFlagRecord, err := model.FindByID(context.Background(), organizationID, featureFlagID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(c,
+		return apierrors.CustomError(
+			c,
 			http.StatusInternalServerError,
 			apierrors.InternalServerError,
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		ffh.logger.Debug("Server error",
-			zap.Error(errors.New(apierrors.UnauthorizedError)),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusUnauthorized,
-			apierrors.UnauthorizedError,
-		)
+	if !ffh.authorizeFlagEnvironments(c, userID, organizationRecord, featureFlagRecord, organizationmodel.FlagApprove) {
+		return nil
 	}
 
-	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
-	if err != nil {
+	revision := featureFlagRecord.Revision(revisionID)
+	if revision == nil {
 		ffh.logger.Debug("Client error",
-			zap.Error(err),
+			zap.String("revision", revisionID.Hex()),
 		)
 		return apierrors.CustomError(
 			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
+			http.StatusNotFound,
+			apierrors.NotFoundError,
 		)
 	}
 
-	revisionID, err := primitive.ObjectIDFromHex(c.Param("revisionID"))
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+	overrideReason, ok := checkFreezeWindows(c, ffh.logger, organizationRecord, userID, flagEnvironments(featureFlagRecord))
+	if !ok {
+		return nil
 	}
 
-	model := featureflagmodel.New(ffh.db)
-	featureFlagRecord, err := model.FindByID(context.Background(), featureFlagID)
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
+	before := timelinemodel.NewSnapshot(featureFlagRecord)
+
+	// Authors don't review their own revisions
+	approves := revision.UserID != userID
+	for _, approverID := range revision.Approvals {
+		approves = approves && approverID != userID
+	}
+	if approves {
+		revision.Approvals = append(revision.Approvals, userID)
+	}
+
+	violation, pending := checkRevisionPolicies(organizationRecord, userID, featureFlagRecord, revision)
+	if violation != nil {
+		return policyError(c, ffh.logger, violation)
+	}
+
+	if pending != nil {
+		if !approves {
+			return policyError(c, ffh.logger, pending)
+		}
+
+		return ffh.recordApproval(c, userID, featureFlagRecord, revisionID, pending, overrideReason)
 	}
 
 	var lastRevisionID primitive.ObjectID
@@ -511,10 +704,6 @@ func (ffh *FeatureFlagHandler) ApproveRevision(c echo.Context) error {
 	}
 	featureFlagRecord.Version++
 
-	filters := bson.M{"$and": []bson.M{
-		{"_id": featureFlagID},
-		{"organization_id": organizationID},
-	}}
 	newValues := bson.D{
 		{
 			Key: "$set", Value: bson.D{
@@ -523,20 +712,26 @@ func (ffh *FeatureFlagHandler) ApproveRevision(c echo.Context) error {
 			},
 		},
 	}
-	err = model.UpdateOne(context.Background(), filters, newValues)
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
-	}
-
 	timelineModel := timelinemodel.New(ffh.db)
-	timelineEntry := timelinemodel.NewTimelineEntry(userID, timelinemodel.RevisionApproved)
-	err = timelineModel.UpdateOne(context.Background(), featureFlagID, timelineEntry)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		organizationID,
+		featureFlagID,
+		userID,
+		timelinemodel.RevisionApproved,
+	)
+	timelineRecord.OverrideReason = overrideReason
+	timelineRecord.RevisionID = &revisionID
+	timelineRecord.LastRevisionID = &lastRevisionID
+	timelineRecord.Before = before
+	timelineRecord.After = timelinemodel.NewSnapshot(featureFlagRecord)
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		if err := model.UpdateOne(ctx, organizationID, featureFlagID, newValues); err != nil {
+			return err
+		}
+
+		_, err := timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
@@ -547,37 +742,112 @@ func (ffh *FeatureFlagHandler) ApproveRevision(c echo.Context) error {
 		)
 	}
 
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
 	return c.JSON(http.StatusOK, featureFlagRecord)
 }
 
-func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+// PendingApprovalResponse is the revision which still needs reviewers before
+// it can go live.
+type PendingApprovalResponse struct {
+	Revision  *featureflagmodel.Revision         `json:"revision"`
+	Violation *organizationmodel.PolicyViolation `json:"violation"`
+}
+
+// checkRevisionPolicies checks the revision against the protected environments
+// of the flag. The violation blocks the approval, pending means the revision
+// needs more reviewers.
+func checkRevisionPolicies(
+	organization *organizationmodel.OrganizationRecord,
+	userID primitive.ObjectID,
+	featureFlag *featureflagmodel.FeatureFlagRecord,
+	revision *featureflagmodel.Revision,
+) (violation, pending *organizationmodel.PolicyViolation) {
+	member := organization.Member(userID)
+	for _, flagEnvironment := range featureFlag.Environments {
+		environment := organization.Environment(flagEnvironment.Name)
+		if environment == nil {
+			continue
+		}
+
+		if violation := environment.CheckChange(member, false); violation != nil {
+			return violation, nil
+		}
+
+		if pending == nil {
+			pending = environment.CheckReviewers(len(revision.Approvals))
+		}
 	}
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+	return nil, pending
+}
+
+// checkFlagChange checks a direct change of the flag, like a rollback or its
+// deletion, against its protected environments.
+func checkFlagChange(
+	organization *organizationmodel.OrganizationRecord,
+	userID primitive.ObjectID,
+	featureFlag *featureflagmodel.FeatureFlagRecord,
+) *organizationmodel.PolicyViolation {
+	member := organization.Member(userID)
+	for _, flagEnvironment := range featureFlag.Environments {
+		environment := organization.Environment(flagEnvironment.Name)
+		if environment == nil {
+			continue
+		}
+
+		if violation := environment.CheckChange(member, true); violation != nil {
+			return violation
+		}
 	}
 
-	organizationModel := organizationmodel.New(ffh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
+	return nil
+}
+
+// recordApproval saves the approval of a revision that can't go live yet.
+// Approvals are added in place, so concurrent reviewers all count.
+func (ffh *FeatureFlagHandler) recordApproval(
+	c echo.Context,
+	userID primitive.ObjectID,
+	featureFlag *featureflagmodel.FeatureFlagRecord,
+	revisionID primitive.ObjectID,
+	pending *organizationmodel.PolicyViolation,
+	overrideReason string,
+) error {
+	model := featureflagmodel.New(ffh.db)
+	timelineModel := timelinemodel.New(ffh.db)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		featureFlag.OrganizationID,
+		featureFlag.ID,
+		userID,
+		timelinemodel.RevisionReviewed,
+	)
+	timelineRecord.OverrideReason = overrideReason
+	timelineRecord.RevisionID = &revisionID
+	timelineRecord.Before = timelinemodel.NewSnapshot(featureFlag)
+
+	var updated *featureflagmodel.FeatureFlagRecord
+	err := storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		var err error
+		updated, err = model.AddApproval(ctx, featureFlag.OrganizationID, featureFlag.ID, revisionID, userID)
+		if err != nil {
+			return err
+		}
+
+		timelineRecord.After = timelinemodel.NewSnapshot(updated)
+		_, err = timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
+
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -587,17 +857,19 @@ func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		ffh.logger.Debug("Server error",
-			zap.Error(errors.New(apierrors.ForbiddenError)),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
+	return c.JSON(http.StatusAccepted, PendingApprovalResponse{
+		Revision:  updated.Revision(revisionID),
+		Violation: pending,
+	})
+}
+
+func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error {
+	userID, organizationRecord, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
 	}
+	organizationID := organizationRecord.ID
 
 	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
 	if err != nil {
@@ -612,8 +884,18 @@ func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error
 	}
 
 	model := featureflagmodel.New(ffh.db)
-	featureFlagRecord, err := model.FindByID(context.Background(), featureFlagID)
+	featureFlagRecord, err := model.FindByID(context.Background(), organizationID, featureFlagID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -624,6 +906,21 @@ func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error
 		)
 	}
 
+	if !ffh.authorizeFlagEnvironments(c, userID, organizationRecord, featureFlagRecord, organizationmodel.FlagApprove) {
+		return nil
+	}
+
+	overrideReason, ok := checkFreezeWindows(c, ffh.logger, organizationRecord, userID, flagEnvironments(featureFlagRecord))
+	if !ok {
+		return nil
+	}
+
+	if violation := checkFlagChange(organizationRecord, userID, featureFlagRecord); violation != nil {
+		return policyError(c, ffh.logger, violation)
+	}
+
+	before := timelinemodel.NewSnapshot(featureFlagRecord)
+
 	newRevisionID := new(primitive.ObjectID)
 	for index, revision := range featureFlagRecord.Revisions {
 		if revision.Status == featureflagmodel.Live {
@@ -639,10 +936,6 @@ func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error
 	}
 	featureFlagRecord.Version--
 
-	filters := bson.M{"$and": []bson.M{
-		{"_id": featureFlagID},
-		{"organization_id": organizationID},
-	}}
 	newValues := bson.D{
 		{
 			Key: "$set", Value: bson.D{
@@ -651,19 +944,28 @@ func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error
 			},
 		},
 	}
-	err = model.UpdateOne(context.Background(), filters, newValues)
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
-	}
 	timelineModel := timelinemodel.New(ffh.db)
-	timelineEntry := timelinemodel.NewTimelineEntry(userID, timelinemodel.FeatureFlagRollback)
-	err = timelineModel.UpdateOne(context.Background(), featureFlagID, timelineEntry)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		organizationID,
+		featureFlagID,
+		userID,
+		timelinemodel.FeatureFlagRollback,
+	)
+	timelineRecord.OverrideReason = overrideReason
+	timelineRecord.RevisionID = newRevisionID
+	if before.LiveRevision != nil {
+		timelineRecord.LastRevisionID = &before.LiveRevision.ID
+	}
+	timelineRecord.Before = before
+	timelineRecord.After = timelinemodel.NewSnapshot(featureFlagRecord)
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		if err := model.UpdateOne(ctx, organizationID, featureFlagID, newValues); err != nil {
+			return err
+		}
+
+		_, err := timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
@@ -674,23 +976,18 @@ func (ffh *FeatureFlagHandler) RollbackFeatureFlagVersion(c echo.Context) error
 		)
 	}
 
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
 	return c.JSON(http.StatusOK, featureFlagRecord)
 }
 
 func (ffh *FeatureFlagHandler) DeleteFeatureFlag(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+	userID, organizationRecord, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
 	}
+	organizationID := organizationRecord.ID
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
+	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
 	if err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
@@ -702,73 +999,67 @@ func (ffh *FeatureFlagHandler) DeleteFeatureFlag(c echo.Context) error {
 		)
 	}
 
-	organizationModel := organizationmodel.New(ffh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
+	model := featureflagmodel.New(ffh.db)
+	featureFlagRecord, err := model.FindByID(context.Background(), organizationID, featureFlagID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(c,
+		return apierrors.CustomError(
+			c,
 			http.StatusInternalServerError,
 			apierrors.InternalServerError,
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		ffh.logger.Debug("Server error",
-			zap.Error(errors.New(apierrors.ForbiddenError)),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
+	if !ffh.authorizeFeatureFlag(c, userID, organizationRecord, featureFlagRecord, organizationmodel.FlagEdit, "") {
+		return nil
 	}
 
-	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+	overrideReason, ok := checkFreezeWindows(c, ffh.logger, organizationRecord, userID, flagEnvironments(featureFlagRecord))
+	if !ok {
+		return nil
 	}
 
-	model := featureflagmodel.New(ffh.db)
+	if violation := checkFlagChange(organizationRecord, userID, featureFlagRecord); violation != nil {
+		return policyError(c, ffh.logger, violation)
+	}
 
-	err = model.UpdateOne(
-		context.Background(),
-		bson.M{"$and": []bson.M{
-			{"_id": featureFlagID},
-			{"organization_id": organizationID},
+	newValues := bson.D{
+		{Key: "$set", Value: bson.D{
+			{
+				Key:   "deleted_at",
+				Value: primitive.NewDateTimeFromTime(time.Now().UTC()),
+			},
 		}},
-		bson.D{
-			{Key: "$set", Value: bson.D{
-				{
-					Key:   "deleted_at",
-					Value: primitive.NewDateTimeFromTime(time.Now().UTC()),
-				},
-			}},
-		},
-	)
-
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err))
-		return apierrors.CustomError(
-			c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
 	}
-
 	timelineModel := timelinemodel.New(ffh.db)
-	timelineEntry := timelinemodel.NewTimelineEntry(userID, timelinemodel.FeatureFlagDeleted)
-	err = timelineModel.UpdateOne(context.Background(), featureFlagID, timelineEntry)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		organizationID,
+		featureFlagID,
+		userID,
+		timelinemodel.FeatureFlagDeleted,
+	)
+	timelineRecord.OverrideReason = overrideReason
+	timelineRecord.Before = timelinemodel.NewSnapshot(featureFlagRecord)
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		if err := model.UpdateOne(ctx, organizationID, featureFlagID, newValues); err != nil {
+			return err
+		}
+
+		_, err := timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
@@ -779,58 +1070,18 @@ func (ffh *FeatureFlagHandler) DeleteFeatureFlag(c echo.Context) error {
 		)
 	}
 
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
 	ffh.logger.Info("Soft deleted feature flag",
 		zap.String("_id", featureFlagID.Hex()))
 	return c.NoContent(http.StatusNoContent)
 }
 
 func (ffh *FeatureFlagHandler) ToggleFeatureFlag(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
-	}
-
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
-		ffh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
-	}
-
-	organizationModel := organizationmodel.New(ffh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
-	}
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		ffh.logger.Debug("Server error",
-			zap.Error(errors.New(apierrors.ForbiddenError)),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
+	userID, organizationRecord, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
 	}
+	organizationID := organizationRecord.ID
 
 	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
 	if err != nil {
@@ -845,8 +1096,18 @@ func (ffh *FeatureFlagHandler) ToggleFeatureFlag(c echo.Context) error {
 	}
 
 	model := featureflagmodel.New(ffh.db)
-	featureFlagRecord, err := model.FindByID(context.Background(), featureFlagID)
+	featureFlagRecord, err := model.FindByID(context.Background(), organizationID, featureFlagID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -856,18 +1117,31 @@ func (ffh *FeatureFlagHandler) ToggleFeatureFlag(c echo.Context) error {
 			apierrors.InternalServerError,
 		)
 	}
+	before := timelinemodel.NewSnapshot(featureFlagRecord)
 
 	environmentName := c.QueryParams().Get("env")
+	if !ffh.authorizeFeatureFlag(c, userID, organizationRecord, featureFlagRecord, organizationmodel.FlagToggle, environmentName) {
+		return nil
+	}
+
+	overrideReason, ok := checkFreezeWindows(c, ffh.logger, organizationRecord, userID, []string{environmentName})
+	if !ok {
+		return nil
+	}
+
+	if environment := organizationRecord.Environment(environmentName); environment != nil {
+		member := organizationRecord.Member(userID)
+		if violation := environment.CheckChange(member, true); violation != nil {
+			return policyError(c, ffh.logger, violation)
+		}
+	}
+
 	for index, environment := range featureFlagRecord.Environments {
 		if environment.Name == environmentName {
 			featureFlagRecord.Environments[index].IsEnabled = !(featureFlagRecord.Environments[index].IsEnabled)
 		}
 	}
 
-	filters := bson.M{"$and": []bson.M{
-		{"_id": featureFlagID},
-		{"organization_id": organizationID},
-	}}
 	newValues := bson.D{
 		{
 			Key: "$set", Value: bson.D{
@@ -875,20 +1149,25 @@ func (ffh *FeatureFlagHandler) ToggleFeatureFlag(c echo.Context) error {
 			},
 		},
 	}
-	err = model.UpdateOne(context.Background(), filters, newValues)
-	if err != nil {
-		ffh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
-	}
-
 	timelineModel := timelinemodel.New(ffh.db)
-	timelineEntry := timelinemodel.NewTimelineEntry(userID, fmt.Sprintf(timelinemodel.FeatureFlagToggle, environmentName))
-	err = timelineModel.UpdateOne(context.Background(), featureFlagID, timelineEntry)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		organizationID,
+		featureFlagID,
+		userID,
+		timelinemodel.FeatureFlagToggled,
+	)
+	timelineRecord.OverrideReason = overrideReason
+	timelineRecord.Environment = environmentName
+	timelineRecord.Before = before
+	timelineRecord.After = timelinemodel.NewSnapshot(featureFlagRecord)
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		if err := model.UpdateOne(ctx, organizationID, featureFlagID, newValues); err != nil {
+			return err
+		}
+
+		_, err := timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
@@ -899,35 +1178,57 @@ func (ffh *FeatureFlagHandler) ToggleFeatureFlag(c echo.Context) error {
 		)
 	}
 
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
 	return c.JSON(http.StatusOK, featureFlagRecord)
 }
 
 func (ffh *FeatureFlagHandler) PatchFeatureFlagTags(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
+	userID, organization, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organization.ID
+
+	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
 	if err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(c,
-			http.StatusUnauthorized,
-			apierrors.UnauthorizedError,
+		return apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
 		)
 	}
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
+	request := new(PatchFeatureFlagTagsRequest)
+	if err := c.Bind(request); err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
 		)
 		return apierrors.CustomError(c,
-			http.StatusUnauthorized,
-			apierrors.UnauthorizedError,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
 		)
 	}
 
-	organizationModel := organizationmodel.New(ffh.db)
-	organization, err := organizationModel.FindByID(context.Background(), organizationID)
+	update := bson.D{{Key: "$addToSet",
+		Value: bson.M{"tags": bson.M{"$each": request.Tags}},
+	}}
+
+	model := featureflagmodel.New(ffh.db)
+	featureFlagRecord, err := model.FindByID(context.Background(), organizationID, featureFlagID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -938,18 +1239,71 @@ func (ffh *FeatureFlagHandler) PatchFeatureFlagTags(c echo.Context) error {
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organization, organizationmodel.Collaborator)
-	if !permission {
-		ffh.logger.Debug("Client error",
-			zap.Error(errors.New(apierrors.ForbiddenError)),
+	if !ffh.authorizeFeatureFlag(c, userID, organization, featureFlagRecord, organizationmodel.FlagEdit, "") {
+		return nil
+	}
+
+	timelineModel := timelinemodel.New(ffh.db)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		organizationID,
+		featureFlagID,
+		userID,
+		timelinemodel.FeatureFlagTagsUpdated,
+	)
+	timelineRecord.Before = timelinemodel.NewSnapshot(featureFlagRecord)
+	tags := make(map[string]bool, len(featureFlagRecord.Tags))
+	for _, tag := range featureFlagRecord.Tags {
+		tags[tag] = true
+	}
+	for _, tag := range request.Tags {
+		if !tags[tag] {
+			tags[tag] = true
+			featureFlagRecord.Tags = append(featureFlagRecord.Tags, tag)
+		}
+	}
+	timelineRecord.After = timelinemodel.NewSnapshot(featureFlagRecord)
+
+	organizationModel := organizationmodel.New(ffh.db)
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		err := organizationModel.UpdateOne(ctx,
+			bson.D{{Key: "_id", Value: organizationID}},
+			update,
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := model.UpdateOne(ctx, organizationID, featureFlagID, update); err != nil {
+			return err
+		}
+
+		_, err = timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
+	if err != nil {
+		ffh.logger.Debug("Server error",
+			zap.Error(err),
 		)
 		return apierrors.CustomError(
 			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
 		)
 	}
 
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
+	ffh.logger.Info("Feature flag updated",
+		zap.String("_id", featureFlagID.Hex()))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (ffh *FeatureFlagHandler) PatchFeatureFlagName(c echo.Context) error {
+	userID, organization, ok := organizationFromContext(c, ffh.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organization.ID
+
 	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
 	if err != nil {
 		ffh.logger.Debug("Client error",
@@ -962,57 +1316,151 @@ func (ffh *FeatureFlagHandler) PatchFeatureFlagTags(c echo.Context) error {
 		)
 	}
 
-	request := new(PatchFeatureFlagTagsRequest)
+	request := new(PatchFeatureFlagNameRequest)
 	if err := c.Bind(request); err != nil {
 		ffh.logger.Debug("Client error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(c,
-			http.StatusUnauthorized,
-			apierrors.UnauthorizedError,
+		return apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
 		)
 	}
 
-	update := bson.D{{Key: "$addToSet",
-		Value: bson.M{"tags": bson.M{"$each": request.Tags}},
-	}}
+	validate := validator.New()
 
-	err = organizationModel.UpdateOne(context.Background(),
-		bson.D{{Key: "_id", Value: organizationID}},
-		update,
-	)
+	if err := validate.Struct(request); err != nil {
+		ffh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	model := featureflagmodel.New(ffh.db)
+	featureFlagRecord, err := model.FindByID(context.Background(), organizationID, featureFlagID)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ffh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(c,
+		return apierrors.CustomError(
+			c,
 			http.StatusInternalServerError,
 			apierrors.InternalServerError,
 		)
 	}
 
-	model := featureflagmodel.New(ffh.db)
+	if !ffh.authorizeFeatureFlag(c, userID, organization, featureFlagRecord, organizationmodel.FlagEdit, "") {
+		return nil
+	}
 
-	err = model.UpdateOne(
-		context.Background(),
-		bson.M{"$and": []bson.M{
-			{"_id": featureFlagID},
-			{"organization_id": organizationID},
-		}},
-		update,
+	newValues := bson.D{
+		{
+			Key: "$set", Value: bson.D{
+				{Key: "name", Value: request.Name},
+				{Key: "search_name", Value: featureflagmodel.SearchName(request.Name)},
+			},
+		},
+	}
+	timelineModel := timelinemodel.New(ffh.db)
+	timelineRecord := timelinemodel.NewTimelineRecord(
+		organizationID,
+		featureFlagID,
+		userID,
+		timelinemodel.FeatureFlagRenamed,
 	)
+	timelineRecord.Before = timelinemodel.NewSnapshot(featureFlagRecord)
+	featureFlagRecord.Name = request.Name
+	timelineRecord.After = timelinemodel.NewSnapshot(featureFlagRecord)
+	err = storage.WithTransaction(context.Background(), ffh.db, func(ctx context.Context) error {
+		if err := model.UpdateOne(ctx, organizationID, featureFlagID, newValues); err != nil {
+			return err
+		}
+
+		_, err := timelineModel.InsertOne(ctx, timelineRecord)
+		return err
+	})
 	if err != nil {
 		ffh.logger.Debug("Server error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(
-			c,
+		return apierrors.CustomError(c,
 			http.StatusInternalServerError,
 			apierrors.InternalServerError,
 		)
 	}
 
-	ffh.logger.Info("Feature flag updated",
+	ffh.publisher.Publish(events.FromTimeline(timelineRecord))
+	ffh.logger.Info("Feature flag renamed",
 		zap.String("_id", featureFlagID.Hex()))
 	return c.NoContent(http.StatusNoContent)
 }
+
+// authorizeFlagEnvironments checks permission for changes to the whole flag,
+// like its revisions, which apply to every environment of the flag. Grants
+// limited to environments have to cover each of them.
+func (ffh *FeatureFlagHandler) authorizeFlagEnvironments(
+	c echo.Context,
+	userID primitive.ObjectID,
+	organization *organizationmodel.OrganizationRecord,
+	featureFlag *featureflagmodel.FeatureFlagRecord,
+	permission organizationmodel.Permission,
+) bool {
+	environments := flagEnvironments(featureFlag)
+	if len(environments) == 0 {
+		return ffh.authorizeFeatureFlag(c, userID, organization, featureFlag, permission, "")
+	}
+
+	for _, environment := range environments {
+		if !ffh.authorizeFeatureFlag(c, userID, organization, featureFlag, permission, environment) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// authorizeFeatureFlag checks that the user has permission on the feature
+// flag, within environment for changes limited to one. When they don't, the
+// error response has already been written.
+func (ffh *FeatureFlagHandler) authorizeFeatureFlag(
+	c echo.Context,
+	userID primitive.ObjectID,
+	organization *organizationmodel.OrganizationRecord,
+	featureFlag *featureflagmodel.FeatureFlagRecord,
+	permission organizationmodel.Permission,
+	environment string,
+) bool {
+	scope := organizationmodel.Scope{Environment: environment}
+	if featureFlag.Project != nil {
+		scope.ProjectID = featureFlag.Project.ID
+	}
+
+	if !apiutils.UserIsAllowed(userID, organization, permission, scope) {
+		ffh.logger.Debug("Client error",
+			zap.String("cause", apierrors.ForbiddenError),
+			zap.String("permission", permission),
+		)
+		_ = apierrors.CustomError(
+			c,
+			http.StatusForbidden,
+			apierrors.ForbiddenError,
+		)
+		return false
+	}
+
+	return true
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,7 +18,9 @@ import (
 	"github.com/Roll-Play/togglelabs/pkg/api/handlers/fixtures"
 	"github.com/Roll-Play/togglelabs/pkg/api/middlewares"
 	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/events"
 	"github.com/Roll-Play/togglelabs/pkg/logger"
+	"github.com/Roll-Play/togglelabs/pkg/migrations"
 	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
 	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
 	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
@@ -47,26 +51,36 @@ func (suite *FeatureFlagHandlerTestSuite) SetupTest() {
 	suite.Server = echo.New()
 
 	logger, _ := logger.NewZapLogger()
-	h := handlers.NewFeatureFlagHandler(suite.db, logger)
+	h := handlers.NewFeatureFlagHandler(suite.db, logger, events.NewBus())
 
-	testGroup := suite.Server.Group("", middlewares.AuthMiddleware, middlewares.OrganizationMiddleware)
-	testGroup.POST("/features", h.PostFeatureFlag)
+	canReadFlags := middlewares.RequirePermission(suite.db, organizationmodel.FlagRead)
+	canEditFlags := middlewares.RequirePermission(suite.db, organizationmodel.FlagEdit)
+	canToggleFlags := middlewares.RequirePermission(suite.db, organizationmodel.FlagToggle)
+	canApproveFlags := middlewares.RequirePermission(suite.db, organizationmodel.FlagApprove)
+
+	testGroup := suite.Server.Group("", middlewares.AuthMiddleware(suite.db), middlewares.OrganizationMiddleware)
+	testGroup.POST("/features", h.PostFeatureFlag, canEditFlags)
 	testGroup.PATCH(
 		"/features/:featureFlagID",
 		h.PatchFeatureFlag,
+		canEditFlags,
 	)
-	testGroup.GET("/features", h.ListFeatureFlags)
+	testGroup.GET("/features", h.ListFeatureFlags, canReadFlags)
+	testGroup.GET("/features/:featureFlagID", h.GetFeatureFlag, canReadFlags)
 	testGroup.PATCH(
 		"/features/:featureFlagID/revisions/:revisionID",
 		h.ApproveRevision,
+		canApproveFlags,
 	)
-	testGroup.DELETE("/features/:featureFlagID", h.DeleteFeatureFlag)
+	testGroup.DELETE("/features/:featureFlagID", h.DeleteFeatureFlag, canEditFlags)
 	testGroup.PATCH(
 		"/features/:featureFlagID/rollback",
 		h.RollbackFeatureFlagVersion,
+		canApproveFlags,
 	)
-	testGroup.PATCH("/features/:featureFlagID/toggle", h.ToggleFeatureFlag)
-	testGroup.PATCH("/features/:featureFlagID/tags", h.PatchFeatureFlagTags)
+	testGroup.PATCH("/features/:featureFlagID/toggle", h.ToggleFeatureFlag, canToggleFlags)
+	testGroup.PATCH("/features/:featureFlagID/tags", h.PatchFeatureFlagTags, canEditFlags)
+	testGroup.PATCH("/features/:featureFlagID/name", h.PatchFeatureFlagName, canEditFlags)
 }
 
 func (suite *FeatureFlagHandlerTestSuite) AfterTest(_, _ string) {
@@ -93,6 +107,7 @@ func (suite *FeatureFlagHandlerTestSuite) TestPostFeatureFlagSuccess() {
 		IsEnabled: true,
 	}
 	featureFlagRequest := handlers.PostFeatureFlagRequest{
+		Key:          "cool-feature",
 		Name:         "cool feature",
 		Type:         featureflagmodel.Boolean,
 		DefaultValue: "true",
@@ -134,6 +149,8 @@ func (suite *FeatureFlagHandlerTestSuite) TestPostFeatureFlagSuccess() {
 	assert.Equal(t, http.StatusCreated, recorder.Code)
 	assert.Equal(t, user.ID, response.UserID)
 	assert.Equal(t, organization.ID, response.OrganizationID)
+	assert.Equal(t, featureFlagRequest.Key, response.Key)
+	assert.Equal(t, featureFlagRequest.Name, response.Name)
 	assert.Equal(t, featureFlagRequest.Type, response.Type)
 	assert.Equal(t, featureFlagRequest.Environment, response.Environments[0].Name)
 	assert.NotEmpty(t, response.Tags)
@@ -160,11 +177,149 @@ func (suite *FeatureFlagHandlerTestSuite) TestPostFeatureFlagSuccess() {
 	assert.Equal(t, rule.IsEnabled, responseRule.IsEnabled)
 
 	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord, err := timelineModel.FindByID(context.Background(), response.ID)
+	timelineRecords, err := timelineModel.FindMany(
+		context.Background(),
+		organization.ID,
+		bson.D{{Key: "feature_flag_id", Value: response.ID}},
+		10,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(timelineRecords))
+	assert.Equal(t, timelinemodel.FeatureFlagCreated, timelineRecords[0].EventType)
+	assert.Equal(t, user.ID, timelineRecords[0].ActorID)
+	assert.Nil(t, timelineRecords[0].Before)
+	assert.Equal(t, featureFlagRequest.Key, timelineRecords[0].After.Key)
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestPostFeatureFlagKeyConflict() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Admin,
+		),
+	}, nil, suite.db)
+	existingFeatureFlag := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 1,
+		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
+
+	featureFlagRequest := handlers.PostFeatureFlagRequest{
+		Key:          existingFeatureFlag.Key,
+		Name:         "another cool feature",
+		Type:         featureflagmodel.Boolean,
+		DefaultValue: "true",
+		Environment:  "prod",
+	}
+	requestBody, err := json.Marshal(featureFlagRequest)
+	assert.NoError(t, err)
+
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(timelineRecord.Entries))
-	assert.Equal(t, timelinemodel.Created, timelineRecord.Entries[0].Action)
-	assert.Equal(t, user.ID, timelineRecord.Entries[0].UserID)
+
+	request := httptest.NewRequest(
+		http.MethodPost,
+		"/features",
+		bytes.NewBuffer(requestBody),
+	)
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+	recorder := httptest.NewRecorder()
+
+	suite.Server.ServeHTTP(recorder, request)
+
+	var response apierrors.Error
+
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Equal(t, apierrors.Error{
+		Error:   http.StatusText(http.StatusConflict),
+		Message: apierrors.FlagKeyConflictError,
+	}, response)
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestPostFeatureFlagInvalidKey() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Admin,
+		),
+	}, nil, suite.db)
+
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	// Flags are looked up by ID or key, so keys can't look like IDs
+	for _, key := range []string{"Cool Feature", primitive.NewObjectID().Hex()} {
+		featureFlagRequest := handlers.PostFeatureFlagRequest{
+			Key:          key,
+			Name:         "cool feature",
+			Type:         featureflagmodel.Boolean,
+			DefaultValue: "true",
+			Environment:  "prod",
+		}
+		requestBody, err := json.Marshal(featureFlagRequest)
+		assert.NoError(t, err)
+
+		request := httptest.NewRequest(
+			http.MethodPost,
+			"/features",
+			bytes.NewBuffer(requestBody),
+		)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+		request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		recorder := httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, key)
+	}
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestPatchFeatureFlagNameSuccess() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Collaborator,
+		),
+	}, nil, suite.db)
+	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 1,
+		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
+
+	requestBody, err := json.Marshal(handlers.PatchFeatureFlagNameRequest{Name: "Cooler feature"})
+	assert.NoError(t, err)
+
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	request := httptest.NewRequest(
+		http.MethodPatch,
+		"/features/"+featureFlagRecord.ID.Hex()+"/name",
+		bytes.NewBuffer(requestBody),
+	)
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+	recorder := httptest.NewRecorder()
+
+	suite.Server.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	model := featureflagmodel.New(suite.db)
+	savedFeatureFlag, err := model.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Cooler feature", savedFeatureFlag.Name)
+	assert.Equal(t, "cooler feature", savedFeatureFlag.SearchName)
+	assert.Equal(t, featureFlagRecord.Key, savedFeatureFlag.Key)
 }
 
 func (suite *FeatureFlagHandlerTestSuite) TestPostFeatureFlagUnauthorized() {
@@ -231,14 +386,6 @@ func (suite *FeatureFlagHandlerTestSuite) TestPatchFeatureFlagSuccess() {
 		},
 	}
 
-	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord := &timelinemodel.TimelineRecord{
-		FeatureFlagID: featureFlagRecord.ID,
-		Entries:       []timelinemodel.TimelineEntry{},
-	}
-	_, err := timelineModel.InsertOne(context.Background(), timelineRecord)
-	assert.NoError(t, err)
-
 	requestBody, err := json.Marshal(revisionRule)
 	assert.NoError(t, err)
 
@@ -266,7 +413,7 @@ func (suite *FeatureFlagHandlerTestSuite) TestPatchFeatureFlagSuccess() {
 	assert.Equal(t, revisionRule.DefaultValue, response.DefaultValue)
 	assert.Equal(t, featureflagmodel.Draft, response.Status)
 
-	savedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), featureFlagRecord.ID)
+	savedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
 	assert.NoError(t, err)
 	assert.NotEqual(t, featureFlagRecord.UpdatedAt, savedFeatureFlag.UpdatedAt)
 	savedRevisions := savedFeatureFlag.Revisions
@@ -295,11 +442,17 @@ func (suite *FeatureFlagHandlerTestSuite) TestPatchFeatureFlagSuccess() {
 	assert.Equal(t, newRule.Env, newSavedRule.Env)
 	assert.Equal(t, newRule.IsEnabled, newSavedRule.IsEnabled)
 
-	savedTimeline, err := timelineModel.FindByID(context.Background(), featureFlagRecord.ID)
+	timelineModel := timelinemodel.New(suite.db)
+	savedTimeline, err := timelineModel.FindMany(
+		context.Background(),
+		organization.ID,
+		bson.D{{Key: "feature_flag_id", Value: featureFlagRecord.ID}},
+		10,
+	)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(savedTimeline.Entries))
-	assert.Equal(t, timelinemodel.RevisionCreated, savedTimeline.Entries[0].Action)
-	assert.Equal(t, user.ID, savedTimeline.Entries[0].UserID)
+	assert.Equal(t, 1, len(savedTimeline))
+	assert.Equal(t, timelinemodel.RevisionCreated, savedTimeline[0].EventType)
+	assert.Equal(t, user.ID, savedTimeline[0].ActorID)
 }
 
 func (suite *FeatureFlagHandlerTestSuite) TestPatchFeatureFlagUnauthorized() {
@@ -395,7 +548,7 @@ func (suite *FeatureFlagHandlerTestSuite) TestListFeatureFlagsPagination() {
 	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
 	assert.NoError(t, err)
 
-	fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 1,
+	firstFeatureFlag := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 1,
 		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
 	featureFlag := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature 2", 1,
 		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
@@ -420,10 +573,167 @@ func (suite *FeatureFlagHandlerTestSuite) TestListFeatureFlagsPagination() {
 		Data: []featureflagmodel.FeatureFlagRecord{
 			*featureFlag,
 		},
-		Page:     1,
-		PageSize: 1,
-		Total:    1,
+		Page:       1,
+		PageSize:   1,
+		Total:      2,
+		NextCursor: response.NextCursor,
 	}, response)
+	assert.NotEmpty(t, response.NextCursor)
+
+	request = httptest.NewRequest(
+		http.MethodGet,
+		"/features?page_size=1&cursor="+response.NextCursor,
+		nil,
+	)
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+	recorder = httptest.NewRecorder()
+
+	// Flags created while paging must not shift the next page
+	fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature 3", 1,
+		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
+
+	suite.Server.ServeHTTP(recorder, request)
+
+	var nextResponse handlers.ListFeatureFlagResponse
+
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &nextResponse))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []featureflagmodel.FeatureFlagRecord{*firstFeatureFlag}, nextResponse.Data)
+
+	// Cursors only continue the sort they were built for
+	for _, sort := range []string{"created_at", "name"} {
+		request = httptest.NewRequest(
+			http.MethodGet,
+			"/features?page_size=1&sort="+sort+"&cursor="+response.NextCursor,
+			nil,
+		)
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+		request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		recorder = httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestListFeatureFlagsInvalidCursor() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Admin,
+		),
+	}, nil, suite.db)
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	request := httptest.NewRequest(
+		http.MethodGet,
+		"/features?cursor=not-a-cursor",
+		nil,
+	)
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+	recorder := httptest.NewRecorder()
+
+	suite.Server.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestListFeatureFlagsFilters() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	otherUser := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Admin,
+		),
+	}, nil, suite.db)
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	project := organization.Projects[0]
+	checkout := fixtures.CreateFeatureFlag(user.ID, organization.ID, "new checkout", 1,
+		featureflagmodel.Boolean, nil, []featureflagmodel.FeatureFlagEnvironment{
+			{Name: "prod", IsEnabled: true},
+		}, &project, []string{"payments", "web"}, suite.db)
+	banner := fixtures.CreateFeatureFlag(otherUser.ID, organization.ID, "banner color", 1,
+		featureflagmodel.String, nil, []featureflagmodel.FeatureFlagEnvironment{
+			{Name: "prod", IsEnabled: false},
+		}, nil, []string{"web"}, suite.db)
+
+	testCases := []struct {
+		query    string
+		expected []featureflagmodel.FeatureFlagRecord
+	}{
+		{query: "tag=web&sort=name", expected: []featureflagmodel.FeatureFlagRecord{*banner, *checkout}},
+		{query: "tag=web&tag=payments", expected: []featureflagmodel.FeatureFlagRecord{*checkout}},
+		{query: "project=" + project.ID.Hex(), expected: []featureflagmodel.FeatureFlagRecord{*checkout}},
+		{query: "type=string", expected: []featureflagmodel.FeatureFlagRecord{*banner}},
+		{query: "environment=prod&enabled=true", expected: []featureflagmodel.FeatureFlagRecord{*checkout}},
+		{query: "created_by=" + otherUser.ID.Hex(), expected: []featureflagmodel.FeatureFlagRecord{*banner}},
+		{query: "search=NEW", expected: []featureflagmodel.FeatureFlagRecord{*checkout}},
+	}
+
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(
+			http.MethodGet,
+			"/features?"+testCase.query,
+			nil,
+		)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+		request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		recorder := httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		var response handlers.ListFeatureFlagResponse
+
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, http.StatusOK, recorder.Code, testCase.query)
+		assert.Equal(t, testCase.expected, response.Data, testCase.query)
+		assert.Equal(t, len(testCase.expected), response.Total, testCase.query)
+	}
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestListFeatureFlagsInvalidFilter() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Admin,
+		),
+	}, nil, suite.db)
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	for _, query := range []string{"sort=popularity", "enabled=true", "project=not-an-id"} {
+		request := httptest.NewRequest(
+			http.MethodGet,
+			"/features?"+query,
+			nil,
+		)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+		request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		recorder := httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+	}
 }
 
 func (suite *FeatureFlagHandlerTestSuite) TestListFeatureFlagsUnauthorized() {
@@ -468,6 +778,209 @@ func (suite *FeatureFlagHandlerTestSuite) TestListFeatureFlagsUnauthorized() {
 	}, response)
 }
 
+func (suite *FeatureFlagHandlerTestSuite) TestGetFeatureFlagSuccess() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.ReadOnly,
+		),
+	}, nil, suite.db)
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	liveRevision := fixtures.CreateRevision(user.ID, featureflagmodel.Live, nil)
+	draftRevision := fixtures.CreateRevision(user.ID, featureflagmodel.Draft, nil)
+	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 1,
+		featureflagmodel.Boolean, []featureflagmodel.Revision{
+			*liveRevision,
+			*draftRevision,
+		}, nil, nil, nil, suite.db)
+
+	fixtures.CreateTimelineRecord(organization.ID, featureFlagRecord.ID, user.ID,
+		timelinemodel.FeatureFlagCreated, suite.db)
+	fixtures.CreateTimelineRecord(organization.ID, featureFlagRecord.ID, user.ID,
+		timelinemodel.RevisionCreated, suite.db)
+
+	for _, identifier := range []string{featureFlagRecord.ID.Hex(), featureFlagRecord.Key} {
+		request := httptest.NewRequest(
+			http.MethodGet,
+			"/features/"+url.PathEscape(identifier),
+			nil,
+		)
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+		request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		recorder := httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		var response handlers.GetFeatureFlagResponse
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, featureFlagRecord.ID, response.ID)
+		assert.Equal(t, featureFlagRecord.Environments, response.Environments)
+		assert.Equal(t, liveRevision.ID, response.LiveRevision.ID)
+		assert.Equal(t, 1, len(response.Drafts))
+		assert.Equal(t, draftRevision.ID, response.Drafts[0].ID)
+		assert.Equal(t, 2, len(response.Timeline))
+		assert.Equal(t, timelinemodel.RevisionCreated, response.Timeline[0].EventType)
+		assert.Equal(t, timelinemodel.FeatureFlagCreated, response.Timeline[1].EventType)
+	}
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestGetFeatureFlagByMigratedKey() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.ReadOnly,
+		),
+	}, nil, suite.db)
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	// Flags created before keys existed
+	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "Cool Feature!", 1,
+		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
+	_, err = suite.db.Collection(featureflagmodel.FeatureFlagCollectionName).UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: featureFlagRecord.ID}},
+		bson.D{{Key: "$unset", Value: bson.M{"key": ""}}},
+	)
+	assert.NoError(t, err)
+
+	logger, _ := logger.NewZapLogger()
+	assert.NoError(t, migrations.Run(context.Background(), suite.db, logger))
+
+	request := httptest.NewRequest(http.MethodGet, "/features/cool-feature", nil)
+	request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+	recorder := httptest.NewRecorder()
+
+	suite.Server.ServeHTTP(recorder, request)
+
+	var response handlers.GetFeatureFlagResponse
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, featureFlagRecord.ID, response.ID)
+	assert.Equal(t, "cool-feature", response.Key)
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestGetFeatureFlagFromAnotherOrganization() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Admin,
+		),
+	}, nil, suite.db)
+	otherOrganization := fixtures.CreateOrganization("other company", fixtures.EmptyMemberTupleList, nil, suite.db)
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, otherOrganization.ID, "cool feature", 1,
+		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
+
+	request := httptest.NewRequest(
+		http.MethodGet,
+		"/features/"+featureFlagRecord.ID.Hex(),
+		nil,
+	)
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+	recorder := httptest.NewRecorder()
+
+	suite.Server.ServeHTTP(recorder, request)
+
+	var response apierrors.Error
+
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, apierrors.Error{
+		Error:   http.StatusText(http.StatusNotFound),
+		Message: apierrors.NotFoundError,
+	}, response)
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestChangeFeatureFlagFromAnotherOrganization() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Admin,
+		),
+	}, nil, suite.db)
+	otherOrganization := fixtures.CreateOrganization("other company", fixtures.EmptyMemberTupleList, nil, suite.db)
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, otherOrganization.ID, "cool feature", 1,
+		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
+
+	path := "/features/" + featureFlagRecord.ID.Hex()
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPatch, path, `{"default_value": "true"}`},
+		{http.MethodPatch, path + "/revisions/" + featureFlagRecord.Revisions[0].ID.Hex(), ""},
+		{http.MethodPatch, path + "/rollback", ""},
+		{http.MethodPatch, path + "/toggle?env=prod", ""},
+		{http.MethodPatch, path + "/tags", `{"tags": ["stolen"]}`},
+		{http.MethodPatch, path + "/name", `{"name": "stolen feature"}`},
+		{http.MethodDelete, path, ""},
+	}
+
+	for _, r := range requests {
+		request := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+		request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		recorder := httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		var response apierrors.Error
+
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, http.StatusNotFound, recorder.Code, r.method+" "+r.path)
+		assert.Equal(t, apierrors.Error{
+			Error:   http.StatusText(http.StatusNotFound),
+			Message: apierrors.NotFoundError,
+		}, response)
+	}
+
+	model := featureflagmodel.New(suite.db)
+	savedFeatureFlag, err := model.FindByID(context.Background(), otherOrganization.ID, featureFlagRecord.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, featureFlagRecord.Version, savedFeatureFlag.Version)
+	assert.Equal(t, featureFlagRecord.Name, savedFeatureFlag.Name)
+	assert.Equal(t, featureFlagRecord.Tags, savedFeatureFlag.Tags)
+	assert.Equal(t, featureFlagRecord.Environments, savedFeatureFlag.Environments)
+	assert.Equal(t, featureFlagRecord.Revisions, savedFeatureFlag.Revisions)
+
+	_, err = model.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+	err = model.UpdateOne(context.Background(), organization.ID, featureFlagRecord.ID, bson.D{
+		{Key: "$set", Value: bson.M{"name": "stolen feature"}},
+	})
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+}
+
 func (suite *FeatureFlagHandlerTestSuite) TestRevisionStatusUpdateSuccess() {
 	t := suite.T()
 	user := fixtures.CreateUser("", "", "", "", suite.db)
@@ -489,14 +1002,6 @@ func (suite *FeatureFlagHandlerTestSuite) TestRevisionStatusUpdateSuccess() {
 			*willBeControlRevision,
 		}, nil, nil, nil, suite.db)
 
-	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord := &timelinemodel.TimelineRecord{
-		FeatureFlagID: featureFlagRecord.ID,
-		Entries:       []timelinemodel.TimelineEntry{},
-	}
-	_, err := timelineModel.InsertOne(context.Background(), timelineRecord)
-	assert.NoError(t, err)
-
 	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
 	assert.NoError(t, err)
 
@@ -515,7 +1020,7 @@ func (suite *FeatureFlagHandlerTestSuite) TestRevisionStatusUpdateSuccess() {
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
 	model := featureflagmodel.New(suite.db)
-	savedFeatureFlag, err := model.FindByID(context.Background(), featureFlagRecord.ID)
+	savedFeatureFlag, err := model.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
 	assert.NoError(t, err)
 
 	savedRevisions := savedFeatureFlag.Revisions
@@ -529,11 +1034,17 @@ func (suite *FeatureFlagHandlerTestSuite) TestRevisionStatusUpdateSuccess() {
 	controlRevision := savedRevisions[2]
 	assert.Equal(t, featureflagmodel.Draft, controlRevision.Status)
 
-	savedTimeline, err := timelineModel.FindByID(context.Background(), featureFlagRecord.ID)
+	timelineModel := timelinemodel.New(suite.db)
+	savedTimeline, err := timelineModel.FindMany(
+		context.Background(),
+		organization.ID,
+		bson.D{{Key: "feature_flag_id", Value: featureFlagRecord.ID}},
+		10,
+	)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(savedTimeline.Entries))
-	assert.Equal(t, timelinemodel.RevisionApproved, savedTimeline.Entries[0].Action)
-	assert.Equal(t, user.ID, savedTimeline.Entries[0].UserID)
+	assert.Equal(t, 1, len(savedTimeline))
+	assert.Equal(t, timelinemodel.RevisionApproved, savedTimeline[0].EventType)
+	assert.Equal(t, user.ID, savedTimeline[0].ActorID)
 }
 
 func (suite *FeatureFlagHandlerTestSuite) TestRevisionUpdateUnauthorized() {
@@ -568,10 +1079,10 @@ func (suite *FeatureFlagHandlerTestSuite) TestRevisionUpdateUnauthorized() {
 	var response apierrors.Error
 
 	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
-	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
 	assert.Equal(t, apierrors.Error{
-		Error:   http.StatusText(http.StatusUnauthorized),
-		Message: apierrors.UnauthorizedError,
+		Error:   http.StatusText(http.StatusForbidden),
+		Message: apierrors.ForbiddenError,
 	}, response)
 }
 
@@ -610,10 +1121,10 @@ func (suite *FeatureFlagHandlerTestSuite) TestRevisionUpdateUnauthorizedMissingP
 	var response apierrors.Error
 
 	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
-	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
 	assert.Equal(t, apierrors.Error{
-		Error:   http.StatusText(http.StatusUnauthorized),
-		Message: apierrors.UnauthorizedError,
+		Error:   http.StatusText(http.StatusForbidden),
+		Message: apierrors.ForbiddenError,
 	}, response)
 }
 
@@ -632,14 +1143,6 @@ func (suite *FeatureFlagHandlerTestSuite) TestRollbackSuccess() {
 	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 2,
 		featureflagmodel.Boolean, []featureflagmodel.Revision{*revision, *wrongRevision}, nil, nil, nil, suite.db)
 
-	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord := &timelinemodel.TimelineRecord{
-		FeatureFlagID: featureFlagRecord.ID,
-		Entries:       []timelinemodel.TimelineEntry{},
-	}
-	_, err := timelineModel.InsertOne(context.Background(), timelineRecord)
-	assert.NoError(t, err)
-
 	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
 	assert.NoError(t, err)
 
@@ -659,7 +1162,7 @@ func (suite *FeatureFlagHandlerTestSuite) TestRollbackSuccess() {
 	assert.Equal(t, http.StatusOK, recorder.Code)
 
 	featureFlagModel := featureflagmodel.New(suite.db)
-	savedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), featureFlagRecord.ID)
+	savedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
 	assert.NoError(t, err)
 
 	savedRevisions := savedFeatureFlag.Revisions
@@ -671,11 +1174,17 @@ func (suite *FeatureFlagHandlerTestSuite) TestRollbackSuccess() {
 	rolledBackRevision := savedRevisions[1]
 	assert.Equal(t, featureflagmodel.Draft, rolledBackRevision.Status)
 
-	savedTimeline, err := timelineModel.FindByID(context.Background(), featureFlagRecord.ID)
+	timelineModel := timelinemodel.New(suite.db)
+	savedTimeline, err := timelineModel.FindMany(
+		context.Background(),
+		organization.ID,
+		bson.D{{Key: "feature_flag_id", Value: featureFlagRecord.ID}},
+		10,
+	)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(savedTimeline.Entries))
-	assert.Equal(t, timelinemodel.FeatureFlagRollback, savedTimeline.Entries[0].Action)
-	assert.Equal(t, user.ID, savedTimeline.Entries[0].UserID)
+	assert.Equal(t, 1, len(savedTimeline))
+	assert.Equal(t, timelinemodel.FeatureFlagRollback, savedTimeline[0].EventType)
+	assert.Equal(t, user.ID, savedTimeline[0].ActorID)
 }
 
 func (suite *FeatureFlagHandlerTestSuite) TestRollbackUnauthorized() {
@@ -773,14 +1282,6 @@ func (suite *FeatureFlagHandlerTestSuite) TestFeatureFlagDeletionSuccess() {
 	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 2,
 		featureflagmodel.Boolean, []featureflagmodel.Revision{*revision}, nil, nil, nil, suite.db)
 
-	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord := &timelinemodel.TimelineRecord{
-		FeatureFlagID: featureFlagRecord.ID,
-		Entries:       []timelinemodel.TimelineEntry{},
-	}
-	_, err := timelineModel.InsertOne(context.Background(), timelineRecord)
-	assert.NoError(t, err)
-
 	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
 	assert.NoError(t, err)
 
@@ -798,7 +1299,7 @@ func (suite *FeatureFlagHandlerTestSuite) TestFeatureFlagDeletionSuccess() {
 
 	model := featureflagmodel.New(suite.db)
 
-	deletedRecord, err := model.FindOne(context.Background(), bson.D{
+	deletedRecord, err := model.FindOne(context.Background(), organization.ID, bson.D{
 		{Key: "_id", Value: featureFlagRecord.ID},
 		{Key: "deleted_at", Value: bson.M{
 			"$exists": true},
@@ -809,11 +1310,17 @@ func (suite *FeatureFlagHandlerTestSuite) TestFeatureFlagDeletionSuccess() {
 	assert.Equal(t, featureFlagRecord.ID, deletedRecord.ID)
 	assert.Equal(t, http.StatusNoContent, recorder.Code)
 
-	savedTimeline, err := timelineModel.FindByID(context.Background(), featureFlagRecord.ID)
+	timelineModel := timelinemodel.New(suite.db)
+	savedTimeline, err := timelineModel.FindMany(
+		context.Background(),
+		organization.ID,
+		bson.D{{Key: "feature_flag_id", Value: featureFlagRecord.ID}},
+		10,
+	)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(savedTimeline.Entries))
-	assert.Equal(t, timelinemodel.FeatureFlagDeleted, savedTimeline.Entries[0].Action)
-	assert.Equal(t, user.ID, savedTimeline.Entries[0].UserID)
+	assert.Equal(t, 1, len(savedTimeline))
+	assert.Equal(t, timelinemodel.FeatureFlagDeleted, savedTimeline[0].EventType)
+	assert.Equal(t, user.ID, savedTimeline[0].ActorID)
 }
 
 func (suite *FeatureFlagHandlerTestSuite) TestFeatureFlagDeletionForbidden() {
@@ -875,14 +1382,6 @@ func (suite *FeatureFlagHandlerTestSuite) TestEnvironmentToggleSuccess() {
 			},
 		}, nil, nil, suite.db)
 
-	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord := &timelinemodel.TimelineRecord{
-		FeatureFlagID: featureFlagRecord.ID,
-		Entries:       []timelinemodel.TimelineEntry{},
-	}
-	_, err := timelineModel.InsertOne(context.Background(), timelineRecord)
-	assert.NoError(t, err)
-
 	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
 	assert.NoError(t, err)
 
@@ -902,18 +1401,69 @@ func (suite *FeatureFlagHandlerTestSuite) TestEnvironmentToggleSuccess() {
 	assert.Equal(t, http.StatusOK, recorder.Code)
 
 	featureFlagModel := featureflagmodel.New(suite.db)
-	savedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), featureFlagRecord.ID)
+	savedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, featureFlagRecord.Environments[0].Name, savedFeatureFlag.Environments[0].Name)
 	assert.Equal(t, false, savedFeatureFlag.Environments[0].IsEnabled)
 	assert.Equal(t, featureFlagRecord.Environments[1].Name, savedFeatureFlag.Environments[1].Name)
 	assert.Equal(t, featureFlagRecord.Environments[1].IsEnabled, savedFeatureFlag.Environments[1].IsEnabled)
 
-	savedTimeline, err := timelineModel.FindByID(context.Background(), featureFlagRecord.ID)
+	timelineModel := timelinemodel.New(suite.db)
+	savedTimeline, err := timelineModel.FindMany(
+		context.Background(),
+		organization.ID,
+		bson.D{{Key: "feature_flag_id", Value: featureFlagRecord.ID}},
+		10,
+	)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(savedTimeline.Entries))
-	assert.Equal(t, fmt.Sprintf(timelinemodel.FeatureFlagToggle, "prod"), savedTimeline.Entries[0].Action)
-	assert.Equal(t, user.ID, savedTimeline.Entries[0].UserID)
+	assert.Equal(t, 1, len(savedTimeline))
+	assert.Equal(t, timelinemodel.FeatureFlagToggled, savedTimeline[0].EventType)
+	assert.Equal(t, "prod", savedTimeline[0].Environment)
+	assert.NotEqual(t, savedTimeline[0].Before.Environments, savedTimeline[0].After.Environments)
+	assert.Equal(t, user.ID, savedTimeline[0].ActorID)
+}
+
+func (suite *FeatureFlagHandlerTestSuite) TestEnvironmentToggleRollsBackWhenTimelineFails() {
+	t := suite.T()
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			user,
+			organizationmodel.Collaborator,
+		),
+	}, nil, suite.db)
+
+	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 2,
+		featureflagmodel.Boolean, nil, nil, nil, nil, suite.db)
+
+	// Every insert into the timeline is rejected by its validator
+	err := suite.db.CreateCollection(
+		context.Background(),
+		timelinemodel.TimelineCollectionName,
+		options.CreateCollection().SetValidator(bson.M{"_id": bson.M{"$exists": false}}),
+	)
+	assert.NoError(t, err)
+
+	recorder := fixtures.Request(
+		suite.Server,
+		http.MethodPatch,
+		"/features/"+featureFlagRecord.ID.Hex()+"/toggle?env=prod",
+		nil,
+		fixtures.CreateSession(user.ID),
+		organization.ID.Hex(),
+	)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	featureFlagModel := featureflagmodel.New(suite.db)
+	savedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, true, savedFeatureFlag.Environments[0].IsEnabled)
+	assert.Equal(t, featureFlagRecord.Version, savedFeatureFlag.Version)
+
+	count, err := suite.db.Collection(timelinemodel.TimelineCollectionName).CountDocuments(context.Background(), bson.D{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
 }
 
 func (suite *FeatureFlagHandlerTestSuite) TestEnvironmentToggleUnauthorized() {
@@ -1012,13 +1562,6 @@ func (suite *FeatureFlagHandlerTestSuite) TestPatchTagSuccess() {
 	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 1,
 		featureflagmodel.Boolean, []featureflagmodel.Revision{*revision}, nil, nil, nil, suite.db)
 
-	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord := &timelinemodel.TimelineRecord{
-		FeatureFlagID: featureFlagRecord.ID,
-		Entries:       []timelinemodel.TimelineEntry{},
-	}
-	_, err := timelineModel.InsertOne(context.Background(), timelineRecord)
-	assert.NoError(t, err)
 	expected := []string{"fizi", "valores"}
 	requestBody, err := json.Marshal(handlers.PatchFeatureFlagTagsRequest{
 		Tags: expected,
@@ -1043,7 +1586,7 @@ func (suite *FeatureFlagHandlerTestSuite) TestPatchTagSuccess() {
 	assert.Equal(t, http.StatusNoContent, recorder.Code)
 
 	model := featureflagmodel.New(suite.db)
-	updatedFlag, err := model.FindByID(context.Background(), featureFlagRecord.ID)
+	updatedFlag, err := model.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, expected, updatedFlag.Tags)
 	assert.NotEqual(t, featureFlagRecord.UpdatedAt, updatedFlag.UpdatedAt)
@@ -1070,14 +1613,6 @@ func (suite *FeatureFlagHandlerTestSuite) TestPatchTagForbidden() {
 	featureFlagRecord := fixtures.CreateFeatureFlag(user.ID, organization.ID, "cool feature", 1,
 		featureflagmodel.Boolean, []featureflagmodel.Revision{*revision}, nil, nil, nil, suite.db)
 
-	timelineModel := timelinemodel.New(suite.db)
-	timelineRecord := &timelinemodel.TimelineRecord{
-		FeatureFlagID: featureFlagRecord.ID,
-		Entries:       []timelinemodel.TimelineEntry{},
-	}
-	_, err := timelineModel.InsertOne(context.Background(), timelineRecord)
-	assert.NoError(t, err)
-
 	requestBody, err := json.Marshal(handlers.PatchFeatureFlagTagsRequest{
 		Tags: []string{"fizi", "valores"},
 	})
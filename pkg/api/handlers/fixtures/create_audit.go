@@ -0,0 +1,26 @@
+package fixtures
+
+import (
+	"context"
+	"net/http"
+
+	auditmodel "github.com/Roll-Play/togglelabs/pkg/models/audit"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateAuditRecord(
+	organizationID,
+	actorID primitive.ObjectID,
+	action string,
+	db *mongo.Database,
+) *auditmodel.AuditRecord {
+	model := auditmodel.New(db)
+	record := auditmodel.NewAuditRecord(organizationID, &actorID, action, nil, http.StatusOK, "127.0.0.1", "", "")
+
+	if err := model.Append(context.Background(), record); err != nil {
+		panic(err)
+	}
+
+	return record
+}
@@ -3,6 +3,7 @@ package fixtures
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Roll-Play/togglelabs/pkg/models"
@@ -54,7 +55,9 @@ func CreateFeatureFlag(
 		OrganizationID: organizationID,
 		UserID:         userID,
 		Version:        version,
+		Key:            strings.ReplaceAll(name, " ", "-"),
 		Name:           name,
+		SearchName:     featureflagmodel.SearchName(name),
 		Type:           flagType,
 		Revisions:      revision,
 		Tags:           tags,
@@ -0,0 +1,38 @@
+package fixtures
+
+import (
+	"context"
+
+	notificationmodel "github.com/Roll-Play/togglelabs/pkg/models/notification"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateNotificationIntegration(
+	organizationID,
+	userID primitive.ObjectID,
+	url string,
+	format notificationmodel.Format,
+	projects []primitive.ObjectID,
+	environments []string,
+	db *mongo.Database,
+) *notificationmodel.IntegrationRecord {
+	model := notificationmodel.New(db)
+	record := notificationmodel.NewIntegrationRecord(
+		organizationID,
+		"release channel",
+		url,
+		format,
+		nil,
+		projects,
+		environments,
+		userID,
+	)
+
+	_, err := model.InsertOne(context.Background(), record)
+	if err != nil {
+		panic(err)
+	}
+
+	return record
+}
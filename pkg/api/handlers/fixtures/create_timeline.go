@@ -0,0 +1,27 @@
+package fixtures
+
+import (
+	"context"
+
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateTimelineRecord(
+	organizationID,
+	featureFlagID,
+	actorID primitive.ObjectID,
+	eventType timelinemodel.EventType,
+	db *mongo.Database,
+) *timelinemodel.TimelineRecord {
+	model := timelinemodel.New(db)
+	record := timelinemodel.NewTimelineRecord(organizationID, featureFlagID, actorID, eventType)
+
+	_, err := model.InsertOne(context.Background(), record)
+	if err != nil {
+		panic(err)
+	}
+
+	return record
+}
@@ -0,0 +1,27 @@
+package fixtures
+
+import (
+	"context"
+
+	webhookmodel "github.com/Roll-Play/togglelabs/pkg/models/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateWebhook(
+	organizationID,
+	userID primitive.ObjectID,
+	url string,
+	events []string,
+	db *mongo.Database,
+) *webhookmodel.WebhookRecord {
+	model := webhookmodel.New(db)
+	record := webhookmodel.NewWebhookRecord(organizationID, url, "", events, "whsec_test", userID)
+
+	_, err := model.InsertOne(context.Background(), record)
+	if err != nil {
+		panic(err)
+	}
+
+	return record
+}
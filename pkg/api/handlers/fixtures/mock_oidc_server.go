@@ -0,0 +1,212 @@
+package fixtures
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/oidc"
+	"github.com/golang-jwt/jwt"
+)
+
+const mockOIDCKeyID = "mock-key"
+
+// MockOIDCServer is an OIDC provider that signs every user in right away:
+// its authorization endpoint redirects back with a code, which the token
+// endpoint exchanges for an ID token carrying the configured claims.
+type MockOIDCServer struct {
+	*httptest.Server
+	ClientID string
+	key      *rsa.PrivateKey
+	mu       sync.Mutex
+	claims   jwt.MapClaims
+	signer   *rsa.PrivateKey
+	logins   map[string]mockOIDCLogin
+}
+
+type mockOIDCLogin struct {
+	challenge string
+	nonce     string
+}
+
+func NewMockOIDCServer(clientID string) *MockOIDCServer {
+	return newMockOIDCServer(clientID, httptest.NewServer)
+}
+
+// NewMockOIDCTLSServer serves the provider over https, its Client trusts the
+// certificate of the server.
+func NewMockOIDCTLSServer(clientID string) *MockOIDCServer {
+	return newMockOIDCServer(clientID, httptest.NewTLSServer)
+}
+
+func newMockOIDCServer(clientID string, serve func(http.Handler) *httptest.Server) *MockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	server := &MockOIDCServer{
+		ClientID: clientID,
+		key:      key,
+		signer:   key,
+		logins:   make(map[string]mockOIDCLogin),
+		claims: jwt.MapClaims{
+			"sub":            "12345",
+			"email":          "test@test.com",
+			"email_verified": true,
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidc.DiscoveryPath, server.discovery)
+	mux.HandleFunc("/jwks", server.jwks)
+	mux.HandleFunc("/authorize", server.authorize)
+	mux.HandleFunc("/token", server.token)
+	server.Server = serve(mux)
+
+	return server
+}
+
+func (s *MockOIDCServer) Config() oidc.Config {
+	return oidc.Config{
+		Issuer:       s.URL,
+		ClientID:     s.ClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/callback",
+	}
+}
+
+// SetClaims overrides claims of the ID tokens issued from now on.
+func (s *MockOIDCServer) SetClaims(claims map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, value := range claims {
+		s.claims[key] = value
+	}
+}
+
+// SignWith makes the server sign ID tokens with a key it doesn't publish.
+func (s *MockOIDCServer) SignWith(key *rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.signer = key
+}
+
+func (s *MockOIDCServer) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (s *MockOIDCServer) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockOIDCKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize remembers the PKCE challenge and nonce of the login under a new
+// code, and redirects back with it.
+func (s *MockOIDCServer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	s.mu.Lock()
+	s.logins[code] = mockOIDCLogin{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+	}
+	s.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *MockOIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	login, ok := s.logins[r.PostForm.Get("code")]
+	delete(s.logins, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != login.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	s.mu.Lock()
+	claims := jwt.MapClaims{
+		"iss":   s.URL,
+		"aud":   s.ClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": login.nonce,
+	}
+	for key, value := range s.claims {
+		claims[key] = value
+	}
+	signer := s.signer
+	s.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockOIDCKeyID
+	idToken, err := token.SignedString(signer)
+	if err != nil {
+		panic(err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"access_token": "mockAccessToken",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		panic(err)
+	}
+}
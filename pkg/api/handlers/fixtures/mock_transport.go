@@ -0,0 +1,29 @@
+package fixtures
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+)
+
+// MockTransport keeps sent messages in memory.
+type MockTransport struct {
+	lock     sync.Mutex
+	messages []*mailer.Message
+}
+
+func (mt *MockTransport) Send(_ context.Context, message *mailer.Message) error {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	mt.messages = append(mt.messages, message)
+	return nil
+}
+
+func (mt *MockTransport) Messages() []*mailer.Message {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	return append([]*mailer.Message{}, mt.messages...)
+}
@@ -0,0 +1,24 @@
+package fixtures
+
+import (
+	"context"
+	"io"
+)
+
+type MockUploader struct {
+	Key         string
+	ContentType string
+	Body        []byte
+}
+
+func (m *MockUploader) Upload(_ context.Context, key, contentType string, body io.Reader) (string, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+
+	m.Key = key
+	m.ContentType = contentType
+	m.Body = data
+	return "http://localhost:9000/exports/" + key, nil
+}
@@ -0,0 +1,65 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/api/middlewares"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateSession returns a session token of the user.
+func CreateSession(userID primitive.ObjectID) string {
+	token, err := apiutils.CreateJWT(userID, time.Second*120)
+	if err != nil {
+		panic(err)
+	}
+
+	return token
+}
+
+// NewRequest builds a request with body as JSON, in the session of token and
+// the organization with organizationID. Either can be empty to leave them out.
+func NewRequest(method, path string, body interface{}, token, organizationID string) *http.Request {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(requestBody))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	}
+	if organizationID != "" {
+		request.Header.Set(middlewares.XOrganizationHeader, organizationID)
+	}
+
+	return request
+}
+
+// Serve answers the request with server.
+func Serve(server http.Handler, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+// Request builds the request like NewRequest and answers it with server.
+func Request(
+	server http.Handler,
+	method,
+	path string,
+	body interface{},
+	token,
+	organizationID string,
+) *httptest.ResponseRecorder {
+	return Serve(server, NewRequest(method, path, body, token, organizationID))
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// FreezeOverrideHeader carries the reason admins give to change flags during
+// a freeze window.
+const FreezeOverrideHeader = "X-Freeze-Override-Reason"
+
+var EmptyFreezeWindowList = []organizationmodel.FreezeWindow{}
+
+// FreezeWindowHandler manages the periods during which flags of the
+// organization don't change.
+type FreezeWindowHandler struct {
+	db     *mongo.Database
+	logger *zap.Logger
+}
+
+type FreezeWindowRequest struct {
+	Name         string                              `json:"name"`
+	Environments []string                            `json:"environments"`
+	Recurrence   *organizationmodel.WeeklyRecurrence `json:"recurrence"`
+	Start        *time.Time                          `json:"start"`
+	End          *time.Time                          `json:"end"`
+}
+
+func (fwh *FreezeWindowHandler) ListFreezeWindows(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, fwh.logger)
+	if !ok {
+		return nil
+	}
+
+	windows := organization.FreezeWindows
+	if windows == nil {
+		windows = EmptyFreezeWindowList
+	}
+
+	return c.JSON(http.StatusOK, windows)
+}
+
+func (fwh *FreezeWindowHandler) PostFreezeWindow(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, fwh.logger)
+	if !ok {
+		return nil
+	}
+
+	request := new(FreezeWindowRequest)
+	if err := c.Bind(request); err != nil {
+		fwh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	window := organizationmodel.NewFreezeWindow(request.Name, request.Environments)
+	window.Recurrence = request.Recurrence
+	window.Start = request.Start
+	window.End = request.End
+
+	validate := validator.New()
+	if err := validate.Struct(window); err != nil {
+		fwh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if !window.IsValid() {
+		fwh.logger.Debug("Client error",
+			zap.String("freeze_window", window.Name),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	organizationModel := organizationmodel.New(fwh.db)
+	if err := organizationModel.AddFreezeWindow(context.Background(), organization.ID, window); err != nil {
+		fwh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, window)
+}
+
+func (fwh *FreezeWindowHandler) DeleteFreezeWindow(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, fwh.logger)
+	if !ok {
+		return nil
+	}
+
+	windowID, err := primitive.ObjectIDFromHex(c.Param("windowID"))
+	if err != nil {
+		fwh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	organizationModel := organizationmodel.New(fwh.db)
+	if err := organizationModel.RemoveFreezeWindow(context.Background(), organization.ID, windowID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			fwh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
+
+		fwh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	fwh.logger.Info("Freeze window deleted",
+		zap.String("_id", windowID.Hex()),
+	)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkFreezeWindows lets the change to environments through when no freeze
+// window of the organization is active, or when an admin gives a reason to
+// override it. It returns that reason for the timeline. When the change is
+// rejected, the error response has already been written.
+func checkFreezeWindows(
+	c echo.Context,
+	logger *zap.Logger,
+	organization *organizationmodel.OrganizationRecord,
+	userID primitive.ObjectID,
+	environments []string,
+) (string, bool) {
+	now := time.Now()
+	for _, environment := range environments {
+		window := organization.ActiveFreeze(environment, now)
+		if window == nil {
+			continue
+		}
+
+		reason := c.Request().Header.Get(FreezeOverrideHeader)
+		member := organization.Member(userID)
+		if reason != "" && member.PermissionLevel == organizationmodel.Admin {
+			logger.Info("Freeze window overridden",
+				zap.String("freeze_window", window.Name),
+				zap.String("user_id", userID.Hex()),
+				zap.String("reason", reason),
+			)
+			return reason, true
+		}
+
+		_ = policyError(c, logger, &organizationmodel.PolicyViolation{
+			Environment: environment,
+			Policy:      organizationmodel.FreezeWindowPolicy,
+			Message:     "changes are frozen by " + window.Name + ", admins can override it with a reason",
+		})
+		return "", false
+	}
+
+	return "", true
+}
+
+func flagEnvironments(featureFlag *featureflagmodel.FeatureFlagRecord) []string {
+	environments := make([]string, 0, len(featureFlag.Environments))
+	for _, environment := range featureFlag.Environments {
+		environments = append(environments, environment.Name)
+	}
+
+	return environments
+}
+
+func NewFreezeWindowHandler(db *mongo.Database, logger *zap.Logger) *FreezeWindowHandler {
+	return &FreezeWindowHandler{
+		db:     db,
+		logger: logger,
+	}
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	"github.com/Roll-Play/togglelabs/pkg/oidc"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+// ReauthenticationWindow is how long after signing in users without a
+// password may change their sign-in methods.
+const ReauthenticationWindow = 5 * time.Minute
+
+var EmptyIdentityList = []usermodel.Identity{}
+
+type IdentityLinkRequest struct {
+	// Required from users with a password
+	Password string `json:"password"`
+	// Links the provider of this organization instead of the deployment one
+	OrganizationID string `json:"organization_id" validate:"omitempty,mongodb"`
+	RedirectTo     string `json:"redirect_to"`
+}
+
+type IdentityLinkResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type IdentityUnlinkRequest struct {
+	Password string `json:"password"`
+}
+
+func (sh *OAuthHandler) ListIdentities(c echo.Context) error {
+	user, ok := sh.findUser(c)
+	if !ok {
+		return nil
+	}
+
+	identities := user.Identities
+	if identities == nil {
+		identities = EmptyIdentityList
+	}
+
+	return c.JSON(http.StatusOK, identities)
+}
+
+// PostIdentity starts linking an account of a provider to the signed in user.
+// The user signs in with the provider at the authorization URL, and Callback
+// links the account instead of signing in.
+func (sh *OAuthHandler) PostIdentity(c echo.Context) error {
+	request := new(IdentityLinkRequest)
+	if err := c.Bind(request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if !allowedRedirect(request.RedirectTo, sh.redirectAllowlist) {
+		sh.logger.Debug("Client error",
+			zap.String("cause", apierrors.InvalidRedirectError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.InvalidRedirectError,
+		)
+	}
+
+	user, ok := sh.findUser(c)
+	if !ok {
+		return nil
+	}
+
+	if !reauthenticate(c, sh.logger, user, request.Password) {
+		return nil
+	}
+
+	provider, _, ok := sh.organizationProvider(c, request.OrganizationID)
+	if !ok {
+		return nil
+	}
+
+	login, err := newOAuthLogin(request.OrganizationID, request.RedirectTo)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+	login.Link = user.ID.Hex()
+
+	cookie, err := login.cookie()
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+	c.SetCookie(cookie)
+
+	return c.JSON(http.StatusOK, IdentityLinkResponse{
+		AuthorizationURL: provider.AuthCodeURL(
+			login.State,
+			oauth2.S256ChallengeOption(login.Verifier),
+			oauth2.SetAuthURLParam("nonce", login.Nonce),
+		),
+	})
+}
+
+// DeleteIdentity unlinks an identity of the signed in user, as long as they
+// keep another way to sign in.
+func (sh *OAuthHandler) DeleteIdentity(c echo.Context) error {
+	identityID, err := primitive.ObjectIDFromHex(c.Param("identityID"))
+	if err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	request := new(IdentityUnlinkRequest)
+	if err := c.Bind(request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	user, ok := sh.findUser(c)
+	if !ok {
+		return nil
+	}
+
+	if !reauthenticate(c, sh.logger, user, request.Password) {
+		return nil
+	}
+
+	if user.Password == "" && len(user.Identities) < 2 {
+		sh.logger.Debug("Client error",
+			zap.String("cause", apierrors.LastSignInMethodError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusConflict,
+			apierrors.LastSignInMethodError,
+		)
+	}
+
+	userModel := usermodel.New(sh.db)
+	if err := userModel.RemoveIdentity(context.Background(), user.ID, identityID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
+
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// linkIdentity finishes a login started by PostIdentity, linking the account
+// of the provider to the user who started it.
+func (sh *OAuthHandler) linkIdentity(c echo.Context, login *oauthLogin, claims *oidc.Claims) error {
+	userID, err := primitive.ObjectIDFromHex(login.Link)
+	if err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.InvalidOAuthStateError,
+		)
+	}
+
+	userModel := usermodel.New(sh.db)
+	owner, err := userModel.FindByIdentity(context.Background(), claims.Issuer, claims.Subject)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	if owner != nil && owner.ID != userID {
+		sh.logger.Debug("Client error",
+			zap.String("cause", apierrors.IdentityInUseError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusConflict,
+			apierrors.IdentityInUseError,
+		)
+	}
+
+	user, err := userModel.FindByID(context.Background(), userID)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	// Linking the same account again is a no-op
+	if owner == nil {
+		identity := usermodel.NewIdentity(claims.Issuer, claims.Subject, claims.Email)
+		if err := userModel.AddIdentity(context.Background(), userID, identity); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) || mongo.IsDuplicateKeyError(err) {
+				sh.logger.Debug("Client error",
+					zap.String("cause", apierrors.IdentityLinkedError),
+				)
+				return apierrors.CustomError(c,
+					http.StatusConflict,
+					apierrors.IdentityLinkedError,
+				)
+			}
+
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+
+		sh.logger.Info("Identity linked",
+			zap.String("_id", userID.Hex()),
+			zap.String("provider", claims.Issuer),
+		)
+	}
+
+	return sh.signIn(c, http.StatusOK, user, login)
+}
+
+// findUser loads the signed in user. When it can't, the error response has
+// already been written.
+func (sh *OAuthHandler) findUser(c echo.Context) (*usermodel.UserRecord, bool) {
+	userID, err := apiutils.GetUserFromContext(c)
+	if err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusUnauthorized,
+			apierrors.UnauthorizedError,
+		)
+		return nil, false
+	}
+
+	userModel := usermodel.New(sh.db)
+	user, err := userModel.FindByID(context.Background(), userID)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// reauthenticate makes sure the signed in user is at the keyboard before
+// their sign-in methods change: users with a password give it, others have
+// to have signed in within ReauthenticationWindow. When they can't, the error
+// response has already been written.
+func reauthenticate(c echo.Context, logger *zap.Logger, user *usermodel.UserRecord, password string) bool {
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+			logger.Debug("Client error",
+				zap.Error(err),
+			)
+			_ = apierrors.CustomError(c,
+				http.StatusForbidden,
+				apierrors.InvalidPasswordError,
+			)
+			return false
+		}
+
+		return true
+	}
+
+	if time.Since(apiutils.GetSessionIssuedAtFromContext(c)) > ReauthenticationWindow {
+		logger.Debug("Client error",
+			zap.String("cause", apierrors.ReauthenticationError),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusForbidden,
+			apierrors.ReauthenticationError,
+		)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	notificationmodel "github.com/Roll-Play/togglelabs/pkg/models/notification"
+	"github.com/Roll-Play/togglelabs/pkg/notification"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+type NotificationHandler struct {
+	db       *mongo.Database
+	logger   *zap.Logger
+	notifier *notification.Notifier
+}
+
+func NewNotificationHandler(db *mongo.Database, logger *zap.Logger, notifier *notification.Notifier) *NotificationHandler {
+	return &NotificationHandler{
+		db:       db,
+		logger:   logger,
+		notifier: notifier,
+	}
+}
+
+type PostNotificationRequest struct {
+	Name         string               `json:"name" validate:"required"`
+	URL          string               `json:"url" validate:"required,http_url"`
+	Format       string               `json:"format" validate:"required,oneof=slack mattermost teams"`
+	Events       []string             `json:"events"`
+	Projects     []primitive.ObjectID `json:"projects"`
+	Environments []string             `json:"environments"`
+}
+
+// Missing fields are left unchanged, empty filters match everything.
+type PatchNotificationRequest struct {
+	Name         *string              `json:"name" validate:"omitempty,min=1"`
+	URL          *string              `json:"url" validate:"omitempty,http_url"`
+	Format       *string              `json:"format" validate:"omitempty,oneof=slack mattermost teams"`
+	Events       []string             `json:"events"`
+	Projects     []primitive.ObjectID `json:"projects"`
+	Environments []string             `json:"environments"`
+	Active       *bool                `json:"active"`
+}
+
+func (nh *NotificationHandler) PostIntegration(c echo.Context) error {
+	userID, organization, ok := organizationFromContext(c, nh.logger)
+	if !ok {
+		return nil
+	}
+
+	request := new(PostNotificationRequest)
+	if err := c.Bind(request); err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+
+	if err := validate.Struct(request); err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if err := validateFlagEventTypes(request.Events); err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	record := notificationmodel.NewIntegrationRecord(
+		organization.ID,
+		request.Name,
+		request.URL,
+		request.Format,
+		request.Events,
+		request.Projects,
+		request.Environments,
+		userID,
+	)
+
+	model := notificationmodel.New(nh.db)
+	if _, err := model.InsertOne(context.Background(), record); err != nil {
+		nh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, record)
+}
+
+func (nh *NotificationHandler) ListIntegrations(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, nh.logger)
+	if !ok {
+		return nil
+	}
+
+	model := notificationmodel.New(nh.db)
+	records, err := model.FindMany(context.Background(), organization.ID, bson.D{})
+	if err != nil {
+		nh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, records)
+}
+
+func (nh *NotificationHandler) PatchIntegration(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, nh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := nh.findIntegration(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	request := new(PatchNotificationRequest)
+	if err := c.Bind(request); err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+
+	if err := validate.Struct(request); err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if err := validateFlagEventTypes(request.Events); err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	values := bson.D{}
+	if request.Name != nil {
+		record.Name = *request.Name
+		values = append(values, bson.E{Key: "name", Value: record.Name})
+	}
+	if request.URL != nil {
+		record.URL = *request.URL
+		values = append(values, bson.E{Key: "url", Value: record.URL})
+	}
+	if request.Format != nil {
+		record.Format = *request.Format
+		values = append(values, bson.E{Key: "format", Value: record.Format})
+	}
+	if request.Events != nil {
+		record.Events = request.Events
+		values = append(values, bson.E{Key: "events", Value: record.Events})
+	}
+	if request.Projects != nil {
+		record.Projects = request.Projects
+		values = append(values, bson.E{Key: "projects", Value: record.Projects})
+	}
+	if request.Environments != nil {
+		record.Environments = request.Environments
+		values = append(values, bson.E{Key: "environments", Value: record.Environments})
+	}
+	if request.Active != nil {
+		record.Active = *request.Active
+		values = append(values, bson.E{Key: "active", Value: record.Active})
+	}
+
+	model := notificationmodel.New(nh.db)
+	if err := model.UpdateOne(context.Background(), organization.ID, record.ID, values); err != nil {
+		nh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, record)
+}
+
+func (nh *NotificationHandler) DeleteIntegration(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, nh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := nh.findIntegration(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	model := notificationmodel.New(nh.db)
+	if err := model.DeleteOne(context.Background(), organization.ID, record.ID); err != nil {
+		nh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (nh *NotificationHandler) PostTestNotification(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, nh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := nh.findIntegration(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	err := nh.notifier.Send(context.Background(), record, notification.NewTestMessage(record))
+	if err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadGateway,
+			apierrors.NotificationError,
+		)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// findIntegration loads the integration of the route from the organization.
+// When it can't, the error response has already been written.
+func (nh *NotificationHandler) findIntegration(
+	c echo.Context,
+	organizationID primitive.ObjectID,
+) (*notificationmodel.IntegrationRecord, bool) {
+	integrationID, err := primitive.ObjectIDFromHex(c.Param("integrationID"))
+	if err != nil {
+		nh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+		return nil, false
+	}
+
+	model := notificationmodel.New(nh.db)
+	record, err := model.FindByID(context.Background(), organizationID, integrationID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			nh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			_ = apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+			return nil, false
+		}
+
+		nh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+		return nil, false
+	}
+
+	return record, true
+}
+
+// Chat notifications only cover flag changes.
+func validateFlagEventTypes(eventTypes []string) error {
+	for _, eventType := range eventTypes {
+		if !isTimelineEventType(eventType) {
+			return ErrUnknownEventType
+		}
+	}
+
+	return nil
+}
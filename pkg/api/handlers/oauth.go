@@ -2,65 +2,167 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/subtle"
 	"errors"
-	"io"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/Roll-Play/togglelabs/pkg/api/common"
 	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
 	"github.com/Roll-Play/togglelabs/pkg/config"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
 	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	"github.com/Roll-Play/togglelabs/pkg/oidc"
 	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 )
 
 type OAuthHandler struct {
-	oauthConfig *oauth2.Config
-	db          *mongo.Database
-	logger      *zap.Logger
-	httpClient  apiutils.BaseHTTPClient
-	oauthClient apiutils.OAuthClient
+	db        *mongo.Database
+	logger    *zap.Logger
+	providers *oidc.Registry
+	// Providers organizations configure, reached under stricter rules
+	tenantProviders *oidc.Registry
+	// Provider of the deployment, organizations may configure their own
+	config oidc.Config
+	// Origins users may be sent back to after signing in
+	redirectAllowlist []string
 }
 
 func NewOAuthHandler(
 	db *mongo.Database,
-	oauthConfig *oauth2.Config,
 	logger *zap.Logger,
-	httpClient apiutils.BaseHTTPClient,
-	oauthClient apiutils.OAuthClient,
+	providers *oidc.Registry,
+	tenantProviders *oidc.Registry,
+	config oidc.Config,
+	redirectAllowlist []string,
 ) *OAuthHandler {
 	return &OAuthHandler{
-		oauthConfig: oauthConfig,
-		db:          db,
-		logger:      logger,
-		httpClient:  httpClient,
-		oauthClient: oauthClient,
+		db:                db,
+		logger:            logger,
+		providers:         providers,
+		tenantProviders:   tenantProviders,
+		config:            config,
+		redirectAllowlist: redirectAllowlist,
 	}
 }
 
+type OIDCProviderRequest struct {
+	Issuer       string `json:"issuer" validate:"required,url"`
+	ClientID     string `json:"client_id" validate:"required"`
+	ClientSecret string `json:"client_secret" validate:"required"`
+	RedirectURL  string `json:"redirect_url" validate:"required,url"`
+}
+
+// SignIn redirects to the provider of the deployment or, under
+// /oauth/:organizationID, to the one of the organization. The state, PKCE
+// verifier and nonce of the login are kept in a signed cookie for Callback,
+// along with the redirect_to location the dashboard resumes at.
 func (sh *OAuthHandler) SignIn(c echo.Context) error {
-	randomString := os.Getenv("OAUTH_RANDOM_STRING")
+	redirectTo := c.QueryParam("redirect_to")
+	if !allowedRedirect(redirectTo, sh.redirectAllowlist) {
+		sh.logger.Debug("Client error",
+			zap.String("cause", apierrors.InvalidRedirectError),
+			zap.String("redirect_to", redirectTo),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.InvalidRedirectError,
+		)
+	}
+
+	provider, _, ok := sh.provider(c)
+	if !ok {
+		return nil
+	}
 
-	if randomString == "" {
-		randomString = "random-string"
+	login, err := newOAuthLogin(c.Param("organizationID"), redirectTo)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	cookie, err := login.cookie()
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
 	}
+	c.SetCookie(cookie)
 
-	url := sh.oauthConfig.AuthCodeURL(randomString)
+	url := provider.AuthCodeURL(
+		login.State,
+		oauth2.S256ChallengeOption(login.Verifier),
+		oauth2.SetAuthURLParam("nonce", login.Nonce),
+	)
 	return c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
+// Callback finishes the sign-in started by SignIn in the same browser, the
+// state has to match its cookie so logins can't be forced on users.
 func (sh *OAuthHandler) Callback(c echo.Context) error {
-	state := c.QueryParam("state")
-	code := c.QueryParam("code")
+	// Logins are single-use, whatever their outcome
+	c.SetCookie(oauthCookie("", -1))
 
-	userDataBytes, err := sh.getUserOAuthData(state, code)
+	login, err := readOAuthLogin(c)
+	if err == nil && (!equalSecrets(login.State, c.QueryParam("state")) ||
+		login.Organization != c.Param("organizationID")) {
+		err = ErrInvalidOAuthLogin
+	}
+	if err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.InvalidOAuthStateError,
+		)
+	}
 
+	provider, organization, ok := sh.provider(c)
+	if !ok {
+		return nil
+	}
+
+	claims, err := provider.Exchange(
+		context.Background(),
+		c.QueryParam("code"),
+		login.Nonce,
+		oauth2.VerifierOption(login.Verifier),
+	)
+	var retrieveError *oauth2.RetrieveError
 	if err != nil {
+		// Codes the provider refuses, like ones of another login
+		if errors.Is(err, oidc.ErrInvalidToken) || errors.As(err, &retrieveError) {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusUnauthorized,
+				apierrors.UnauthorizedError,
+			)
+		}
+
 		sh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -70,9 +172,51 @@ func (sh *OAuthHandler) Callback(c echo.Context) error {
 			apierrors.InternalServerError,
 		)
 	}
-	userData := new(usermodel.UserRecord)
-	err = json.Unmarshal(userDataBytes, userData)
-	if err != nil {
+
+	if claims.Email == "" {
+		sh.logger.Debug("Client error",
+			zap.String("cause", "provider shared no email"),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusUnauthorized,
+			apierrors.UnauthorizedError,
+		)
+	}
+
+	if login.Link != "" {
+		return sh.linkIdentity(c, login, claims)
+	}
+
+	// Providers of organizations only sign in their members
+	allowed := func(user *usermodel.UserRecord) bool {
+		if organization == nil || user != nil && organization.Member(user.ID) != nil {
+			return true
+		}
+
+		sh.logger.Debug("Client error",
+			zap.String("cause", apierrors.OrganizationSignInError),
+			zap.String("organization_id", organization.ID.Hex()),
+		)
+		_ = apierrors.CustomError(
+			c,
+			http.StatusForbidden,
+			apierrors.OrganizationSignInError,
+		)
+		return false
+	}
+
+	model := usermodel.New(sh.db)
+	user, err := model.FindByIdentity(context.Background(), claims.Issuer, claims.Subject)
+	if err == nil {
+		if !allowed(user) {
+			return nil
+		}
+
+		return sh.signIn(c, http.StatusOK, user, login)
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
 		sh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -83,11 +227,50 @@ func (sh *OAuthHandler) Callback(c echo.Context) error {
 		)
 	}
 
-	model := usermodel.New(sh.db)
-	foundRecord, err := model.FindByEmail(context.Background(), userData.Email)
+	identity := usermodel.NewIdentity(claims.Issuer, claims.Subject, claims.Email)
+	user, err = model.FindByEmail(context.Background(), claims.Email)
 	if err == nil {
-		token, err := apiutils.CreateJWT(foundRecord.ID, config.JWTExpireTime)
-		if err != nil {
+		if !allowed(user) {
+			return nil
+		}
+
+		// Anyone can claim an email they don't own at some providers, so
+		// accounts are only merged by the deployment provider, when both
+		// sides verified the email. Providers of organizations are run by
+		// the organizations themselves, their identities are linked
+		// explicitly, except for members provisioned through SCIM that have
+		// no way to sign in yet, which the organization claims.
+		legacy := claims.Issuer == oidc.GoogleIssuer && user.OAuthID == claims.Subject
+		unclaimed := user.Password == "" && user.OAuthID == "" && len(user.Identities) == 0
+		var linkable bool
+		if organization == nil {
+			linkable = legacy || claims.EmailVerified && user.EmailVerified
+		} else {
+			linkable = unclaimed
+		}
+		if !linkable {
+			sh.logger.Debug("Client error",
+				zap.String("cause", apierrors.AccountLinkRequiredError),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusConflict,
+				apierrors.AccountLinkRequiredError,
+			)
+		}
+
+		if err := model.AddIdentity(context.Background(), user.ID, identity); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) || mongo.IsDuplicateKeyError(err) {
+				sh.logger.Debug("Client error",
+					zap.String("cause", apierrors.IdentityLinkedError),
+				)
+				return apierrors.CustomError(
+					c,
+					http.StatusConflict,
+					apierrors.IdentityLinkedError,
+				)
+			}
+
 			sh.logger.Debug("Server error",
 				zap.Error(err),
 			)
@@ -98,17 +281,10 @@ func (sh *OAuthHandler) Callback(c echo.Context) error {
 			)
 		}
 
-		return c.JSON(http.StatusOK, common.AuthResponse{
-			ID:        foundRecord.ID,
-			Email:     foundRecord.Email,
-			FirstName: foundRecord.FirstName,
-			LastName:  foundRecord.LastName,
-			Token:     token,
-		})
+		return sh.signIn(c, http.StatusOK, user, login)
 	}
 
-	objectID, err := model.InsertOne(context.Background(), userData)
-	if err != nil {
+	if !errors.Is(err, mongo.ErrNoDocuments) {
 		sh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -119,7 +295,20 @@ func (sh *OAuthHandler) Callback(c echo.Context) error {
 		)
 	}
 
-	token, err := apiutils.CreateJWT(objectID, config.JWTExpireTime)
+	if !allowed(nil) {
+		return nil
+	}
+
+	// Only trust the provider about the email it verified itself
+	user = &usermodel.UserRecord{
+		Email:         claims.Email,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+		OAuthID:       claims.Subject,
+		EmailVerified: claims.EmailVerified,
+		Identities:    []usermodel.Identity{*identity},
+	}
+	objectID, err := model.InsertOne(context.Background(), user)
 	if err != nil {
 		sh.logger.Debug("Server error",
 			zap.Error(err),
@@ -134,41 +323,242 @@ func (sh *OAuthHandler) Callback(c echo.Context) error {
 	sh.logger.Debug("User created",
 		zap.String("_id", objectID.Hex()),
 	)
-	return c.JSON(http.StatusCreated, common.AuthResponse{
-		ID:    userData.ID,
-		Email: userData.Email,
-		Token: token,
+	return sh.signIn(c, http.StatusCreated, user, login)
+}
+
+// signIn answers with a new session of user, or with a challenge for users
+// with a second factor, which identity providers don't vouch for.
+func (sh *OAuthHandler) signIn(c echo.Context, status int, user *usermodel.UserRecord, login *oauthLogin) error {
+	c.Set("user", user.ID.Hex())
+
+	if user.TwoFactorEnabled() {
+		challenge, err := createTwoFactorChallenge(context.Background(), sh.db, user.ID)
+		if err != nil {
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+
+		return c.JSON(http.StatusOK, TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			RedirectTo:        login.RedirectTo,
+		})
+	}
+
+	token, err := apiutils.CreateSessionJWT(user.ID, user.SessionVersion, false, config.JWTExpireTime)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(status, common.AuthResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		EmailVerified: user.EmailVerified,
+		Token:         token,
+		RedirectTo:    login.RedirectTo,
 	})
 }
 
-func (sh *OAuthHandler) getUserOAuthData(
-	state string,
-	code string,
-) ([]byte, error) {
-	randomString := os.Getenv("OAUTH_RANDOM_STRING")
-	if randomString == "" {
-		randomString = "random-string"
+// PutOrganizationProvider sets the identity provider members of the
+// organization sign in with. The provider is discovered before it's saved,
+// so a wrong issuer is reported right away.
+func (sh *OAuthHandler) PutOrganizationProvider(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, sh.logger)
+	if !ok {
+		return nil
+	}
+
+	request := new(OIDCProviderRequest)
+	if err := c.Bind(request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
 	}
 
-	if state != randomString {
-		return nil, errors.New("invalid user state")
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
 	}
 
-	token, err := sh.oauthClient.Exchange(context.Background(), code)
+	provider := &organizationmodel.OIDCProvider{
+		Issuer:       request.Issuer,
+		ClientID:     request.ClientID,
+		ClientSecret: request.ClientSecret,
+		RedirectURL:  request.RedirectURL,
+	}
+	if _, err := sh.tenantProviders.Provider(context.Background(), organizationProviderConfig(provider)); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.InvalidOIDCProviderError,
+		)
+	}
+
+	organizationModel := organizationmodel.New(sh.db)
+	err := organizationModel.UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: organization.ID}},
+		bson.D{{Key: "$set", Value: bson.M{
+			"oidc":                  provider,
+			"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+		}}},
+	)
 	if err != nil {
-		return nil, err
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
 	}
 
-	response, err := sh.httpClient.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + token.AccessToken)
+	return c.JSON(http.StatusOK, provider)
+}
+
+func (sh *OAuthHandler) DeleteOrganizationProvider(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, sh.logger)
+	if !ok {
+		return nil
+	}
+
+	organizationModel := organizationmodel.New(sh.db)
+	err := organizationModel.UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: organization.ID}},
+		bson.D{
+			{Key: "$unset", Value: bson.M{"oidc": ""}},
+			{Key: "$set", Value: bson.M{
+				"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+			}},
+		},
+	)
 	if err != nil {
-		return nil, err
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
 	}
 
-	defer response.Body.Close()
-	data, err := io.ReadAll(response.Body)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// provider returns the provider of the organization in the organizationID
+// path parameter along with the organization, or the one of the deployment
+// and nil without it. When it can't, the error response has already been
+// written.
+func (sh *OAuthHandler) provider(c echo.Context) (*oidc.Provider, *organizationmodel.OrganizationRecord, bool) {
+	return sh.organizationProvider(c, c.Param("organizationID"))
+}
+
+// organizationProvider returns the provider of organization, the one of the
+// deployment when it's empty.
+func (sh *OAuthHandler) organizationProvider(
+	c echo.Context,
+	organization string,
+) (*oidc.Provider, *organizationmodel.OrganizationRecord, bool) {
+	if organization == "" {
+		provider, ok := sh.discover(c, sh.providers, sh.config)
+		return provider, nil, ok
+	}
+
+	organizationID, err := primitive.ObjectIDFromHex(organization)
 	if err != nil {
-		return nil, err
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+		return nil, nil, false
 	}
 
-	return data, nil
+	organizationModel := organizationmodel.New(sh.db)
+	record, err := organizationModel.FindByID(context.Background(), organizationID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+		return nil, nil, false
+	}
+
+	if record == nil || record.OIDC == nil {
+		sh.logger.Debug("Client error",
+			zap.String("cause", "organization has no identity provider"),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusNotFound,
+			apierrors.NotFoundError,
+		)
+		return nil, nil, false
+	}
+
+	provider, ok := sh.discover(c, sh.tenantProviders, organizationProviderConfig(record.OIDC))
+	return provider, record, ok
+}
+
+// discover returns the provider of providerConfig from registry. When it
+// can't, the error response has already been written.
+func (sh *OAuthHandler) discover(c echo.Context, registry *oidc.Registry, providerConfig oidc.Config) (*oidc.Provider, bool) {
+	provider, err := registry.Provider(context.Background(), providerConfig)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusBadGateway,
+			apierrors.InvalidOIDCProviderError,
+		)
+		return nil, false
+	}
+
+	return provider, true
+}
+
+func organizationProviderConfig(provider *organizationmodel.OIDCProvider) oidc.Config {
+	return oidc.Config{
+		Issuer:       provider.Issuer,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  provider.RedirectURL,
+	}
+}
+
+func equalSecrets(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+const (
+	OAuthCookieName = "togglelabs_oauth"
+	// OAuthLoginTTL bounds the time users have to sign in with the provider
+	OAuthLoginTTL  = 10 * time.Minute
+	oauthLoginUse  = "oauth-login"
+	oauthStateSize = 32
+)
+
+var ErrInvalidOAuthLogin = errors.New("invalid oauth login cookie")
+
+// oauthLogin is what a callback needs to finish the sign-in it was started
+// for, kept in a signed cookie of the browser between both.
+type oauthLogin struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	// Empty for the provider of the deployment
+	Organization string `json:"organization,omitempty"`
+	RedirectTo   string `json:"redirect_to,omitempty"`
+	// User the identity is linked to, instead of signing in
+	Link string `json:"link,omitempty"`
+	jwt.StandardClaims
+}
+
+func newOAuthLogin(organization, redirectTo string) (*oauthLogin, error) {
+	state, err := randomOAuthValue()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomOAuthValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauthLogin{
+		State:        state,
+		Verifier:     oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		Organization: organization,
+		RedirectTo:   redirectTo,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  oauthLoginUse,
+			ExpiresAt: time.Now().Add(OAuthLoginTTL).Unix(),
+		},
+	}, nil
+}
+
+func (ol *oauthLogin) cookie() (*http.Cookie, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, ol)
+	value, err := token.SignedString(apiutils.SigningKey(oauthLoginUse))
+	if err != nil {
+		return nil, err
+	}
+
+	return oauthCookie(value, int(OAuthLoginTTL.Seconds())), nil
+}
+
+// readOAuthLogin returns the login of the cookie of the request, as long as
+// we signed it and it hasn't expired.
+func readOAuthLogin(c echo.Context) (*oauthLogin, error) {
+	cookie, err := c.Cookie(OAuthCookieName)
+	if err != nil {
+		return nil, ErrInvalidOAuthLogin
+	}
+
+	login := new(oauthLogin)
+	_, err = jwt.ParseWithClaims(cookie.Value, login, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, ErrInvalidOAuthLogin
+		}
+		return apiutils.SigningKey(oauthLoginUse), nil
+	})
+	if err != nil || !login.VerifyAudience(oauthLoginUse, true) {
+		return nil, ErrInvalidOAuthLogin
+	}
+
+	return login, nil
+}
+
+// oauthCookie is sent along the top-level redirect back from the provider,
+// which SameSite Lax allows, and is out of reach of scripts.
+func oauthCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     OAuthCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   config.Environment != config.DevEnvironment,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// allowedRedirect tells whether users may be sent back to redirectTo after
+// signing in: paths of the dashboard, or URLs of an allowed origin.
+func allowedRedirect(redirectTo string, allowlist []string) bool {
+	if redirectTo == "" {
+		return true
+	}
+
+	// Backslashes are read as slashes by browsers, "/\evil.com" leaves the site
+	if strings.Contains(redirectTo, "\\") {
+		return false
+	}
+
+	location, err := url.Parse(redirectTo)
+	if err != nil || location.User != nil {
+		return false
+	}
+
+	if location.Scheme == "" && location.Host == "" {
+		return strings.HasPrefix(location.Path, "/") && !strings.HasPrefix(redirectTo, "//")
+	}
+
+	if location.Scheme != "http" && location.Scheme != "https" {
+		return false
+	}
+
+	origin := strings.ToLower(location.Scheme + "://" + location.Host)
+	for _, allowed := range allowlist {
+		allowedURL, err := url.Parse(strings.TrimSpace(allowed))
+		if err != nil {
+			continue
+		}
+
+		if origin == strings.ToLower(allowedURL.Scheme+"://"+allowedURL.Host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomOAuthValue() (string, error) {
+	bytes := make([]byte, oauthStateSize)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
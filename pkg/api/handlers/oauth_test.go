@@ -1,32 +1,45 @@
 package handlers_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/Roll-Play/togglelabs/pkg/api/common"
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
 	"github.com/Roll-Play/togglelabs/pkg/api/handlers"
 	"github.com/Roll-Play/togglelabs/pkg/api/handlers/fixtures"
+	"github.com/Roll-Play/togglelabs/pkg/api/middlewares"
 	"github.com/Roll-Play/togglelabs/pkg/logger"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
 	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	"github.com/Roll-Play/togglelabs/pkg/oidc"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
 	testutils "github.com/Roll-Play/togglelabs/pkg/utils/test_utils"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/oauth2"
 )
 
 type OAuthTestSuite struct {
 	testutils.DefaultTestSuite
-	db *mongo.Database
+	db       *mongo.Database
+	provider *fixtures.MockOIDCServer
+	// Provider of an organization, served over https
+	tenantProvider *fixtures.MockOIDCServer
 }
 
 func (suite *OAuthTestSuite) SetupTest() {
@@ -42,9 +55,45 @@ func (suite *OAuthTestSuite) SetupTest() {
 	suite.db = client.Database("togglelabs_test")
 
 	suite.Server = echo.New()
+	suite.provider = fixtures.NewMockOIDCServer("togglelabs")
+	suite.tenantProvider = fixtures.NewMockOIDCTLSServer("togglelabs-company")
+
+	logger, _ := logger.NewZapLogger()
+
+	h := handlers.NewOAuthHandler(
+		suite.db,
+		logger,
+		oidc.NewRegistry(nil),
+		oidc.NewTenantRegistry(suite.tenantProvider.Client()),
+		suite.provider.Config(),
+		[]string{"http://dashboard.test"},
+	)
+	suite.Server.POST("/oauth", h.SignIn)
+	suite.Server.GET("/callback", h.Callback)
+	suite.Server.POST("/oauth/:organizationID", h.SignIn)
+	suite.Server.GET("/oauth/:organizationID/callback", h.Callback)
+
+	organizationGroup := suite.Server.Group(
+		"/organizations",
+		middlewares.AuthMiddleware(suite.db),
+		middlewares.OrganizationMiddleware,
+	)
+	isAdmin := middlewares.RequireLevel(suite.db, organizationmodel.Admin)
+	organizationGroup.PUT("/oidc", h.PutOrganizationProvider, isAdmin)
+	organizationGroup.DELETE("/oidc", h.DeleteOrganizationProvider, isAdmin)
+
+	passwordHandler := handlers.NewPasswordHandler(suite.db, logger, nil, "")
+	userGroup := suite.Server.Group("/user", middlewares.AuthMiddleware(suite.db))
+	userGroup.GET("/identities", h.ListIdentities)
+	userGroup.POST("/identities", h.PostIdentity)
+	userGroup.DELETE("/identities/:identityID", h.DeleteIdentity)
+	userGroup.PATCH("/password", passwordHandler.PatchPassword)
 }
 
 func (suite *OAuthTestSuite) AfterTest(_, _ string) {
+	suite.provider.Close()
+	suite.tenantProvider.Close()
+
 	if err := suite.db.Drop(context.Background()); err != nil {
 		panic(err)
 	}
@@ -57,35 +106,80 @@ func (suite *OAuthTestSuite) TearDownSuite() {
 	suite.Server.Close()
 }
 
-func (suite *OAuthTestSuite) TestOAtuhHandlerNewUserSuccess() {
+// signIn starts a login at path and returns the login cookie along with the
+// callback request the provider redirects to.
+func (suite *OAuthTestSuite) signIn(path string) (*http.Cookie, *http.Request) {
 	t := suite.T()
 
-	mockOAuthClient := &fixtures.MockOAuthClient{
-		ExchangeFunc: func(ctx context.Context, code string) (*oauth2.Token, error) {
-			return &oauth2.Token{
-				AccessToken: "mockAccessToken",
-				TokenType:   "Bearer",
-				Expiry:      time.Now().Add(time.Hour),
-			}, nil
+	recorder := httptest.NewRecorder()
+	suite.Server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+
+	cookies := recorder.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, handlers.OAuthCookieName, cookies[0].Name)
+
+	return cookies[0], suite.authorize(recorder.Header().Get(echo.HeaderLocation))
+}
+
+// authorize signs in at the authorization URL of the provider and returns
+// the callback request it redirects to.
+func (suite *OAuthTestSuite) authorize(authorizationURL string) *http.Request {
+	t := suite.T()
+
+	client := &http.Client{
+		// Trusts the certificate of the provider of the organization
+		Transport: suite.tenantProvider.Client().Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
 		},
 	}
+	response, err := client.Get(authorizationURL)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusFound, response.StatusCode)
 
-	model := usermodel.New(suite.db)
+	callback, err := url.Parse(response.Header.Get(echo.HeaderLocation))
+	assert.NoError(t, err)
 
-	request := httptest.NewRequest(http.MethodGet, "/callback", nil)
-	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	recorder := httptest.NewRecorder()
-	urlq := request.URL.Query()
-	urlq.Add("state", "random-string")
-	urlq.Add("code", "code")
-	request.URL.RawQuery = urlq.Encode()
+	return httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+}
 
-	logger, _ := logger.NewZapLogger()
+func (suite *OAuthTestSuite) request(method, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	return fixtures.Request(suite.Server, method, path, body, token, "")
+}
+
+func (suite *OAuthTestSuite) verifyEmail(user *usermodel.UserRecord) {
+	err := usermodel.New(suite.db).UpdateOne(context.Background(), user.ID, bson.D{{Key: "email_verified", Value: true}})
+	assert.NoError(suite.T(), err)
+}
+
+func (suite *OAuthTestSuite) callback(cookie *http.Cookie, request *http.Request) *httptest.ResponseRecorder {
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
 
-	h := handlers.NewOAuthHandler(suite.db, &oauth2.Config{}, logger, &fixtures.MockHTTPClient{}, mockOAuthClient)
-	assert.NotNil(t, h)
-	suite.Server.GET("/callback", h.Callback)
 	suite.Server.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func (suite *OAuthTestSuite) login(path string) *httptest.ResponseRecorder {
+	return suite.callback(suite.signIn(path))
+}
+
+func (suite *OAuthTestSuite) TestOAtuhHandlerNewUserSuccess() {
+	t := suite.T()
+
+	suite.provider.SetClaims(map[string]interface{}{
+		"given_name":  "Fizi",
+		"family_name": "Valores",
+	})
+
+	model := usermodel.New(suite.db)
+
+	recorder := suite.login("/oauth")
 	var response common.AuthResponse
 
 	ur, err := model.FindByEmail(context.Background(), "test@test.com")
@@ -93,42 +187,489 @@ func (suite *OAuthTestSuite) TestOAtuhHandlerNewUserSuccess() {
 	assert.Equal(t, http.StatusCreated, recorder.Code)
 	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
 	assert.Equal(t, ur.Email, response.Email)
+	assert.Equal(t, "Fizi", ur.FirstName)
+	assert.Equal(t, "Valores", ur.LastName)
+	assert.Equal(t, "12345", ur.OAuthID)
+	assert.True(t, ur.EmailVerified)
 	assert.NotEmpty(t, response.Token)
 }
 
 func (suite *OAuthTestSuite) TestOAtuhHandlerExistingUserSuccess() {
 	t := suite.T()
-	mockOAuthClient := &fixtures.MockOAuthClient{
-		ExchangeFunc: func(ctc context.Context, code string) (*oauth2.Token, error) {
-			return &oauth2.Token{
-				AccessToken: "mockAccessToken",
-				TokenType:   "Bearer",
-				Expiry:      time.Now().Add(time.Hour),
-			}, nil
-		},
-	}
 
 	user := fixtures.CreateUser("test@test.com", "", "", "", suite.db)
+	suite.verifyEmail(user)
+
+	recorder := suite.login("/oauth")
+	var response common.AuthResponse
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, user.Email, response.Email)
+	assert.NotEmpty(t, response.Token)
+
+	// Verified on both sides, the account of the provider is linked
+	record, err := usermodel.New(suite.db).FindByIdentity(context.Background(), suite.provider.URL, "12345")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, record.ID)
+	assert.Len(t, record.Identities, 1)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerUnverifiedEmailNotMerged() {
+	t := suite.T()
+
+	// Unverified on our side
+	user := fixtures.CreateUser("test@test.com", "", "", "password", suite.db)
+
+	recorder := suite.login("/oauth")
+	var response apierrors.Error
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, apierrors.AccountLinkRequiredError, response.Message)
+
+	// Unverified at the provider
+	suite.verifyEmail(user)
+	suite.provider.SetClaims(map[string]interface{}{"email_verified": false})
+
+	recorder = suite.login("/oauth")
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, apierrors.AccountLinkRequiredError, response.Message)
+
+	record, err := usermodel.New(suite.db).FindByID(context.Background(), user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, record.Identities)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerLinkIdentity() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("fizi@gmail.com", "", "", "password", suite.db)
+	session, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	recorder := suite.request(http.MethodPost, "/user/identities", handlers.IdentityLinkRequest{
+		Password: "wrong_password",
+	}, session)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	recorder = suite.request(http.MethodPost, "/user/identities", handlers.IdentityLinkRequest{
+		Password:   "password",
+		RedirectTo: "/settings/security",
+	}, session)
+	var link handlers.IdentityLinkResponse
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &link))
+	cookie := recorder.Result().Cookies()[0]
+
+	// The account of the provider has another email, linking doesn't need it
+	recorder = suite.callback(cookie, suite.authorize(link.AuthorizationURL))
+	var response common.AuthResponse
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, user.ID, response.ID)
+	assert.Equal(t, "/settings/security", response.RedirectTo)
+
+	recorder = suite.request(http.MethodGet, "/user/identities", nil, session)
+	var identities []usermodel.Identity
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &identities))
+	assert.Len(t, identities, 1)
+	assert.Equal(t, suite.provider.URL, identities[0].Provider)
+	assert.Equal(t, "12345", identities[0].Subject)
+	assert.NotZero(t, identities[0].LinkedAt)
+
+	// The provider now signs this user in
+	recorder = suite.login("/oauth")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, user.ID, response.ID)
+
+	// And can't be linked to anyone else
+	other := fixtures.CreateUser("other@gmail.com", "", "", "password", suite.db)
+	otherSession, err := apiutils.CreateJWT(other.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	recorder = suite.request(http.MethodPost, "/user/identities", handlers.IdentityLinkRequest{
+		Password: "password",
+	}, otherSession)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &link))
+	recorder = suite.callback(recorder.Result().Cookies()[0], suite.authorize(link.AuthorizationURL))
+	var errorResponse apierrors.Error
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &errorResponse))
+	assert.Equal(t, apierrors.IdentityInUseError, errorResponse.Message)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerUnlinkIdentity() {
+	t := suite.T()
+
+	recorder := suite.login("/oauth")
+	var response common.AuthResponse
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+
+	recorder = suite.request(http.MethodGet, "/user/identities", nil, response.Token)
+	var identities []usermodel.Identity
+
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &identities))
+	assert.Len(t, identities, 1)
+
+	path := "/user/identities/" + identities[0].ID.Hex()
+	recorder = suite.request(http.MethodDelete, path, handlers.IdentityUnlinkRequest{}, response.Token)
+	var errorResponse apierrors.Error
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &errorResponse))
+	assert.Equal(t, apierrors.LastSignInMethodError, errorResponse.Message)
+
+	// Users of a provider can set a password right after signing in
+	recorder = suite.request(http.MethodPatch, "/user/password", handlers.ChangePasswordRequest{
+		NewPassword: "brand_new_password",
+	}, response.Token)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+
+	recorder = suite.request(http.MethodDelete, path, handlers.IdentityUnlinkRequest{}, response.Token)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	recorder = suite.request(http.MethodDelete, path, handlers.IdentityUnlinkRequest{
+		Password: "brand_new_password",
+	}, response.Token)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	recorder = suite.request(http.MethodGet, "/user/identities", nil, response.Token)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &identities))
+	assert.Empty(t, identities)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerTwoFactorChallenge() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("test@test.com", "", "", "", suite.db)
+	suite.verifyEmail(user)
+	err := usermodel.New(suite.db).UpdateOne(context.Background(), user.ID, bson.D{
+		{Key: "two_factor", Value: usermodel.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true}},
+	})
+	assert.NoError(t, err)
+
+	recorder := suite.login("/oauth")
+
+	// Identity providers don't vouch for the second factor of the user
+	var response handlers.TwoFactorChallengeResponse
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.True(t, response.TwoFactorRequired)
+	assert.NotEmpty(t, response.ChallengeToken)
+	assert.NotContains(t, recorder.Body.String(), `"token"`)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerSignInRedirect() {
+	t := suite.T()
+
+	states := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/oauth", nil)
+		recorder := httptest.NewRecorder()
+		suite.Server.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+		location, err := url.Parse(recorder.Header().Get(echo.HeaderLocation))
+		assert.NoError(t, err)
+		assert.Equal(t, suite.provider.URL+"/authorize", fmt.Sprintf("%s://%s%s", location.Scheme, location.Host, location.Path))
+		assert.Equal(t, "togglelabs", location.Query().Get("client_id"))
+		assert.Equal(t, "openid email profile", location.Query().Get("scope"))
+		assert.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+		assert.NotEmpty(t, location.Query().Get("code_challenge"))
+		assert.NotEmpty(t, location.Query().Get("nonce"))
+
+		state := location.Query().Get("state")
+		assert.Len(t, state, 43)
+		states[state] = true
+
+		cookie := recorder.Result().Cookies()[0]
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		assert.Equal(t, int(handlers.OAuthLoginTTL.Seconds()), cookie.MaxAge)
+		// The verifier stays with the browser
+		assert.NotContains(t, location.RawQuery, "code_verifier")
+	}
+
+	assert.Len(t, states, 2)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerInvalidState() {
+	t := suite.T()
+
+	cookie, request := suite.signIn("/oauth")
+	query := request.URL.Query()
+	query.Set("state", "forged-state")
+	request.URL.RawQuery = query.Encode()
+
+	recorder := suite.callback(cookie, request)
+	var response apierrors.Error
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, apierrors.InvalidOAuthStateError, response.Message)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerMissingCookie() {
+	t := suite.T()
+
+	_, request := suite.signIn("/oauth")
+
+	recorder := suite.callback(nil, request)
+	var response apierrors.Error
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, apierrors.InvalidOAuthStateError, response.Message)
+
+	// Tampered cookies are rejected too
+	cookie, request := suite.signIn("/oauth")
+	cookie.Value += "x"
+	recorder = suite.callback(cookie, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerCodeOfAnotherLogin() {
+	t := suite.T()
+
+	_, victimRequest := suite.signIn("/oauth")
+	attackerCookie, attackerRequest := suite.signIn("/oauth")
+
+	// The state matches the cookie, but the PKCE verifier doesn't match the code
+	query := attackerRequest.URL.Query()
+	query.Set("code", victimRequest.URL.Query().Get("code"))
+	attackerRequest.URL.RawQuery = query.Encode()
+
+	recorder := suite.callback(attackerCookie, attackerRequest)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerRedirectTo() {
+	t := suite.T()
+
+	for _, redirectTo := range []string{"/features/123?tab=rules", "http://dashboard.test/activity"} {
+		recorder := suite.login("/oauth?redirect_to=" + url.QueryEscape(redirectTo))
+		var response common.AuthResponse
+
+		assert.Contains(t, []int{http.StatusOK, http.StatusCreated}, recorder.Code)
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, redirectTo, response.RedirectTo)
+	}
+
+	for _, redirectTo := range []string{
+		"https://evil.test/activity",
+		"//evil.test/activity",
+		"/\\evil.test",
+		"javascript:alert(1)",
+		"http://user@dashboard.test.evil.test/",
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/oauth?redirect_to="+url.QueryEscape(redirectTo), nil)
+		recorder := httptest.NewRecorder()
+		suite.Server.ServeHTTP(recorder, request)
+		var response apierrors.Error
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, redirectTo)
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, apierrors.InvalidRedirectError, response.Message)
+		assert.Empty(t, recorder.Result().Cookies())
+	}
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerInvalidIDToken() {
+	t := suite.T()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	suite.provider.SignWith(key)
+
+	recorder := suite.login("/oauth")
+	var response apierrors.Error
 
-	request := httptest.NewRequest(http.MethodGet, "/callback", nil)
-	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, apierrors.UnauthorizedError, response.Message)
+
+	_, err = usermodel.New(suite.db).FindByEmail(context.Background(), "test@test.com")
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerWrongAudience() {
+	t := suite.T()
+
+	suite.provider.SetClaims(map[string]interface{}{"aud": "another-client"})
+
+	recorder := suite.login("/oauth")
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerExpiredIDToken() {
+	t := suite.T()
+
+	suite.provider.SetClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})
+
+	recorder := suite.login("/oauth")
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func (suite *OAuthTestSuite) TestOAuthHandlerOrganizationProvider() {
+	t := suite.T()
+
+	admin := fixtures.CreateUser("admin@test.com", "", "", "", suite.db)
+	employee := fixtures.CreateUser("employee@test.com", "", "", "", suite.db)
+	suite.verifyEmail(employee)
+	organization := fixtures.CreateOrganization(
+		"",
+		[]common.Tuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum]{
+			common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](admin, organizationmodel.Admin),
+			common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](employee, organizationmodel.ReadOnly),
+		},
+		nil,
+		suite.db,
+	)
+	session, err := apiutils.CreateJWT(admin.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	// Organizations without a provider of their own can't be signed in through
 	recorder := httptest.NewRecorder()
-	urlq := request.URL.Query()
-	urlq.Add("state", "random-string")
-	urlq.Add("code", "code")
-	request.URL.RawQuery = urlq.Encode()
+	suite.Server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/oauth/"+organization.ID.Hex(), nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
 
-	logger, _ := logger.NewZapLogger()
+	suite.tenantProvider.SetClaims(map[string]interface{}{
+		"sub":   "keycloak-user",
+		"email": "outsider@test.com",
+	})
 
-	h := handlers.NewOAuthHandler(suite.db, &oauth2.Config{}, logger, &fixtures.MockHTTPClient{}, mockOAuthClient)
-	suite.Server.GET("/callback", h.Callback)
-	suite.Server.ServeHTTP(recorder, request)
+	putProvider := func(request handlers.OIDCProviderRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(request)
+		assert.NoError(t, err)
+
+		httpRequest := httptest.NewRequest(http.MethodPut, "/organizations/oidc", bytes.NewReader(body))
+		httpRequest.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		httpRequest.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", session))
+		httpRequest.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		recorder := httptest.NewRecorder()
+		suite.Server.ServeHTTP(recorder, httpRequest)
+
+		return recorder
+	}
+
+	// Issuers are discovered before they are saved
+	recorder = putProvider(handlers.OIDCProviderRequest{
+		Issuer:       suite.tenantProvider.URL + "/not-an-issuer",
+		ClientID:     "togglelabs-company",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oauth/" + organization.ID.Hex() + "/callback",
+	})
+	var errorResponse apierrors.Error
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &errorResponse))
+	assert.Equal(t, apierrors.InvalidOIDCProviderError, errorResponse.Message)
+
+	// Providers of organizations are only reached over https
+	recorder = putProvider(handlers.OIDCProviderRequest{
+		Issuer:       suite.provider.URL,
+		ClientID:     "togglelabs",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oauth/" + organization.ID.Hex() + "/callback",
+	})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &errorResponse))
+	assert.Equal(t, apierrors.InvalidOIDCProviderError, errorResponse.Message)
+
+	recorder = putProvider(handlers.OIDCProviderRequest{
+		Issuer:       suite.tenantProvider.URL,
+		ClientID:     "togglelabs-company",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oauth/" + organization.ID.Hex() + "/callback",
+	})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "secret")
+
+	recorder = httptest.NewRecorder()
+	suite.Server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/oauth/"+organization.ID.Hex(), nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, recorder.Code)
+	location, err := url.Parse(recorder.Header().Get(echo.HeaderLocation))
+	assert.NoError(t, err)
+	assert.Equal(t, "togglelabs-company", location.Query().Get("client_id"))
+
+	// Logins started for the organization can't finish at the deployment callback
+	cookie, request := suite.signIn("/oauth/" + organization.ID.Hex())
+	request.URL.Path = "/callback"
+	recorder = suite.callback(cookie, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// The provider only signs in members of the organization
+	recorder = suite.login("/oauth/" + organization.ID.Hex())
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &errorResponse))
+	assert.Equal(t, apierrors.OrganizationSignInError, errorResponse.Message)
+
+	_, err = usermodel.New(suite.db).FindByEmail(context.Background(), "outsider@test.com")
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+	suite.tenantProvider.SetClaims(map[string]interface{}{
+		"sub":   "keycloak-user",
+		"email": "employee@test.com",
+	})
+
+	// Existing accounts are linked explicitly
+	recorder = suite.login("/oauth/" + organization.ID.Hex())
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &errorResponse))
+	assert.Equal(t, apierrors.AccountLinkRequiredError, errorResponse.Message)
+
+	userModel := usermodel.New(suite.db)
+	record, err := userModel.FindByID(context.Background(), employee.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, record.Identities)
+
+	identity := usermodel.NewIdentity(suite.tenantProvider.URL, "keycloak-user", "employee@test.com")
+	assert.NoError(t, userModel.AddIdentity(context.Background(), employee.ID, identity))
+
+	recorder = suite.login("/oauth/" + organization.ID.Hex())
 	var response common.AuthResponse
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
 	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
-	assert.Equal(t, user.Email, response.Email)
-	assert.NotEmpty(t, response.Token)
+	assert.Equal(t, "employee@test.com", response.Email)
+
+	// Members provisioned through SCIM are claimed by the organization
+	provisioned := &usermodel.UserRecord{Email: "provisioned@test.com"}
+	_, err = userModel.InsertOne(context.Background(), provisioned)
+	assert.NoError(t, err)
+	assert.NoError(t, organizationmodel.New(suite.db).AddMember(context.Background(), organization.ID,
+		&organizationmodel.OrganizationMember{User: *provisioned, PermissionLevel: organizationmodel.ReadOnly}))
+	suite.tenantProvider.SetClaims(map[string]interface{}{
+		"sub":   "keycloak-provisioned",
+		"email": "provisioned@test.com",
+	})
+
+	recorder = suite.login("/oauth/" + organization.ID.Hex())
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	record, err = userModel.FindByIdentity(context.Background(), suite.tenantProvider.URL, "keycloak-provisioned")
+	assert.NoError(t, err)
+	assert.Equal(t, provisioned.ID, record.ID)
+
+	// The deployment provider still signs in its own users
+	recorder = suite.login("/oauth")
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, "test@test.com", response.Email)
 }
 
 func TestOAuthHandler(t *testing.T) {
@@ -3,9 +3,12 @@ package handlers
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
+	"time"
 
 	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/events"
 	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
 	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
 	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
@@ -19,14 +22,23 @@ import (
 )
 
 type OrganizationHandler struct {
-	db     *mongo.Database
-	logger *zap.Logger
+	db        *mongo.Database
+	logger    *zap.Logger
+	publisher events.Publisher
 }
 
 type OrganizationPostRequest struct {
 	Name string `json:"name" validate:"required"`
 }
 
+type OrganizationSettingsPatchRequest struct {
+	RequireTwoFactor *bool `json:"require_two_factor" validate:"required"`
+}
+
+type IPAllowlistPutRequest struct {
+	Dashboard []string `json:"dashboard" validate:"dive,cidr"`
+}
+
 type ProjectPostRequest struct {
 	Name        string `json:"name" validate:"required"`
 	Description string `json:"description" validate:"required"`
@@ -93,6 +105,7 @@ func (oh *OrganizationHandler) PostOrganization(c echo.Context) error {
 	}
 
 	user.Password = ""
+	user.TwoFactor = nil
 
 	organization := organizationmodel.NewOrganizationRecord(request.Name, []organizationmodel.OrganizationMember{{
 		User:            *user,
@@ -113,24 +126,20 @@ func (oh *OrganizationHandler) PostOrganization(c echo.Context) error {
 		)
 	}
 
+	// The new organization owns the audit entry of its creation
+	c.Set("organization", organization.ID.Hex())
 	return c.JSON(http.StatusCreated, organization)
 }
 
 func (oh *OrganizationHandler) PostProject(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
-	if err != nil {
-		oh.logger.Debug("Client error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusBadRequest,
-			apierrors.BadRequestError,
-		)
+	userID, organizationRecord, ok := organizationFromContext(c, oh.logger)
+	if !ok {
+		return nil
 	}
+	organizationID := organizationRecord.ID
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
+	request := new(ProjectPostRequest)
+	if err := c.Bind(request); err != nil {
 		oh.logger.Debug("Client error",
 			zap.Error(err),
 		)
@@ -140,8 +149,15 @@ func (oh *OrganizationHandler) PostProject(c echo.Context) error {
 			apierrors.BadRequestError,
 		)
 	}
+
+	project := organizationmodel.NewProjectRecord(request.Name, request.Description)
+
 	organizationModel := organizationmodel.New(oh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
+	err := organizationModel.UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: organizationID}},
+		bson.D{{Key: "$push", Value: bson.M{"projects": project}}},
+	)
 	if err != nil {
 		oh.logger.Debug("Server error",
 			zap.Error(err),
@@ -152,23 +168,30 @@ func (oh *OrganizationHandler) PostProject(c echo.Context) error {
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		oh.logger.Debug("Client error",
-			zap.String("cause", apierrors.ForbiddenError),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
+	oh.publisher.Publish(events.New(organizationID, userID, events.ProjectCreated, project))
+	return c.JSON(http.StatusOK, project)
+}
+
+func (oh *OrganizationHandler) GetOrganization(c echo.Context) error {
+	_, organizationRecord, ok := organizationFromContext(c, oh.logger)
+	if !ok {
+		return nil
 	}
 
-	request := new(ProjectPostRequest)
-	if err := c.Bind(request); err != nil {
+	return c.JSON(http.StatusOK, organizationRecord)
+}
+
+func (oh *OrganizationHandler) DeleteProject(c echo.Context) error {
+	userID, organizationRecord, ok := organizationFromContext(c, oh.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organizationRecord.ID
+
+	projectID, err := primitive.ObjectIDFromHex(c.Param("projectID"))
+	if err != nil {
 		oh.logger.Debug("Client error",
-			zap.Error(err),
-		)
+			zap.Error(err))
 		return apierrors.CustomError(
 			c,
 			http.StatusBadRequest,
@@ -176,63 +199,118 @@ func (oh *OrganizationHandler) PostProject(c echo.Context) error {
 		)
 	}
 
-	project := organizationmodel.NewProjectRecord(request.Name, request.Description)
+	featureFlagModel := featureflagmodel.New(oh.db)
+	err = featureFlagModel.UpdateMany(context.Background(),
+		organizationID,
+		bson.D{{Key: "project._id", Value: projectID}},
+		bson.D{{
+			Key: "$unset",
+			Value: bson.M{
+				"project": 1,
+			},
+		}},
+	)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		oh.logger.Debug("Server error",
+			zap.Error(err))
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
 
-	err = organizationModel.UpdateOne(
-		context.Background(),
+	organizationModel := organizationmodel.New(oh.db)
+	err = organizationModel.UpdateOne(context.Background(),
 		bson.D{{Key: "_id", Value: organizationID}},
-		bson.D{{Key: "$push", Value: bson.M{"projects": project}}},
+		bson.D{
+			{Key: "$pull", Value: bson.D{
+				{Key: "projects", Value: bson.M{"_id": projectID}},
+			}},
+		},
 	)
 	if err != nil {
 		oh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
+			zap.Error(err))
+		return apierrors.CustomError(
+			c,
 			http.StatusInternalServerError,
 			apierrors.InternalServerError,
 		)
 	}
 
-	return c.JSON(http.StatusOK, project)
+	oh.publisher.Publish(events.New(organizationID, userID, events.ProjectDeleted, bson.M{"_id": projectID}))
+	oh.logger.Info("Project deleted",
+		zap.String("_id", projectID.Hex()))
+	return c.NoContent(http.StatusNoContent)
 }
 
-func (oh *OrganizationHandler) GetOrganization(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
-	if err != nil {
+// PatchOrganizationSettings updates the security settings of the
+// organization. Admins can only require two-factor authentication once they
+// use it, so they don't lock themselves out.
+func (oh *OrganizationHandler) PatchOrganizationSettings(c echo.Context) error {
+	userID, organizationRecord, ok := organizationFromContext(c, oh.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organizationRecord.ID
+
+	request := new(OrganizationSettingsPatchRequest)
+	if err := c.Bind(request); err != nil {
 		oh.logger.Debug("Client error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(
-			c,
+		return apierrors.CustomError(c,
 			http.StatusBadRequest,
 			apierrors.BadRequestError,
 		)
 	}
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
 		oh.logger.Debug("Client error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(
-			c,
+		return apierrors.CustomError(c,
 			http.StatusBadRequest,
 			apierrors.BadRequestError,
 		)
 	}
 
-	organizationModel := organizationmodel.New(oh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
-	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			oh.logger.Debug("Client error",
+	if *request.RequireTwoFactor {
+		userModel := usermodel.New(oh.db)
+		user, err := userModel.FindByID(context.Background(), userID)
+		if err != nil {
+			oh.logger.Debug("Server error",
 				zap.Error(err),
 			)
 			return apierrors.CustomError(c,
-				http.StatusNotFound,
-				apierrors.NotFoundError,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
 			)
 		}
+
+		if !user.TwoFactorEnabled() {
+			oh.logger.Debug("Client error",
+				zap.String("cause", apierrors.TwoFactorRequiredError),
+			)
+			return apierrors.CustomError(c,
+				http.StatusForbidden,
+				apierrors.TwoFactorRequiredError,
+			)
+		}
+	}
+
+	organizationModel := organizationmodel.New(oh.db)
+	err := organizationModel.UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: organizationID}},
+		bson.D{{Key: "$set", Value: bson.M{
+			"require_two_factor":    *request.RequireTwoFactor,
+			"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+		}}},
+	)
+	if err != nil {
 		oh.logger.Debug("Server error",
 			zap.Error(err),
 		)
@@ -242,127 +320,90 @@ func (oh *OrganizationHandler) GetOrganization(c echo.Context) error {
 		)
 	}
 
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.ReadOnly)
-	if !permission {
-		oh.logger.Debug("Client error",
-			zap.String("cause", apierrors.ForbiddenError),
+	organization, err := organizationModel.FindByID(context.Background(), organizationID)
+	if err != nil {
+		oh.logger.Debug("Server error",
+			zap.Error(err),
 		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
 		)
 	}
 
-	return c.JSON(http.StatusOK, organizationRecord)
+	return c.JSON(http.StatusOK, organization)
 }
 
-func (oh *OrganizationHandler) DeleteProject(c echo.Context) error {
-	userID, err := apiutils.GetUserFromContext(c)
-	if err != nil {
+// PutIPAllowlist replaces the networks members can reach the organization
+// from. The allowlist has to include the IP of the admin, so they don't lock
+// themselves out.
+func (oh *OrganizationHandler) PutIPAllowlist(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, oh.logger)
+	if !ok {
+		return nil
+	}
+
+	request := new(IPAllowlistPutRequest)
+	if err := c.Bind(request); err != nil {
 		oh.logger.Debug("Client error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(
-			c,
+		return apierrors.CustomError(c,
 			http.StatusBadRequest,
 			apierrors.BadRequestError,
 		)
 	}
 
-	organizationID, err := apiutils.GetOrganizationFromContext(c)
-	if err != nil {
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
 		oh.logger.Debug("Client error",
 			zap.Error(err),
 		)
-		return apierrors.CustomError(
-			c,
+		return apierrors.CustomError(c,
 			http.StatusBadRequest,
 			apierrors.BadRequestError,
 		)
 	}
 
-	organizationModel := organizationmodel.New(oh.db)
-	organizationRecord, err := organizationModel.FindByID(context.Background(), organizationID)
-	if err != nil {
-		oh.logger.Debug("Server error",
-			zap.Error(err),
-		)
-		return apierrors.CustomError(c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
-		)
+	allowlist := &organizationmodel.IPAllowlist{
+		Dashboard: request.Dashboard,
 	}
-
-	permission := apiutils.UserHasPermission(userID, organizationRecord, organizationmodel.Collaborator)
-	if !permission {
-		oh.logger.Debug("Server error",
-			zap.String("cause", apierrors.ForbiddenError),
-		)
-		return apierrors.CustomError(
-			c,
-			http.StatusForbidden,
-			apierrors.ForbiddenError,
-		)
-	}
-
-	projectID, err := primitive.ObjectIDFromHex(c.Param("projectID"))
-	if err != nil {
-		oh.logger.Debug("Server error",
-			zap.Error(err))
-		return apierrors.CustomError(
-			c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
+	if !allowlist.Allows(net.ParseIP(c.RealIP())) {
+		oh.logger.Debug("Client error",
+			zap.String("cause", apierrors.IPAllowlistLockoutError),
 		)
-	}
-
-	featureFlagModel := featureflagmodel.New(oh.db)
-	err = featureFlagModel.UpdateMany(context.Background(),
-		bson.D{{Key: "project._id", Value: projectID}},
-		bson.D{{
-			Key: "$unset",
-			Value: bson.M{
-				"project": 1,
-			},
-		}},
-	)
-	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-		oh.logger.Debug("Server error",
-			zap.Error(err))
-		return apierrors.CustomError(
-			c,
-			http.StatusInternalServerError,
-			apierrors.InternalServerError,
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.IPAllowlistLockoutError,
 		)
 	}
 
-	err = organizationModel.UpdateOne(context.Background(),
-		bson.D{{Key: "_id", Value: organizationID}},
-		bson.D{
-			{Key: "$pull", Value: bson.D{
-				{Key: "projects", Value: bson.M{"_id": projectID}},
-			}},
-		},
+	organizationModel := organizationmodel.New(oh.db)
+	err := organizationModel.UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: organization.ID}},
+		bson.D{{Key: "$set", Value: bson.M{
+			"ip_allowlist":          allowlist,
+			"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+		}}},
 	)
 	if err != nil {
 		oh.logger.Debug("Server error",
-			zap.Error(err))
-		return apierrors.CustomError(
-			c,
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
 			http.StatusInternalServerError,
 			apierrors.InternalServerError,
 		)
 	}
 
-	oh.logger.Info("Project deleted",
-		zap.String("_id", projectID.Hex()))
-	return c.NoContent(http.StatusNoContent)
+	return c.JSON(http.StatusOK, allowlist)
 }
 
-func NewOrganizationHandler(db *mongo.Database, logger *zap.Logger) *OrganizationHandler {
+func NewOrganizationHandler(db *mongo.Database, logger *zap.Logger, publisher events.Publisher) *OrganizationHandler {
 	return &OrganizationHandler{
-		db:     db,
-		logger: logger,
+		db:        db,
+		logger:    logger,
+		publisher: publisher,
 	}
 }
@@ -16,6 +16,7 @@ import (
 	"github.com/Roll-Play/togglelabs/pkg/api/handlers/fixtures"
 	"github.com/Roll-Play/togglelabs/pkg/api/middlewares"
 	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/events"
 	"github.com/Roll-Play/togglelabs/pkg/logger"
 	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
 	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
@@ -25,6 +26,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -45,13 +47,30 @@ func (suite *OrganizationHandlerTestSuite) SetupTest() {
 
 	logger, _ := logger.NewZapLogger()
 
-	h := handlers.NewOrganizationHandler(suite.db, logger)
-	suite.Server.POST("/organizations", middlewares.AuthMiddleware(h.PostOrganization))
+	h := handlers.NewOrganizationHandler(suite.db, logger, events.NewBus())
+	suite.Server.POST("/organizations", middlewares.AuthMiddleware(suite.db)(h.PostOrganization))
 
-	testGroup := suite.Server.Group("", middlewares.AuthMiddleware, middlewares.OrganizationMiddleware)
-	testGroup.POST("/projects", h.PostProject)
-	testGroup.GET("/organizations", middlewares.AuthMiddleware(h.GetOrganization))
-	testGroup.DELETE("/projects/:projectID", middlewares.AuthMiddleware(h.DeleteProject))
+	testGroup := suite.Server.Group(
+		"",
+		middlewares.AuthMiddleware(suite.db),
+		middlewares.OrganizationMiddleware,
+	)
+	ipAllowlist := middlewares.IPAllowlist(suite.db)
+	canEditProjects := middlewares.RequirePermission(suite.db, organizationmodel.ProjectEdit)
+	testGroup.POST("/projects", h.PostProject, canEditProjects, ipAllowlist)
+	testGroup.GET(
+		"/organizations",
+		h.GetOrganization,
+		middlewares.RequireLevel(suite.db, organizationmodel.ReadOnly),
+		ipAllowlist,
+	)
+	testGroup.DELETE("/projects/:projectID", h.DeleteProject, canEditProjects, ipAllowlist)
+	testGroup.PUT(
+		"/organizations/ip-allowlist",
+		h.PutIPAllowlist,
+		middlewares.RequireLevel(suite.db, organizationmodel.Admin),
+		ipAllowlist,
+	)
 }
 
 func (suite *OrganizationHandlerTestSuite) AfterTest(_, _ string) {
@@ -261,6 +280,35 @@ func (suite *OrganizationHandlerTestSuite) TestGetOrganizationHandlerUnauthorize
 	}, response)
 }
 
+func (suite *OrganizationHandlerTestSuite) TestGetOrganizationHandlerUnknownOrganization() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("", "", "", "", suite.db)
+
+	token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+	assert.NoError(t, err)
+
+	request := httptest.NewRequest(
+		http.MethodGet,
+		"/organizations",
+		nil,
+	)
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+	request.Header.Set(middlewares.XOrganizationHeader, primitive.NewObjectID().Hex())
+	recorder := httptest.NewRecorder()
+
+	suite.Server.ServeHTTP(recorder, request)
+
+	var response api_errors.Error
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, api_errors.Error{
+		Error:   http.StatusText(http.StatusForbidden),
+		Message: api_errors.ForbiddenError,
+	}, response)
+}
+
 func (suite *OrganizationHandlerTestSuite) TestDeleteProjectHandlerSuccess() {
 	t := suite.T()
 
@@ -300,7 +348,7 @@ func (suite *OrganizationHandlerTestSuite) TestDeleteProjectHandlerSuccess() {
 	assert.Equal(t, 1, len(updatedOrganization.Projects))
 	assert.Equal(t, []organizationmodel.Project{organization.Projects[1]}, updatedOrganization.Projects)
 	featureFlagModel := featureflagmodel.New(suite.db)
-	updatedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), featureFlagRecord.ID)
+	updatedFeatureFlag, err := featureFlagModel.FindByID(context.Background(), organization.ID, featureFlagRecord.ID)
 	assert.NoError(t, err)
 	assert.Nil(t, updatedFeatureFlag.Project)
 }
@@ -346,6 +394,96 @@ func (suite *OrganizationHandlerTestSuite) TestDeleteProjectUnauthorized() {
 	}, response)
 }
 
+func (suite *OrganizationHandlerTestSuite) TestIPAllowlist() {
+	t := suite.T()
+
+	admin := fixtures.CreateUser("", "", "", "", suite.db)
+	collaborator := fixtures.CreateUser("", "", "", "", suite.db)
+	organization := fixtures.CreateOrganization("the company", []common.Tuple[*usermodel.UserRecord, string]{
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			admin,
+			organizationmodel.Admin,
+		),
+		common.NewTuple[*usermodel.UserRecord, organizationmodel.PermissionLevelEnum](
+			collaborator,
+			organizationmodel.Collaborator,
+		),
+	}, nil, suite.db)
+
+	send := func(method, path string, body interface{}, user *usermodel.UserRecord, ip string) *httptest.ResponseRecorder {
+		requestBody, err := json.Marshal(body)
+		assert.NoError(t, err)
+
+		token, err := apiutils.CreateJWT(user.ID, time.Second*120)
+		assert.NoError(t, err)
+
+		request := httptest.NewRequest(method, path, bytes.NewBuffer(requestBody))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		request.Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", token))
+		request.Header.Set(middlewares.XOrganizationHeader, organization.ID.Hex())
+		request.Header.Set(echo.HeaderXRealIP, ip)
+		recorder := httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	allowlist := handlers.IPAllowlistPutRequest{
+		Dashboard: []string{"10.8.0.0/16"},
+	}
+
+	recorder := send(http.MethodPut, "/organizations/ip-allowlist", allowlist, collaborator, "10.8.1.2")
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	recorder = send(http.MethodPut, "/organizations/ip-allowlist", handlers.IPAllowlistPutRequest{
+		Dashboard: []string{"10.8.0.1"},
+	}, admin, "10.8.1.2")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// Admins can't lock themselves out
+	recorder = send(http.MethodPut, "/organizations/ip-allowlist", allowlist, admin, "203.0.113.9")
+	var response api_errors.Error
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, api_errors.IPAllowlistLockoutError, response.Message)
+
+	recorder = send(http.MethodPut, "/organizations/ip-allowlist", allowlist, admin, "10.8.1.2")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = send(http.MethodGet, "/organizations", nil, collaborator, "10.8.200.3")
+	var saved organizationmodel.OrganizationRecord
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &saved))
+	assert.Equal(t, &organizationmodel.IPAllowlist{
+		Dashboard: allowlist.Dashboard,
+	}, saved.IPAllowlist)
+
+	recorder = send(http.MethodGet, "/organizations", nil, collaborator, "203.0.113.9")
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, api_errors.IPNotAllowedError, response.Message)
+
+	// Others don't learn the organization has an allowlist
+	outsider := fixtures.CreateUser("outsider@test.com", "", "", "", suite.db)
+	recorder = send(http.MethodGet, "/organizations", nil, outsider, "203.0.113.9")
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, api_errors.ForbiddenError, response.Message)
+
+	// Admins are held to the allowlist too
+	recorder = send(http.MethodPut, "/organizations/ip-allowlist", handlers.IPAllowlistPutRequest{}, admin, "203.0.113.9")
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	recorder = send(http.MethodPut, "/organizations/ip-allowlist", handlers.IPAllowlistPutRequest{}, admin, "10.8.1.2")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = send(http.MethodGet, "/organizations", nil, collaborator, "203.0.113.9")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
 func TestOrganizationHandlerTestSuite(t *testing.T) {
 	suite.Run(t, new(OrganizationHandlerTestSuite))
 }
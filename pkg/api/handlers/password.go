@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/api/common"
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+	tokenmodel "github.com/Roll-Play/togglelabs/pkg/models/token"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const PasswordResetTTL = time.Hour
+
+type PasswordHandler struct {
+	db           *mongo.Database
+	logger       *zap.Logger
+	mailer       *mailer.Sender
+	dashboardURL string
+	// Serializes reset emails so concurrent requests can't skip the rate limit
+	resets sync.Mutex
+}
+
+func NewPasswordHandler(
+	db *mongo.Database,
+	logger *zap.Logger,
+	sender *mailer.Sender,
+	dashboardURL string,
+) *PasswordHandler {
+	return &PasswordHandler{
+		db:           db,
+		logger:       logger,
+		mailer:       sender,
+		dashboardURL: dashboardURL,
+	}
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"gte=8,max=72"`
+}
+
+type ChangePasswordRequest struct {
+	// Users without a password set one right after signing in instead
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" validate:"gte=8,max=72"`
+}
+
+// PostPasswordResetRequest emails a reset link to the user. The link is made
+// in the background so every request is answered the same way and as fast,
+// neither the response nor its timing tells whether an email has an account.
+func (ph *PasswordHandler) PostPasswordResetRequest(c echo.Context) error {
+	request := new(PasswordResetRequest)
+	if err := c.Bind(request); err != nil {
+		ph.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		ph.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	go ph.sendPasswordReset(context.Background(), request.Email)
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (ph *PasswordHandler) sendPasswordReset(ctx context.Context, email string) {
+	ph.resets.Lock()
+	defer ph.resets.Unlock()
+
+	if err := ph.createPasswordReset(ctx, email); err != nil {
+		ph.logger.Error("Unable to send password reset",
+			zap.Error(err),
+		)
+	}
+}
+
+func (ph *PasswordHandler) createPasswordReset(ctx context.Context, email string) error {
+	userModel := usermodel.New(ph.db)
+	user, err := userModel.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+
+	tokenModel := tokenmodel.New(ph.db)
+	now := time.Now().UTC()
+	recent, err := tokenModel.FindLatestSince(ctx, user.ID, tokenmodel.PasswordReset, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+
+	// Dropped silently, answering otherwise would reveal the account
+	if tokenRetryAfter(recent, now) > 0 {
+		ph.logger.Debug("Password reset rate limited",
+			zap.String("_id", user.ID.Hex()),
+		)
+		return nil
+	}
+
+	// Only the latest link works, older emails may have leaked
+	if err := tokenModel.Revoke(ctx, user.ID, tokenmodel.PasswordReset); err != nil {
+		return err
+	}
+
+	token, hash, err := tokenmodel.Generate()
+	if err != nil {
+		return err
+	}
+
+	record := tokenmodel.NewTokenRecord(user.ID, tokenmodel.PasswordReset, hash, PasswordResetTTL)
+	if _, err := tokenModel.InsertOne(ctx, record); err != nil {
+		return err
+	}
+
+	name := user.FirstName
+	if name == "" {
+		name = user.Email
+	}
+
+	return ph.mailer.Send(mailer.PasswordResetTemplate, user.Email, mailer.PasswordResetData{
+		Name:      name,
+		Link:      strings.TrimRight(ph.dashboardURL, "/") + "/reset-password?token=" + token,
+		ExpiresIn: PasswordResetTTL,
+	})
+}
+
+// PostPasswordReset sets the password of the user the reset token was sent to
+// and signs them out of every session.
+func (ph *PasswordHandler) PostPasswordReset(c echo.Context) error {
+	request := new(PasswordResetConfirmRequest)
+	if err := c.Bind(request); err != nil {
+		ph.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		ph.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	tokenModel := tokenmodel.New(ph.db)
+	token, err := tokenModel.Consume(context.Background(), tokenmodel.PasswordReset, request.Token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ph.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusBadRequest,
+				apierrors.InvalidTokenError,
+			)
+		}
+
+		ph.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	userModel := usermodel.New(ph.db)
+	if err := userModel.UpdatePassword(context.Background(), token.UserID, request.Password); err != nil {
+		ph.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	c.Set("user", token.UserID.Hex())
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PatchPassword changes the password of the signed in user, who has to prove
+// they know the current one, or sets the first one of users signed up through
+// a provider. Other sessions are signed out and the response carries a new
+// token for this one.
+func (ph *PasswordHandler) PatchPassword(c echo.Context) error {
+	userID, err := apiutils.GetUserFromContext(c)
+	if err != nil {
+		ph.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusUnauthorized,
+			apierrors.UnauthorizedError,
+		)
+	}
+
+	request := new(ChangePasswordRequest)
+	if err := c.Bind(request); err != nil {
+		ph.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		ph.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	userModel := usermodel.New(ph.db)
+	user, err := userModel.FindByID(context.Background(), userID)
+	if err != nil {
+		ph.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	if !reauthenticate(c, ph.logger, user, request.CurrentPassword) {
+		return nil
+	}
+
+	if err := userModel.UpdatePassword(context.Background(), userID, request.NewPassword); err != nil {
+		ph.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	token, err := apiutils.CreateSessionJWT(
+		userID,
+		user.SessionVersion+1,
+		apiutils.GetSessionSecondFactorFromContext(c),
+		config.JWTExpireTime,
+	)
+	if err != nil {
+		ph.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, common.AuthResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		EmailVerified: user.EmailVerified,
+		Token:         token,
+	})
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var EmptyRoleList = []organizationmodel.Role{}
+
+// RoleHandler manages the custom roles of an organization. Only admins can
+// change them, as roles can grant any permission.
+type RoleHandler struct {
+	db     *mongo.Database
+	logger *zap.Logger
+}
+
+type RoleRequest struct {
+	Name        string                    `json:"name" validate:"required"`
+	Description string                    `json:"description"`
+	Grants      []organizationmodel.Grant `json:"grants" validate:"required,dive"`
+}
+
+type MemberRolesRequest struct {
+	Roles []primitive.ObjectID `json:"roles" validate:"required"`
+}
+
+func (rh *RoleHandler) ListRoles(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, rh.logger)
+	if !ok {
+		return nil
+	}
+
+	roles := organization.Roles
+	if roles == nil {
+		roles = EmptyRoleList
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (rh *RoleHandler) PostRole(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, rh.logger)
+	if !ok {
+		return nil
+	}
+
+	request, ok := rh.bindRole(c, organization)
+	if !ok {
+		return nil
+	}
+
+	role := organizationmodel.NewRole(request.Name, request.Description, request.Grants)
+	organizationModel := organizationmodel.New(rh.db)
+	if err := organizationModel.AddRole(context.Background(), organization.ID, role); err != nil {
+		rh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, role)
+}
+
+func (rh *RoleHandler) PutRole(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, rh.logger)
+	if !ok {
+		return nil
+	}
+
+	roleID, err := primitive.ObjectIDFromHex(c.Param("roleID"))
+	if err != nil {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	request, ok := rh.bindRole(c, organization)
+	if !ok {
+		return nil
+	}
+
+	role := organizationmodel.NewRole(request.Name, request.Description, request.Grants)
+	role.ID = roleID
+
+	organizationModel := organizationmodel.New(rh.db)
+	if err := organizationModel.ReplaceRole(context.Background(), organization.ID, role); err != nil {
+		return rh.roleError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, role)
+}
+
+// DeleteRole deletes the role and takes it from the members who had it.
+func (rh *RoleHandler) DeleteRole(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, rh.logger)
+	if !ok {
+		return nil
+	}
+
+	roleID, err := primitive.ObjectIDFromHex(c.Param("roleID"))
+	if err != nil {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	organizationModel := organizationmodel.New(rh.db)
+	if err := organizationModel.RemoveRole(context.Background(), organization.ID, roleID); err != nil {
+		return rh.roleError(c, err)
+	}
+
+	rh.logger.Info("Role deleted",
+		zap.String("_id", roleID.Hex()),
+	)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PutMemberRoles replaces the roles of a member of the organization.
+func (rh *RoleHandler) PutMemberRoles(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, rh.logger)
+	if !ok {
+		return nil
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(c.Param("userID"))
+	if err != nil {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	request := new(MemberRolesRequest)
+	if err := c.Bind(request); err != nil {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	for _, roleID := range request.Roles {
+		if organization.Role(roleID) == nil {
+			rh.logger.Debug("Client error",
+				zap.String("role", roleID.Hex()),
+			)
+			return apierrors.CustomError(c,
+				http.StatusBadRequest,
+				apierrors.BadRequestError,
+			)
+		}
+	}
+
+	organizationModel := organizationmodel.New(rh.db)
+	err = organizationModel.UpdateMember(
+		context.Background(),
+		organization.ID,
+		memberID,
+		bson.M{"roles": request.Roles},
+	)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			rh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
+
+		rh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// bindRole reads the role of the request, whose grants have to name known
+// permissions and projects of the organization. Every member already reads
+// every flag through their permission level, so read grants can't be scoped.
+// When it can't, the error response has already been written.
+func (rh *RoleHandler) bindRole(
+	c echo.Context,
+	organization *organizationmodel.OrganizationRecord,
+) (*RoleRequest, bool) {
+	request := new(RoleRequest)
+	if err := c.Bind(request); err != nil {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+		return nil, false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+		return nil, false
+	}
+
+	for _, grant := range request.Grants {
+		scopedRead := grant.Permission == organizationmodel.FlagRead &&
+			(len(grant.Projects) > 0 || len(grant.Environments) > 0)
+		if !organizationmodel.IsPermission(grant.Permission) || !hasProjects(organization, grant.Projects) || scopedRead {
+			rh.logger.Debug("Client error",
+				zap.String("permission", grant.Permission),
+			)
+			_ = apierrors.CustomError(c,
+				http.StatusBadRequest,
+				apierrors.BadRequestError,
+			)
+			return nil, false
+		}
+	}
+
+	return request, true
+}
+
+func (rh *RoleHandler) roleError(c echo.Context, err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		rh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusNotFound,
+			apierrors.NotFoundError,
+		)
+	}
+
+	rh.logger.Debug("Server error",
+		zap.Error(err),
+	)
+	return apierrors.CustomError(c,
+		http.StatusInternalServerError,
+		apierrors.InternalServerError,
+	)
+}
+
+func hasProjects(organization *organizationmodel.OrganizationRecord, projectIDs []primitive.ObjectID) bool {
+	for _, projectID := range projectIDs {
+		found := false
+		for _, project := range organization.Projects {
+			found = found || project.ID == projectID
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func NewRoleHandler(db *mongo.Database, logger *zap.Logger) *RoleHandler {
+	return &RoleHandler{
+		db:     db,
+		logger: logger,
+	}
+}
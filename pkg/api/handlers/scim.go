@@ -0,0 +1,985 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/events"
+	"github.com/Roll-Play/togglelabs/pkg/models"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	tokenmodel "github.com/Roll-Play/togglelabs/pkg/models/token"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	"github.com/Roll-Play/togglelabs/pkg/scim"
+	"github.com/Roll-Play/togglelabs/pkg/storage"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// SCIMBasePath is where identity providers reach the SCIM API.
+const SCIMBasePath = "/scim/v2"
+
+var errLastAdmin = errors.New(apierrors.LastAdminError)
+
+// SCIMHandler lets the identity provider of an organization manage its
+// members. SCIM users are the members of the organization and SCIM groups
+// its permission levels, every member belonging to exactly one of them.
+type SCIMHandler struct {
+	db        *mongo.Database
+	logger    *zap.Logger
+	publisher events.Publisher
+}
+
+// SCIMTokenResponse is the only response carrying the provisioning token.
+type SCIMTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// PostSCIMToken enables provisioning for the organization, replacing its
+// previous token if any.
+func (sh *SCIMHandler) PostSCIMToken(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, sh.logger)
+	if !ok {
+		return nil
+	}
+
+	token, hash, err := tokenmodel.Generate()
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+	organizationModel := organizationmodel.New(sh.db)
+	err = organizationModel.UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: organization.ID}},
+		bson.D{{Key: "$set", Value: bson.M{
+			"scim": organizationmodel.SCIMProvisioning{
+				TokenHash: hash,
+				CreatedAt: now,
+			},
+			"timestamps.updated_at": now,
+		}}},
+	)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, SCIMTokenResponse{Token: token})
+}
+
+// DeleteSCIMToken disables provisioning for the organization.
+func (sh *SCIMHandler) DeleteSCIMToken(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, sh.logger)
+	if !ok {
+		return nil
+	}
+
+	organizationModel := organizationmodel.New(sh.db)
+	err := organizationModel.UpdateOne(
+		context.Background(),
+		bson.D{{Key: "_id", Value: organization.ID}},
+		bson.D{
+			{Key: "$unset", Value: bson.M{"scim": 1}},
+			{Key: "$set", Value: bson.M{
+				"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+			}},
+		},
+	)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Authenticate is the middleware of the SCIM API. It puts the organization of
+// the provisioning token of the request in context.
+func (sh *SCIMHandler) Authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authHeader := c.Request().Header.Get(echo.HeaderAuthorization)
+		token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+		if token == "" || token == authHeader {
+			sh.logger.Debug("Client error",
+				zap.String("cause", "missing bearer token"),
+			)
+			return scimError(c, http.StatusUnauthorized, "", apierrors.UnauthorizedError)
+		}
+
+		organizationModel := organizationmodel.New(sh.db)
+		organization, err := organizationModel.FindBySCIMToken(context.Background(), tokenmodel.Hash(token))
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				sh.logger.Debug("Client error",
+					zap.Error(err),
+				)
+				return scimError(c, http.StatusUnauthorized, "", apierrors.UnauthorizedError)
+			}
+
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return scimError(c, http.StatusInternalServerError, "", apierrors.InternalServerError)
+		}
+
+		// The token proves the organization, its audit chain records the
+		// request without a member behind it
+		c.Set("organization", organization.ID.Hex())
+		c.Set("organization_verified", true)
+		return next(c)
+	}
+}
+
+func (sh *SCIMHandler) GetServiceProviderConfig(c echo.Context) error {
+	return scimJSON(c, http.StatusOK, scim.NewServiceProviderConfig(config.MaxPageSize))
+}
+
+func (sh *SCIMHandler) ListUsers(c echo.Context) error {
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	members := organization.Members
+	if filter := c.QueryParam("filter"); filter != "" {
+		parsed, err := scim.ParseFilter(filter)
+		if err != nil {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return scimError(c, http.StatusBadRequest, scim.InvalidFilter, err.Error())
+		}
+
+		members = make([]organizationmodel.OrganizationMember, 0)
+		for _, member := range organization.Members {
+			var matches bool
+			switch parsed.Attribute {
+			case "username", "emails.value", "emails":
+				matches = strings.EqualFold(member.User.Email, parsed.Value)
+			case "externalid":
+				matches = member.ExternalID == parsed.Value
+			case "id":
+				matches = member.User.ID.Hex() == parsed.Value
+			default:
+				sh.logger.Debug("Client error",
+					zap.Error(scim.ErrInvalidFilter),
+				)
+				return scimError(c, http.StatusBadRequest, scim.InvalidFilter, scim.ErrInvalidFilter.Error())
+			}
+
+			if matches {
+				members = append(members, member)
+			}
+		}
+	}
+
+	startIndex, count := scimPaginationParams(c.QueryParam("startIndex"), c.QueryParam("count"))
+	resources := make([]*scim.User, 0, count)
+	for i := startIndex - 1; i < len(members) && len(resources) < count; i++ {
+		resources = append(resources, userResource(c, &members[i]))
+	}
+
+	return scimJSON(c, http.StatusOK, scim.NewListResponse(resources, len(members), startIndex, len(resources)))
+}
+
+func (sh *SCIMHandler) GetUser(c echo.Context) error {
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	member, ok := sh.findMember(c, organization, c.Param("userID"))
+	if !ok {
+		return nil
+	}
+
+	return scimJSON(c, http.StatusOK, userResource(c, member))
+}
+
+// PostUser makes the user of the request a read only member of the
+// organization, creating an account without a way to sign in yet when the
+// email isn't known. Such accounts are claimed by signing in with the
+// identity provider or resetting their password. Existing accounts join
+// through invites instead, so identity providers can't add them to
+// organizations they didn't choose.
+func (sh *SCIMHandler) PostUser(c echo.Context) error {
+	request := new(scim.User)
+	if err := bindSCIM(c, request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return scimError(c, http.StatusBadRequest, scim.InvalidSyntax, apierrors.BadRequestError)
+	}
+
+	email := strings.TrimSpace(request.Email())
+	if email == "" {
+		sh.logger.Debug("Client error",
+			zap.String("cause", "missing userName"),
+		)
+		return scimError(c, http.StatusBadRequest, scim.InvalidValue, "userName is required")
+	}
+
+	if request.Active != nil && !*request.Active {
+		sh.logger.Debug("Client error",
+			zap.String("cause", "inactive user"),
+		)
+		return scimError(c, http.StatusBadRequest, scim.InvalidValue, "inactive users can't be provisioned")
+	}
+
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	for _, member := range organization.Members {
+		if strings.EqualFold(member.User.Email, email) {
+			sh.logger.Debug("Client error",
+				zap.String("cause", "already a member"),
+			)
+			return scimError(c, http.StatusConflict, scim.Uniqueness, "user already a member of the organization")
+		}
+	}
+
+	userModel := usermodel.New(sh.db)
+	user, err := userModel.FindByEmail(context.Background(), email)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		user = &usermodel.UserRecord{
+			Email: email,
+			Timestamps: models.Timestamps{
+				CreatedAt: primitive.NewDateTimeFromTime(time.Now().UTC()),
+				UpdatedAt: primitive.NewDateTimeFromTime(time.Now().UTC()),
+			},
+		}
+		if request.Name != nil {
+			user.FirstName = request.Name.GivenName
+			user.LastName = request.Name.FamilyName
+		}
+
+		_, err = userModel.InsertOne(context.Background(), user)
+	} else if err == nil {
+		sh.logger.Debug("Client error",
+			zap.String("cause", "account already exists"),
+		)
+		return scimError(c, http.StatusConflict, scim.Uniqueness, "user already exists, invite them instead")
+	}
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return scimError(c, http.StatusInternalServerError, "", apierrors.InternalServerError)
+	}
+
+	user.Password = ""
+	user.TwoFactor = nil
+	user.Identities = nil
+
+	member := &organizationmodel.OrganizationMember{
+		User:            *user,
+		PermissionLevel: organizationmodel.ReadOnly,
+		ExternalID:      request.ExternalID,
+	}
+
+	organizationModel := organizationmodel.New(sh.db)
+	if err := organizationModel.AddMember(context.Background(), organization.ID, member); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return scimError(c, http.StatusConflict, scim.Uniqueness, "user already a member of the organization")
+		}
+
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return scimError(c, http.StatusInternalServerError, "", apierrors.InternalServerError)
+	}
+
+	sh.publisher.Publish(events.New(organization.ID, primitive.NilObjectID, events.MemberAdded, member))
+	sh.logger.Info("Member provisioned",
+		zap.String("organization", organization.ID.Hex()),
+		zap.String("_id", user.ID.Hex()),
+	)
+
+	resource := userResource(c, member)
+	c.Response().Header().Set(echo.HeaderLocation, resource.Meta.Location)
+	return scimJSON(c, http.StatusCreated, resource)
+}
+
+// PutUser replaces the membership of the user. Profile attributes belong to
+// the user, so only externalId and active are taken.
+func (sh *SCIMHandler) PutUser(c echo.Context) error {
+	request := new(scim.User)
+	if err := bindSCIM(c, request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return scimError(c, http.StatusBadRequest, scim.InvalidSyntax, apierrors.BadRequestError)
+	}
+
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	member, ok := sh.findMember(c, organization, c.Param("userID"))
+	if !ok {
+		return nil
+	}
+
+	active := request.Active == nil || *request.Active
+	return sh.updateMember(c, organization, member, active, &request.ExternalID)
+}
+
+// PatchUser applies the operations of the request to the membership of the
+// user. Like PutUser it only takes externalId and active.
+func (sh *SCIMHandler) PatchUser(c echo.Context) error {
+	request := new(scim.PatchRequest)
+	if err := bindSCIM(c, request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return scimError(c, http.StatusBadRequest, scim.InvalidSyntax, apierrors.BadRequestError)
+	}
+
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	member, ok := sh.findMember(c, organization, c.Param("userID"))
+	if !ok {
+		return nil
+	}
+
+	active := true
+	var externalID *string
+	for _, operation := range request.Operations {
+		if operation.Operation() != "add" && operation.Operation() != "replace" {
+			sh.logger.Debug("Client error",
+				zap.String("op", operation.Op),
+			)
+			return scimError(c, http.StatusBadRequest, scim.InvalidPath, scim.ErrInvalidPath.Error())
+		}
+
+		attributes, err := operation.Attributes()
+		if err != nil {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return scimError(c, http.StatusBadRequest, scim.InvalidValue, err.Error())
+		}
+
+		for name, value := range attributes {
+			switch name {
+			case "active":
+				active, err = scim.Bool(value)
+			case "externalid":
+				var text string
+				text, err = scim.String(value)
+				externalID = &text
+			}
+
+			if err != nil {
+				sh.logger.Debug("Client error",
+					zap.Error(err),
+				)
+				return scimError(c, http.StatusBadRequest, scim.InvalidValue, err.Error())
+			}
+		}
+	}
+
+	return sh.updateMember(c, organization, member, active, externalID)
+}
+
+// DeleteUser removes the user from the organization. The account itself is
+// left alone, as it may be a member of other organizations.
+func (sh *SCIMHandler) DeleteUser(c echo.Context) error {
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	member, ok := sh.findMember(c, organization, c.Param("userID"))
+	if !ok {
+		return nil
+	}
+
+	if !sh.removeMember(c, organization, member) {
+		return nil
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (sh *SCIMHandler) ListGroups(c echo.Context) error {
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	levels := organizationmodel.PermissionLevels
+	if filter := c.QueryParam("filter"); filter != "" {
+		parsed, err := scim.ParseFilter(filter)
+		if err != nil || (parsed.Attribute != "displayname" && parsed.Attribute != "id") {
+			sh.logger.Debug("Client error",
+				zap.String("filter", filter),
+			)
+			return scimError(c, http.StatusBadRequest, scim.InvalidFilter, scim.ErrInvalidFilter.Error())
+		}
+
+		levels = make([]organizationmodel.PermissionLevelEnum, 0)
+		for _, level := range organizationmodel.PermissionLevels {
+			if strings.EqualFold(level, parsed.Value) {
+				levels = append(levels, level)
+			}
+		}
+	}
+
+	resources := make([]*scim.Group, 0, len(levels))
+	for _, level := range levels {
+		resources = append(resources, groupResource(c, organization, level))
+	}
+
+	return scimJSON(c, http.StatusOK, scim.NewListResponse(resources, len(resources), 1, len(resources)))
+}
+
+func (sh *SCIMHandler) GetGroup(c echo.Context) error {
+	level, ok := sh.findGroup(c)
+	if !ok {
+		return nil
+	}
+
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	return scimJSON(c, http.StatusOK, groupResource(c, organization, level))
+}
+
+// PutGroup gives the permission level of the group to its members. Members
+// left out of it fall back to read only.
+func (sh *SCIMHandler) PutGroup(c echo.Context) error {
+	request := new(scim.Group)
+	if err := bindSCIM(c, request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return scimError(c, http.StatusBadRequest, scim.InvalidSyntax, apierrors.BadRequestError)
+	}
+
+	level, ok := sh.findGroup(c)
+	if !ok {
+		return nil
+	}
+
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	if request.DisplayName != "" && request.DisplayName != level {
+		sh.logger.Debug("Client error",
+			zap.String("cause", "group renamed"),
+		)
+		return scimError(c, http.StatusBadRequest, scim.Mutability, "groups can't be renamed")
+	}
+
+	members := make([]string, 0, len(request.Members))
+	for _, member := range request.Members {
+		members = append(members, member.Value)
+	}
+
+	levels := memberLevels(organization)
+	if !sh.replaceGroupMembers(c, levels, level, members) {
+		return nil
+	}
+
+	if !sh.applyMemberLevels(c, organization, levels) {
+		return nil
+	}
+
+	return sh.respondGroup(c, level)
+}
+
+// PatchGroup adds members to the group, giving them its permission level, or
+// removes members from it, which makes them read only.
+func (sh *SCIMHandler) PatchGroup(c echo.Context) error {
+	request := new(scim.PatchRequest)
+	if err := bindSCIM(c, request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return scimError(c, http.StatusBadRequest, scim.InvalidSyntax, apierrors.BadRequestError)
+	}
+
+	level, ok := sh.findGroup(c)
+	if !ok {
+		return nil
+	}
+
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	// Operations are applied together, later ones see the levels set by
+	// earlier ones
+	levels := memberLevels(organization)
+	for _, operation := range request.Operations {
+		members, err := operation.Members()
+		if err != nil {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			scimType := scim.InvalidValue
+			if errors.Is(err, scim.ErrInvalidPath) {
+				scimType = scim.InvalidPath
+			}
+			return scimError(c, http.StatusBadRequest, scimType, err.Error())
+		}
+
+		switch operation.Operation() {
+		case "add":
+			ok = sh.setPermissionLevel(c, levels, members, level)
+		case "remove":
+			ok = sh.removeGroupMembers(c, levels, level, members)
+		case "replace":
+			ok = sh.replaceGroupMembers(c, levels, level, members)
+		default:
+			sh.logger.Debug("Client error",
+				zap.String("op", operation.Op),
+			)
+			return scimError(c, http.StatusBadRequest, scim.InvalidSyntax, "unsupported patch operation")
+		}
+
+		if !ok {
+			return nil
+		}
+	}
+
+	if !sh.applyMemberLevels(c, organization, levels) {
+		return nil
+	}
+
+	return sh.respondGroup(c, level)
+}
+
+func (sh *SCIMHandler) respondGroup(c echo.Context, level organizationmodel.PermissionLevelEnum) error {
+	organization, ok := sh.findOrganization(c)
+	if !ok {
+		return nil
+	}
+
+	return scimJSON(c, http.StatusOK, groupResource(c, organization, level))
+}
+
+// updateMember deprovisions inactive members and records the external ID of
+// the others.
+func (sh *SCIMHandler) updateMember(
+	c echo.Context,
+	organization *organizationmodel.OrganizationRecord,
+	member *organizationmodel.OrganizationMember,
+	active bool,
+	externalID *string,
+) error {
+	if !active {
+		if !sh.removeMember(c, organization, member) {
+			return nil
+		}
+
+		resource := userResource(c, member)
+		resource.Active = &active
+		resource.Groups = nil
+		return scimJSON(c, http.StatusOK, resource)
+	}
+
+	if externalID != nil && *externalID != member.ExternalID {
+		organizationModel := organizationmodel.New(sh.db)
+		err := organizationModel.UpdateMember(
+			context.Background(),
+			organization.ID,
+			member.User.ID,
+			bson.M{"external_id": *externalID},
+		)
+		if err != nil {
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return scimError(c, http.StatusInternalServerError, "", apierrors.InternalServerError)
+		}
+
+		member.ExternalID = *externalID
+	}
+
+	return scimJSON(c, http.StatusOK, userResource(c, member))
+}
+
+// removeMember deprovisions member. When it can't, the error response has
+// already been written.
+func (sh *SCIMHandler) removeMember(
+	c echo.Context,
+	organization *organizationmodel.OrganizationRecord,
+	member *organizationmodel.OrganizationMember,
+) bool {
+	organizationModel := organizationmodel.New(sh.db)
+	if err := organizationModel.RemoveMember(context.Background(), organization.ID, member.User.ID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			_ = scimError(c, http.StatusNotFound, "", apierrors.NotFoundError)
+			return false
+		}
+
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = scimError(c, http.StatusInternalServerError, "", apierrors.InternalServerError)
+		return false
+	}
+
+	sh.publisher.Publish(events.New(organization.ID, primitive.NilObjectID, events.MemberRemoved, member))
+	sh.logger.Info("Member deprovisioned",
+		zap.String("organization", organization.ID.Hex()),
+		zap.String("_id", member.User.ID.Hex()),
+	)
+	return true
+}
+
+// memberLevels returns the permission level of every member of organization,
+// by user ID.
+func memberLevels(
+	organization *organizationmodel.OrganizationRecord,
+) map[primitive.ObjectID]organizationmodel.PermissionLevelEnum {
+	levels := make(map[primitive.ObjectID]organizationmodel.PermissionLevelEnum, len(organization.Members))
+	for _, member := range organization.Members {
+		levels[member.User.ID] = member.PermissionLevel
+	}
+
+	return levels
+}
+
+// replaceGroupMembers makes members the only ones with level, the others
+// falling back to read only.
+func (sh *SCIMHandler) replaceGroupMembers(
+	c echo.Context,
+	levels map[primitive.ObjectID]organizationmodel.PermissionLevelEnum,
+	level organizationmodel.PermissionLevelEnum,
+	members []string,
+) bool {
+	if !sh.setPermissionLevel(c, levels, members, level) {
+		return false
+	}
+
+	kept := make(map[string]bool, len(members))
+	for _, member := range members {
+		kept[member] = true
+	}
+
+	removed := make([]string, 0)
+	for userID, memberLevel := range levels {
+		if memberLevel == level && !kept[userID.Hex()] {
+			removed = append(removed, userID.Hex())
+		}
+	}
+
+	return sh.removeGroupMembers(c, levels, level, removed)
+}
+
+// removeGroupMembers makes the members with level read only. Every member
+// stays in a group, so removing them from the read only one does nothing.
+func (sh *SCIMHandler) removeGroupMembers(
+	c echo.Context,
+	levels map[primitive.ObjectID]organizationmodel.PermissionLevelEnum,
+	level organizationmodel.PermissionLevelEnum,
+	members []string,
+) bool {
+	if level == organizationmodel.ReadOnly {
+		return true
+	}
+
+	demoted := make([]string, 0, len(members))
+	for _, id := range members {
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err == nil && levels[userID] == level {
+			demoted = append(demoted, id)
+		}
+	}
+
+	return sh.setPermissionLevel(c, levels, demoted, organizationmodel.ReadOnly)
+}
+
+// setPermissionLevel gives level to members, IDs of users who have to be
+// members of the organization. When it can't, the error response has already
+// been written.
+func (sh *SCIMHandler) setPermissionLevel(
+	c echo.Context,
+	levels map[primitive.ObjectID]organizationmodel.PermissionLevelEnum,
+	members []string,
+	level organizationmodel.PermissionLevelEnum,
+) bool {
+	userIDs := make([]primitive.ObjectID, 0, len(members))
+	for _, id := range members {
+		userID, err := primitive.ObjectIDFromHex(id)
+		if _, ok := levels[userID]; err != nil || !ok {
+			sh.logger.Debug("Client error",
+				zap.String("member", id),
+			)
+			_ = scimError(c, http.StatusBadRequest, scim.InvalidValue, "unknown member "+id)
+			return false
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	for _, userID := range userIDs {
+		levels[userID] = level
+	}
+
+	return true
+}
+
+// applyMemberLevels stores the levels of the members of organization that
+// changed, all at once, as long as the organization keeps an admin. When it
+// can't, the error response has already been written.
+func (sh *SCIMHandler) applyMemberLevels(
+	c echo.Context,
+	organization *organizationmodel.OrganizationRecord,
+	levels map[primitive.ObjectID]organizationmodel.PermissionLevelEnum,
+) bool {
+	organizationModel := organizationmodel.New(sh.db)
+	err := storage.WithTransaction(context.Background(), sh.db, func(ctx context.Context) error {
+		for _, member := range organization.Members {
+			level := levels[member.User.ID]
+			if level == member.PermissionLevel {
+				continue
+			}
+
+			err := organizationModel.UpdateMember(
+				ctx,
+				organization.ID,
+				member.User.ID,
+				bson.M{"permission_level": level},
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Checked on the stored members, which other requests may have
+		// changed since the organization was loaded
+		updated, err := organizationModel.FindByID(ctx, organization.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, member := range updated.Members {
+			if member.PermissionLevel == organizationmodel.Admin {
+				return nil
+			}
+		}
+
+		return errLastAdmin
+	})
+	if err != nil {
+		if errors.Is(err, errLastAdmin) {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			_ = scimError(c, http.StatusBadRequest, scim.Mutability, apierrors.LastAdminError)
+			return false
+		}
+
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = scimError(c, http.StatusInternalServerError, "", apierrors.InternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// findOrganization loads the organization of the provisioning token. When it
+// can't, the error response has already been written.
+func (sh *SCIMHandler) findOrganization(c echo.Context) (*organizationmodel.OrganizationRecord, bool) {
+	organizationID, err := apiutils.GetOrganizationFromContext(c)
+	if err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = scimError(c, http.StatusUnauthorized, "", apierrors.UnauthorizedError)
+		return nil, false
+	}
+
+	organizationModel := organizationmodel.New(sh.db)
+	organization, err := organizationModel.FindByID(context.Background(), organizationID)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = scimError(c, http.StatusInternalServerError, "", apierrors.InternalServerError)
+		return nil, false
+	}
+
+	return organization, true
+}
+
+// findMember returns the member of the organization whose user ID is id.
+// When there is none, the error response has already been written.
+func (sh *SCIMHandler) findMember(
+	c echo.Context,
+	organization *organizationmodel.OrganizationRecord,
+	id string,
+) (*organizationmodel.OrganizationMember, bool) {
+	for i := range organization.Members {
+		if organization.Members[i].User.ID.Hex() == id {
+			return &organization.Members[i], true
+		}
+	}
+
+	sh.logger.Debug("Client error",
+		zap.String("cause", apierrors.NotFoundError),
+	)
+	_ = scimError(c, http.StatusNotFound, "", apierrors.NotFoundError)
+	return nil, false
+}
+
+// findGroup returns the permission level of the group of the request. When
+// there is none, the error response has already been written.
+func (sh *SCIMHandler) findGroup(c echo.Context) (organizationmodel.PermissionLevelEnum, bool) {
+	for _, level := range organizationmodel.PermissionLevels {
+		if level == c.Param("groupID") {
+			return level, true
+		}
+	}
+
+	sh.logger.Debug("Client error",
+		zap.String("cause", apierrors.NotFoundError),
+	)
+	_ = scimError(c, http.StatusNotFound, "", apierrors.NotFoundError)
+	return "", false
+}
+
+func userResource(c echo.Context, member *organizationmodel.OrganizationMember) *scim.User {
+	active := true
+	id := member.User.ID.Hex()
+
+	return &scim.User{
+		Schemas:    []string{scim.UserSchema},
+		ID:         id,
+		ExternalID: member.ExternalID,
+		UserName:   member.User.Email,
+		Name: &scim.Name{
+			GivenName:  member.User.FirstName,
+			FamilyName: member.User.LastName,
+		},
+		Emails: []scim.Email{{Value: member.User.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Groups: []scim.Reference{{Value: member.PermissionLevel, Display: member.PermissionLevel}},
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Location:     scimLocation(c, "Users", id),
+		},
+	}
+}
+
+func groupResource(
+	c echo.Context,
+	organization *organizationmodel.OrganizationRecord,
+	level organizationmodel.PermissionLevelEnum,
+) *scim.Group {
+	members := make([]scim.Reference, 0)
+	for _, member := range organization.Members {
+		if member.PermissionLevel == level {
+			members = append(members, scim.Reference{
+				Value:   member.User.ID.Hex(),
+				Display: member.User.Email,
+			})
+		}
+	}
+
+	return &scim.Group{
+		Schemas:     []string{scim.GroupSchema},
+		ID:          level,
+		DisplayName: level,
+		Members:     members,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Location:     scimLocation(c, "Groups", level),
+		},
+	}
+}
+
+func scimLocation(c echo.Context, resource, id string) string {
+	return c.Scheme() + "://" + c.Request().Host + SCIMBasePath + "/" + resource + "/" + id
+}
+
+// scimPaginationParams parses the 1-based startIndex and count query values,
+// falling back to the defaults when they are missing or invalid and capping
+// count to config.MaxPageSize.
+func scimPaginationParams(startIndex, count string) (int, int) {
+	start, err := strconv.Atoi(startIndex)
+	if err != nil || start < 1 {
+		start = 1
+	}
+
+	size, err := strconv.Atoi(count)
+	if err != nil || size < 0 || size > config.MaxPageSize {
+		size = config.MaxPageSize
+	}
+
+	return start, size
+}
+
+// bindSCIM decodes the JSON body of the request, which SCIM clients send as
+// application/scim+json.
+func bindSCIM(c echo.Context, v interface{}) error {
+	return json.NewDecoder(c.Request().Body).Decode(v)
+}
+
+func scimJSON(c echo.Context, status int, v interface{}) error {
+	c.Response().Header().Set(echo.HeaderContentType, scim.MediaType)
+	return c.JSON(status, v)
+}
+
+func scimError(c echo.Context, status int, scimType, detail string) error {
+	return scimJSON(c, status, scim.NewError(status, scimType, detail))
+}
+
+func NewSCIMHandler(db *mongo.Database, logger *zap.Logger, publisher events.Publisher) *SCIMHandler {
+	return &SCIMHandler{
+		db:        db,
+		logger:    logger,
+		publisher: publisher,
+	}
+}
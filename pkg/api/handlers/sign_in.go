@@ -4,14 +4,17 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/Roll-Play/togglelabs/pkg/api/common"
 	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
 	"github.com/Roll-Play/togglelabs/pkg/config"
+	tokenmodel "github.com/Roll-Play/togglelabs/pkg/models/token"
 	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
 	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
@@ -27,6 +30,27 @@ type SignInRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+const (
+	TwoFactorChallengeTTL = 5 * time.Minute
+	// Codes a challenge accepts before the password has to be given again
+	twoFactorChallengeAttempts = 5
+)
+
+// TwoFactorChallengeResponse answers sign-ins of users with a second factor,
+// the challenge token is exchanged for a session at /signin/2fa.
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	// Sign-ins through an identity provider keep where to send users back
+	RedirectTo string `json:"redirect_to,omitempty"`
+}
+
+type SignInTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode   string `json:"recovery_code" validate:"required_without=Code"`
+}
+
 func (sh *SignInHandler) PostSignIn(c echo.Context) error {
 	request := new(SignInRequest)
 
@@ -77,6 +101,10 @@ func (sh *SignInHandler) PostSignIn(c echo.Context) error {
 		)
 	}
 
+	if !sh.countSignInAttempt(c, model, ur) {
+		return nil
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(ur.Password), []byte(request.Password)); err != nil {
 		sh.logger.Debug("Client error",
 			zap.Error(err),
@@ -88,7 +116,221 @@ func (sh *SignInHandler) PostSignIn(c echo.Context) error {
 		)
 	}
 
-	token, err := apiutils.CreateJWT(ur.ID, config.JWTExpireTime)
+	// Signed in users are the actor of the audit entry of this request
+	c.Set("user", ur.ID.Hex())
+
+	if ur.TwoFactorEnabled() {
+		challenge, err := createTwoFactorChallenge(context.Background(), sh.db, ur.ID)
+		if err != nil {
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+
+		return c.JSON(http.StatusOK, TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
+	}
+
+	return sh.signIn(c, ur, false)
+}
+
+// countSignInAttempt counts an attempt to sign in as ur as failed until the
+// sign-in completes. Locked out accounts don't get to try passwords or codes
+// at all. Attempts are counted before they are checked and one at a time, so
+// parallel guesses can't all get in before the lockout. When it returns
+// false, the error response has already been written.
+func (sh *SignInHandler) countSignInAttempt(c echo.Context, model *usermodel.UserModel, ur *usermodel.UserRecord) bool {
+	now := time.Now()
+	for {
+		if lockedUntil := ur.SignInLockedUntil(); now.Before(lockedUntil) {
+			sh.logger.Debug("Client error",
+				zap.String("cause", apierrors.AccountLockedError),
+			)
+			apiutils.SetRetryAfter(c, lockedUntil.Sub(now))
+			_ = apierrors.CustomError(
+				c,
+				http.StatusTooManyRequests,
+				apierrors.AccountLockedError,
+			)
+			return false
+		}
+
+		err := model.RecordSignInAttempt(context.Background(), ur, now)
+		if err == nil {
+			return true
+		}
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ur, err = model.FindByID(context.Background(), ur.ID)
+		}
+		if err != nil {
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			_ = apierrors.CustomError(
+				c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+			return false
+		}
+	}
+}
+
+// createTwoFactorChallenge stores a challenge the user completes with a
+// second factor at PostSignInTwoFactor, and returns its token.
+func createTwoFactorChallenge(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) (string, error) {
+	challenge, hash, err := tokenmodel.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	tokenModel := tokenmodel.New(db)
+	record := tokenmodel.NewTokenRecord(userID, tokenmodel.TwoFactorChallenge, hash, TwoFactorChallengeTTL)
+	if _, err := tokenModel.InsertOne(ctx, record); err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// PostSignInTwoFactor completes the sign-in of a user with a second factor,
+// given the challenge token of the password step and a code of their app or
+// one of their recovery codes.
+func (sh *SignInHandler) PostSignInTwoFactor(c echo.Context) error {
+	request := new(SignInTwoFactorRequest)
+	if err := c.Bind(request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		sh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	tokenModel := tokenmodel.New(sh.db)
+	challenge, err := tokenModel.FindValid(context.Background(), tokenmodel.TwoFactorChallenge, request.ChallengeToken)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusUnauthorized,
+				apierrors.InvalidTokenError,
+			)
+		}
+
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	c.Set("user", challenge.UserID.Hex())
+
+	userModel := usermodel.New(sh.db)
+	ur, err := userModel.FindByID(context.Background(), challenge.UserID)
+	if err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	// Codes count toward the lockout of the account like passwords, so new
+	// challenges don't give more guesses
+	if !sh.countSignInAttempt(c, userModel, ur) {
+		return nil
+	}
+
+	valid := false
+	if ur.TwoFactorEnabled() {
+		valid, err = verifySecondFactor(context.Background(), sh.db, ur, request.Code, request.RecoveryCode)
+		if err != nil {
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+	}
+
+	if !valid {
+		if err := tokenModel.Fail(context.Background(), challenge.ID, twoFactorChallengeAttempts); err != nil {
+			sh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+
+		sh.logger.Debug("Client error",
+			zap.String("cause", apierrors.InvalidTwoFactorCodeError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusUnauthorized,
+			apierrors.InvalidTwoFactorCodeError,
+		)
+	}
+
+	// Challenges are single-use, a concurrent request may have used it first
+	if _, err := tokenModel.Consume(context.Background(), tokenmodel.TwoFactorChallenge, request.ChallengeToken); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			sh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusUnauthorized,
+				apierrors.InvalidTokenError,
+			)
+		}
+
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return sh.signIn(c, ur, true)
+}
+
+// signIn answers with a new session of ur, secondFactor tells whether they
+// passed it. The failed attempts of ur are forgotten once the sign-in
+// completes.
+func (sh *SignInHandler) signIn(c echo.Context, ur *usermodel.UserRecord, secondFactor bool) error {
+	token, err := apiutils.CreateSessionJWT(ur.ID, ur.SessionVersion, secondFactor, config.JWTExpireTime)
 	if err != nil {
 		sh.logger.Debug("Server error",
 			zap.Error(err),
@@ -99,15 +341,26 @@ func (sh *SignInHandler) PostSignIn(c echo.Context) error {
 		)
 	}
 
+	if err := usermodel.New(sh.db).ResetSignInLockout(context.Background(), ur.ID); err != nil {
+		sh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
 	sh.logger.Debug("User logged in",
 		zap.String("_id", ur.ID.Hex()),
 	)
 	return c.JSON(http.StatusOK, common.AuthResponse{
-		ID:        ur.ID,
-		Email:     ur.Email,
-		FirstName: ur.FirstName,
-		LastName:  ur.LastName,
-		Token:     token,
+		ID:            ur.ID,
+		Email:         ur.Email,
+		FirstName:     ur.FirstName,
+		LastName:      ur.LastName,
+		EmailVerified: ur.EmailVerified,
+		Token:         token,
 	})
 }
 
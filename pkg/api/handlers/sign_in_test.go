@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Roll-Play/togglelabs/pkg/api/common"
 	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
@@ -14,10 +16,13 @@ import (
 	"github.com/Roll-Play/togglelabs/pkg/api/handlers/fixtures"
 	"github.com/Roll-Play/togglelabs/pkg/config"
 	"github.com/Roll-Play/togglelabs/pkg/logger"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
 	testutils "github.com/Roll-Play/togglelabs/pkg/utils/test_utils"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -127,6 +132,99 @@ func (suite *SignInHandlerTestSuite) TestSignInHandlerUnauthorized() {
 	}, response)
 }
 
+func (suite *SignInHandlerTestSuite) TestSignInHandlerLockout() {
+	t := suite.T()
+
+	user := fixtures.CreateUser("fizi@gmail.com", "", "", "", suite.db)
+
+	signIn := func(password string) *httptest.ResponseRecorder {
+		requestBody, err := json.Marshal(handlers.SignInRequest{
+			Email:    "fizi@gmail.com",
+			Password: password,
+		})
+		assert.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/signin", bytes.NewBuffer(requestBody))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+
+		suite.Server.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	for i := 0; i < usermodel.SignInLockoutThreshold; i++ {
+		assert.Equal(t, http.StatusUnauthorized, signIn("wrongpass").Code)
+	}
+
+	// Even the right password is rejected while locked out
+	recorder := signIn("big_secret_password")
+	var response apierrors.Error
+
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, apierrors.AccountLockedError, response.Message)
+	assert.Equal(t, "60", recorder.Header().Get(echo.HeaderRetryAfter))
+
+	model := usermodel.New(suite.db)
+	err := model.UpdateOne(context.Background(), user.ID, bson.D{{
+		Key:   "sign_in_lockout.last_failed_at",
+		Value: primitive.NewDateTimeFromTime(time.Now().Add(-2 * usermodel.SignInLockoutBase)),
+	}})
+	assert.NoError(t, err)
+
+	// Each failure past the threshold doubles the lockout
+	assert.Equal(t, http.StatusUnauthorized, signIn("wrongpass").Code)
+	recorder = signIn("big_secret_password")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "120", recorder.Header().Get(echo.HeaderRetryAfter))
+
+	err = model.UpdateOne(context.Background(), user.ID, bson.D{{
+		Key:   "sign_in_lockout.last_failed_at",
+		Value: primitive.NewDateTimeFromTime(time.Now().Add(-4 * usermodel.SignInLockoutBase)),
+	}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, signIn("big_secret_password").Code)
+
+	saved, err := model.FindByID(context.Background(), user.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, saved.SignInLockout)
+}
+
+func (suite *SignInHandlerTestSuite) TestSignInHandlerParallelGuesses() {
+	t := suite.T()
+
+	fixtures.CreateUser("fizi@gmail.com", "", "", "", suite.db)
+
+	guesses := usermodel.SignInLockoutThreshold * 4
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- fixtures.Request(suite.Server, http.MethodPost, "/signin", handlers.SignInRequest{
+				Email:    "fizi@gmail.com",
+				Password: "wrongpass",
+			}, "", "").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	// Guesses past the threshold are locked out however fast they come
+	tried := 0
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			tried++
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, code)
+		}
+	}
+	assert.Equal(t, usermodel.SignInLockoutThreshold, tried)
+}
+
 func TestSignInHandlerTestSuite(t *testing.T) {
 	suite.Run(t, new(SignInHandlerTestSuite))
 }
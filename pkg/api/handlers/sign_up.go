@@ -8,6 +8,7 @@ import (
 	"github.com/Roll-Play/togglelabs/pkg/api/common"
 	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
 	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
 	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
 	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
 	"github.com/go-playground/validator/v10"
@@ -17,20 +18,29 @@ import (
 )
 
 type SignUpHandler struct {
-	db     *mongo.Database
-	logger *zap.Logger
+	db           *mongo.Database
+	logger       *zap.Logger
+	mailer       *mailer.Sender
+	dashboardURL string
 }
 
-func NewSignUpHandler(db *mongo.Database, logger *zap.Logger) *SignUpHandler {
+func NewSignUpHandler(
+	db *mongo.Database,
+	logger *zap.Logger,
+	sender *mailer.Sender,
+	dashboardURL string,
+) *SignUpHandler {
 	return &SignUpHandler{
-		db:     db,
-		logger: logger,
+		db:           db,
+		logger:       logger,
+		mailer:       sender,
+		dashboardURL: dashboardURL,
 	}
 }
 
 type SignUpRequest struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"gte=8"`
+	Password string `json:"password" validate:"gte=8,max=72"`
 }
 
 func (sh *SignUpHandler) PostUser(c echo.Context) error {
@@ -104,11 +114,21 @@ func (sh *SignUpHandler) PostUser(c echo.Context) error {
 	sh.logger.Debug("Created user",
 		zap.String("_id", objectID.Hex()),
 	)
+
+	// The account is usable right away, users can ask for another email
+	if err := sendVerificationEmail(context.Background(), sh.db, sh.mailer, sh.dashboardURL, ur); err != nil {
+		sh.logger.Error("Unable to send verification email",
+			zap.String("_id", objectID.Hex()),
+			zap.Error(err),
+		)
+	}
+	c.Set("user", objectID.Hex())
 	return c.JSON(http.StatusCreated, common.AuthResponse{
-		ID:        objectID,
-		Email:     ur.Email,
-		FirstName: ur.FirstName,
-		LastName:  ur.LastName,
-		Token:     token,
+		ID:            objectID,
+		Email:         ur.Email,
+		FirstName:     ur.FirstName,
+		LastName:      ur.LastName,
+		EmailVerified: ur.EmailVerified,
+		Token:         token,
 	})
 }
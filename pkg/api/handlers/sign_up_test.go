@@ -14,6 +14,7 @@ import (
 	"github.com/Roll-Play/togglelabs/pkg/api/handlers/fixtures"
 	"github.com/Roll-Play/togglelabs/pkg/config"
 	"github.com/Roll-Play/togglelabs/pkg/logger"
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
 	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
 	testutils "github.com/Roll-Play/togglelabs/pkg/utils/test_utils"
 	"github.com/labstack/echo/v4"
@@ -38,7 +39,8 @@ func (suite *SignUpHandlerTestSuite) SetupTest() {
 	suite.db = client.Database(config.TestDBName)
 	suite.Server = echo.New()
 	logger, _ := logger.NewZapLogger()
-	h := handlers.NewSignUpHandler(suite.db, logger)
+	sender := mailer.NewSender(new(fixtures.MockTransport), logger, "test@togglelabs.net")
+	h := handlers.NewSignUpHandler(suite.db, logger, sender, "http://dashboard.test")
 	suite.Server.POST("/signup", h.PostUser)
 }
 
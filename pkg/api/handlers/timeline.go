@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+type TimelineHandler struct {
+	db     *mongo.Database
+	logger *zap.Logger
+}
+
+func NewTimelineHandler(db *mongo.Database, logger *zap.Logger) *TimelineHandler {
+	return &TimelineHandler{
+		db:     db,
+		logger: logger,
+	}
+}
+
+type ListTimelineResponse struct {
+	PageSize   int                            `json:"page_size"`
+	NextCursor string                         `json:"next_cursor,omitempty"`
+	Data       []timelinemodel.TimelineRecord `json:"data"`
+}
+
+var ErrInvalidEventType = errors.New("invalid timeline event type")
+
+func (th *TimelineHandler) ListFeatureFlagTimeline(c echo.Context) error {
+	featureFlagID, err := primitive.ObjectIDFromHex(c.Param("featureFlagID"))
+	if err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	return th.listTimeline(c, bson.D{{Key: "feature_flag_id", Value: featureFlagID}})
+}
+
+func (th *TimelineHandler) ListActivity(c echo.Context) error {
+	return th.listTimeline(c, bson.D{})
+}
+
+func (th *TimelineHandler) listTimeline(c echo.Context, filter bson.D) error {
+	_, organization, ok := organizationFromContext(c, th.logger)
+	if !ok {
+		return nil
+	}
+	organizationID := organization.ID
+
+	queryFilter, err := timelineListFilter(c)
+	if err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+	filter = append(filter, queryFilter...)
+
+	_, limit := apiutils.GetPaginationParams("", c.QueryParam("page_size"))
+
+	if cursorQuery := c.QueryParam("cursor"); cursorQuery != "" {
+		cursor, err := apiutils.ParseCursor(cursorQuery, timelinemodel.TimelineSort)
+		if err != nil {
+			th.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusBadRequest,
+				apierrors.BadRequestError,
+			)
+		}
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{cursor.Filter(timelinemodel.TimelineSort)}})
+	}
+
+	model := timelinemodel.New(th.db)
+	records, err := model.FindMany(context.Background(), organizationID, filter, limit)
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(
+			c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	nextCursor := ""
+	if len(records) == limit {
+		nextCursor, err = apiutils.NewCursor(records[len(records)-1], timelinemodel.TimelineSort)
+		if err != nil {
+			th.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(
+				c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+	}
+
+	return c.JSON(http.StatusOK, ListTimelineResponse{
+		PageSize:   limit,
+		NextCursor: nextCursor,
+		Data:       records,
+	})
+}
+
+func timelineListFilter(c echo.Context) (bson.D, error) {
+	filter := bson.D{}
+
+	if actor := c.QueryParam("actor"); actor != "" {
+		actorID, err := primitive.ObjectIDFromHex(actor)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "actor_id", Value: actorID})
+	}
+
+	if eventTypes := c.QueryParams()["event_type"]; len(eventTypes) > 0 {
+		for _, eventType := range eventTypes {
+			if !isTimelineEventType(eventType) {
+				return nil, ErrInvalidEventType
+			}
+		}
+		filter = append(filter, bson.E{Key: "event_type", Value: bson.M{"$in": eventTypes}})
+	}
+
+	timestamp, err := timestampRangeFilter(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(timestamp) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestamp})
+	}
+
+	return filter, nil
+}
+
+// timestampRangeFilter builds the timestamp condition of the from (inclusive)
+// and to (exclusive) RFC 3339 query params.
+func timestampRangeFilter(c echo.Context) (bson.M, error) {
+	timestamp := bson.M{}
+	if from := c.QueryParam("from"); from != "" {
+		fromTime, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, err
+		}
+		timestamp["$gte"] = primitive.NewDateTimeFromTime(fromTime)
+	}
+
+	if to := c.QueryParam("to"); to != "" {
+		toTime, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, err
+		}
+		timestamp["$lt"] = primitive.NewDateTimeFromTime(toTime)
+	}
+
+	return timestamp, nil
+}
+
+func isTimelineEventType(eventType string) bool {
+	for _, known := range timelinemodel.EventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"time"
+
+	tokenmodel "github.com/Roll-Play/togglelabs/pkg/models/token"
+)
+
+// Emailed tokens are limited to one per interval and tokenLimit per hour for
+// each user and purpose.
+const (
+	tokenInterval = time.Minute
+	tokenLimit    = 5
+)
+
+// tokenRetryAfter returns how long the user has to wait before another token
+// is emailed, given the ones sent during the last hour newest first.
+func tokenRetryAfter(recent []tokenmodel.TokenRecord, now time.Time) time.Duration {
+	if len(recent) == 0 {
+		return 0
+	}
+
+	if wait := recent[0].CreatedAt.Time().Add(tokenInterval).Sub(now); wait > 0 {
+		return wait
+	}
+
+	if len(recent) >= tokenLimit {
+		oldest := recent[tokenLimit-1]
+		return oldest.CreatedAt.Time().Add(time.Hour).Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/api/common"
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	tokenmodel "github.com/Roll-Play/togglelabs/pkg/models/token"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	"github.com/Roll-Play/togglelabs/pkg/totp"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TwoFactorIssuer    = "Toggle Labs"
+	recoveryCodesCount = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorHandler struct {
+	db     *mongo.Database
+	logger *zap.Logger
+}
+
+func NewTwoFactorHandler(db *mongo.Database, logger *zap.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		db:     db,
+		logger: logger,
+	}
+}
+
+type TwoFactorEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type TwoFactorVerificationRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type TwoFactorVerificationResponse struct {
+	// Shown once, each code signs in a single time without the app
+	RecoveryCodes []string `json:"recovery_codes"`
+	// Session that passed the second factor, for organizations requiring it
+	Token string `json:"token"`
+}
+
+type TwoFactorDeleteRequest struct {
+	Password     string `json:"password" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+// PostEnrollment starts a new enrollment of the signed in user, replacing any
+// pending one. The second factor is only enabled once a code is verified.
+func (th *TwoFactorHandler) PostEnrollment(c echo.Context) error {
+	user, ok := th.findUser(c)
+	if !ok {
+		return nil
+	}
+
+	// Users signing in through a provider rely on its own second factor
+	if user.Password == "" {
+		th.logger.Debug("Client error",
+			zap.String("cause", "user has no password"),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if user.TwoFactorEnabled() {
+		th.logger.Debug("Client error",
+			zap.String("cause", apierrors.TwoFactorEnabledError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusConflict,
+			apierrors.TwoFactorEnabledError,
+		)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	userModel := usermodel.New(th.db)
+	err = userModel.UpdateOne(context.Background(), user.ID, bson.D{{
+		Key:   "two_factor",
+		Value: usermodel.TwoFactor{Secret: secret, RecoveryCodes: []string{}},
+	}})
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, TwoFactorEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(TwoFactorIssuer, user.Email, secret),
+	})
+}
+
+// PostVerification enables the pending second factor once the user proves
+// their app generates valid codes, and returns new recovery codes with a
+// session that passed it.
+func (th *TwoFactorHandler) PostVerification(c echo.Context) error {
+	request := new(TwoFactorVerificationRequest)
+	if err := c.Bind(request); err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	user, ok := th.findUser(c)
+	if !ok {
+		return nil
+	}
+
+	if user.TwoFactor == nil || user.TwoFactorEnabled() {
+		th.logger.Debug("Client error",
+			zap.String("cause", "no pending enrollment"),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	step, valid := totp.Validate(user.TwoFactor.Secret, request.Code, time.Now(), user.TwoFactor.LastStep)
+	if !valid {
+		th.logger.Debug("Client error",
+			zap.String("cause", apierrors.InvalidTwoFactorCodeError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.InvalidTwoFactorCodeError,
+		)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	userModel := usermodel.New(th.db)
+	err = userModel.UpdateOne(context.Background(), user.ID, bson.D{
+		{Key: "two_factor.enabled", Value: true},
+		{Key: "two_factor.recovery_codes", Value: hashes},
+		{Key: "two_factor.last_step", Value: step},
+	})
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	token, err := apiutils.CreateSessionJWT(user.ID, user.SessionVersion, true, config.JWTExpireTime)
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, TwoFactorVerificationResponse{
+		RecoveryCodes: codes,
+		Token:         token,
+	})
+}
+
+// DeleteTwoFactor disables the second factor, which takes both the password
+// and a code of the user. Other sessions are signed out, the response carries
+// a new session for the current one.
+func (th *TwoFactorHandler) DeleteTwoFactor(c echo.Context) error {
+	request := new(TwoFactorDeleteRequest)
+	if err := c.Bind(request); err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	user, ok := th.findUser(c)
+	if !ok {
+		return nil
+	}
+
+	if !user.TwoFactorEnabled() {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusForbidden,
+			apierrors.InvalidPasswordError,
+		)
+	}
+
+	valid, err := verifySecondFactor(context.Background(), th.db, user, request.Code, request.RecoveryCode)
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	if !valid {
+		th.logger.Debug("Client error",
+			zap.String("cause", apierrors.InvalidTwoFactorCodeError),
+		)
+		return apierrors.CustomError(c,
+			http.StatusForbidden,
+			apierrors.InvalidTwoFactorCodeError,
+		)
+	}
+
+	userModel := usermodel.New(th.db)
+	if err := userModel.DisableTwoFactor(context.Background(), user.ID); err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	token, err := apiutils.CreateSessionJWT(user.ID, user.SessionVersion+1, false, config.JWTExpireTime)
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, common.AuthResponse{
+		ID:            user.ID,
+		Email:         user.Email,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		EmailVerified: user.EmailVerified,
+		Token:         token,
+	})
+}
+
+// findUser loads the signed in user. When it can't, the error response has
+// already been written.
+func (th *TwoFactorHandler) findUser(c echo.Context) (*usermodel.UserRecord, bool) {
+	userID, err := apiutils.GetUserFromContext(c)
+	if err != nil {
+		th.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusUnauthorized,
+			apierrors.UnauthorizedError,
+		)
+		return nil, false
+	}
+
+	userModel := usermodel.New(th.db)
+	user, err := userModel.FindByID(context.Background(), userID)
+	if err != nil {
+		th.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// verifySecondFactor checks a TOTP code or, without one, a recovery code of
+// user. Both are used up when valid.
+func verifySecondFactor(
+	ctx context.Context,
+	db *mongo.Database,
+	user *usermodel.UserRecord,
+	code,
+	recoveryCode string,
+) (bool, error) {
+	userModel := usermodel.New(db)
+
+	var err error
+	if code != "" {
+		step, valid := totp.Validate(user.TwoFactor.Secret, code, time.Now(), user.TwoFactor.LastStep)
+		if !valid {
+			return false, nil
+		}
+
+		err = userModel.UseTwoFactorStep(ctx, user.ID, step)
+	} else {
+		err = userModel.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(recoveryCode))
+	}
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// generateRecoveryCodes returns codes like "abcde-fghij" and their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		bytes := make([]byte, 7)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, nil, err
+		}
+
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(bytes))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case and separators, so codes can be typed loosely.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return tokenmodel.Hash(normalized)
+}
@@ -44,7 +44,7 @@ func (suite *UserHandlerTestSuite) SetupTest() {
 	suite.Server = echo.New()
 	logger, _ := logger.NewZapLogger()
 	h := handlers.NewUserHandler(suite.db, logger)
-	testGroup := suite.Server.Group("/user", middlewares.AuthMiddleware)
+	testGroup := suite.Server.Group("/user", middlewares.AuthMiddleware(suite.db))
 	testGroup.PATCH("", h.PatchUser)
 	testGroup.GET("", h.GetUser)
 }
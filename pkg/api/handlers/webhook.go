@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/events"
+	webhookmodel "github.com/Roll-Play/togglelabs/pkg/models/webhook"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/Roll-Play/togglelabs/pkg/webhook"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+type WebhookHandler struct {
+	db         *mongo.Database
+	logger     *zap.Logger
+	dispatcher *webhook.Dispatcher
+}
+
+func NewWebhookHandler(db *mongo.Database, logger *zap.Logger, dispatcher *webhook.Dispatcher) *WebhookHandler {
+	return &WebhookHandler{
+		db:         db,
+		logger:     logger,
+		dispatcher: dispatcher,
+	}
+}
+
+var ErrUnknownEventType = errors.New("unknown event type")
+
+type PostWebhookRequest struct {
+	URL         string   `json:"url" validate:"required,http_url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+}
+
+type PatchWebhookRequest struct {
+	URL         *string `json:"url" validate:"omitempty,http_url"`
+	Description *string `json:"description"`
+	// Left unchanged when missing, an empty list subscribes to every event
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// PostWebhookResponse is the only response carrying the secret used to sign
+// deliveries.
+type PostWebhookResponse struct {
+	webhookmodel.WebhookRecord
+	Secret string `json:"secret"`
+}
+
+type ListDeliveriesResponse struct {
+	PageSize   int                           `json:"page_size"`
+	NextCursor string                        `json:"next_cursor,omitempty"`
+	Data       []webhookmodel.DeliveryRecord `json:"data"`
+}
+
+func (wh *WebhookHandler) PostWebhook(c echo.Context) error {
+	userID, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	request := new(PostWebhookRequest)
+	if err := c.Bind(request); err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+
+	if err := validate.Struct(request); err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if err := validateEventTypes(request.Events); err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	record := webhookmodel.NewWebhookRecord(
+		organization.ID,
+		request.URL,
+		request.Description,
+		request.Events,
+		secret,
+		userID,
+	)
+
+	model := webhookmodel.New(wh.db)
+	if _, err := model.InsertOne(context.Background(), record); err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, PostWebhookResponse{
+		WebhookRecord: *record,
+		Secret:        secret,
+	})
+}
+
+func (wh *WebhookHandler) ListWebhooks(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	model := webhookmodel.New(wh.db)
+	records, err := model.FindMany(context.Background(), organization.ID, bson.D{})
+	if err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, records)
+}
+
+func (wh *WebhookHandler) GetWebhook(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := wh.findWebhook(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	return c.JSON(http.StatusOK, record)
+}
+
+func (wh *WebhookHandler) PatchWebhook(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := wh.findWebhook(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	request := new(PatchWebhookRequest)
+	if err := c.Bind(request); err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	validate := validator.New()
+
+	if err := validate.Struct(request); err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	if err := validateEventTypes(request.Events); err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	values := bson.D{}
+	if request.URL != nil {
+		record.URL = *request.URL
+		values = append(values, bson.E{Key: "url", Value: record.URL})
+	}
+	if request.Description != nil {
+		record.Description = *request.Description
+		values = append(values, bson.E{Key: "description", Value: record.Description})
+	}
+	if request.Events != nil {
+		record.Events = request.Events
+		values = append(values, bson.E{Key: "events", Value: record.Events})
+	}
+	if request.Active != nil {
+		record.Active = *request.Active
+		values = append(values, bson.E{Key: "active", Value: record.Active})
+	}
+
+	model := webhookmodel.New(wh.db)
+	if err := model.UpdateOne(context.Background(), organization.ID, record.ID, values); err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusOK, record)
+}
+
+func (wh *WebhookHandler) DeleteWebhook(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := wh.findWebhook(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	// Pending deliveries are failed by the retry worker once it finds the
+	// webhook is gone
+	model := webhookmodel.New(wh.db)
+	if err := model.DeleteOne(context.Background(), organization.ID, record.ID); err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (wh *WebhookHandler) ListDeliveries(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := wh.findWebhook(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	filter := bson.D{}
+	if status := c.QueryParam("status"); status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+
+	_, limit := apiutils.GetPaginationParams("", c.QueryParam("page_size"))
+
+	if cursorQuery := c.QueryParam("cursor"); cursorQuery != "" {
+		cursor, err := apiutils.ParseCursor(cursorQuery, webhookmodel.DeliverySort)
+		if err != nil {
+			wh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusBadRequest,
+				apierrors.BadRequestError,
+			)
+		}
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{cursor.Filter(webhookmodel.DeliverySort)}})
+	}
+
+	model := webhookmodel.NewDeliveryModel(wh.db)
+	deliveries, err := model.FindMany(context.Background(), organization.ID, record.ID, filter, limit)
+	if err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	nextCursor := ""
+	if len(deliveries) == limit {
+		nextCursor, err = apiutils.NewCursor(deliveries[len(deliveries)-1], webhookmodel.DeliverySort)
+		if err != nil {
+			wh.logger.Debug("Server error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusInternalServerError,
+				apierrors.InternalServerError,
+			)
+		}
+	}
+
+	return c.JSON(http.StatusOK, ListDeliveriesResponse{
+		PageSize:   limit,
+		NextCursor: nextCursor,
+		Data:       deliveries,
+	})
+}
+
+func (wh *WebhookHandler) Redeliver(c echo.Context) error {
+	_, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := wh.findWebhook(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	deliveryID, err := primitive.ObjectIDFromHex(c.Param("deliveryID"))
+	if err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+	}
+
+	model := webhookmodel.NewDeliveryModel(wh.db)
+	delivery, err := model.FindByID(context.Background(), organization.ID, record.ID, deliveryID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			wh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			return apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+		}
+
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	redelivery, err := wh.dispatcher.Redeliver(context.Background(), record, delivery)
+	if err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, redelivery)
+}
+
+func (wh *WebhookHandler) PostTestEvent(c echo.Context) error {
+	userID, organization, ok := organizationFromContext(c, wh.logger)
+	if !ok {
+		return nil
+	}
+
+	record, ok := wh.findWebhook(c, organization.ID)
+	if !ok {
+		return nil
+	}
+
+	event := events.New(organization.ID, userID, webhook.TestEvent, bson.M{"webhook_id": record.ID})
+	delivery, err := wh.dispatcher.Send(context.Background(), record, event)
+	if err != nil {
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		return apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+	}
+
+	return c.JSON(http.StatusCreated, delivery)
+}
+
+// findWebhook loads the webhook of the route from the organization. When it
+// can't, the error response has already been written.
+func (wh *WebhookHandler) findWebhook(
+	c echo.Context,
+	organizationID primitive.ObjectID,
+) (*webhookmodel.WebhookRecord, bool) {
+	webhookID, err := primitive.ObjectIDFromHex(c.Param("webhookID"))
+	if err != nil {
+		wh.logger.Debug("Client error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusBadRequest,
+			apierrors.BadRequestError,
+		)
+		return nil, false
+	}
+
+	model := webhookmodel.New(wh.db)
+	record, err := model.FindByID(context.Background(), organizationID, webhookID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			wh.logger.Debug("Client error",
+				zap.Error(err),
+			)
+			_ = apierrors.CustomError(c,
+				http.StatusNotFound,
+				apierrors.NotFoundError,
+			)
+			return nil, false
+		}
+
+		wh.logger.Debug("Server error",
+			zap.Error(err),
+		)
+		_ = apierrors.CustomError(c,
+			http.StatusInternalServerError,
+			apierrors.InternalServerError,
+		)
+		return nil, false
+	}
+
+	return record, true
+}
+
+func validateEventTypes(eventTypes []string) error {
+	for _, eventType := range eventTypes {
+		if !events.IsType(eventType) {
+			return ErrUnknownEventType
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	auditmodel "github.com/Roll-Play/togglelabs/pkg/models/audit"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// AuditLog appends an entry to the audit log for every mutating request once
+// it has been handled, whatever its outcome. The actor and organization are
+// read from the context after the handler runs, so handlers that authenticate
+// a user or create an organization can set them for their own entry. The
+// change of the request is already committed by then, entries that can't be
+// stored are logged as errors instead of failing it.
+func AuditLog(db *mongo.Database, log *zap.Logger) echo.MiddlewareFunc {
+	return auditLog(db, log, isMutatingMethod)
+}
+
+// AuditRequest records every request of the routes it is applied to, for
+// routes that change state on a safe method like the OAuth callback.
+func AuditRequest(db *mongo.Database, log *zap.Logger) echo.MiddlewareFunc {
+	return auditLog(db, log, func(string) bool { return true })
+}
+
+func auditLog(db *mongo.Database, log *zap.Logger, audited func(method string) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			if !audited(request.Method) {
+				return next(c)
+			}
+
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			params := make(map[string]string)
+			for i, name := range c.ParamNames() {
+				params[name] = c.ParamValues()[i]
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), config.DBConnectionTimeout*time.Second)
+			defer cancel()
+
+			actorID := contextObjectID(c, "user")
+			organizationID := auditOrganization(ctx, db, c, actorID)
+
+			model := auditmodel.New(db)
+			for _, chainID := range auditChains(ctx, db, organizationID, actorID) {
+				record := auditmodel.NewAuditRecord(
+					chainID,
+					nil,
+					request.Method+" "+c.Path(),
+					params,
+					c.Response().Status,
+					c.RealIP(),
+					request.UserAgent(),
+					c.Response().Header().Get(echo.HeaderXRequestID),
+				)
+				if !actorID.IsZero() {
+					record.ActorID = &actorID
+				}
+
+				if err := model.Append(ctx, record); err != nil {
+					log.Error("Unable to append audit entry",
+						zap.Error(err),
+						zap.String("action", record.Action),
+						zap.String("request_id", record.RequestID),
+					)
+				}
+			}
+
+			return nil
+		}
+	}
+}
+
+// auditOrganization returns the organization whose chain records the request.
+// The organization of the context comes from a header any client sets, so it
+// is only trusted once the actor is a member of it, or when the request was
+// authenticated by the organization itself, like SCIM requests.
+func auditOrganization(
+	ctx context.Context,
+	db *mongo.Database,
+	c echo.Context,
+	actorID primitive.ObjectID,
+) primitive.ObjectID {
+	organizationID := contextObjectID(c, "organization")
+	if verified, _ := c.Get("organization_verified").(bool); verified || organizationID.IsZero() {
+		return organizationID
+	}
+
+	if actorID.IsZero() {
+		return primitive.NilObjectID
+	}
+
+	organization, err := organizationmodel.New(db).FindByID(ctx, organizationID)
+	if err != nil || !apiutils.UserHasPermission(actorID, organization, organizationmodel.ReadOnly) {
+		return primitive.NilObjectID
+	}
+
+	return organizationID
+}
+
+// auditChains returns the chains that record a request of the organization.
+// Requests outside of organizations, like sign-ins, are recorded on the chain
+// of every organization of the actor, so their admins review them, and on the
+// system chain when there is no actor or it belongs to no organization.
+func auditChains(
+	ctx context.Context,
+	db *mongo.Database,
+	organizationID primitive.ObjectID,
+	actorID primitive.ObjectID,
+) []primitive.ObjectID {
+	if !organizationID.IsZero() || actorID.IsZero() {
+		return []primitive.ObjectID{organizationID}
+	}
+
+	organizations, err := organizationmodel.New(db).FindByMember(ctx, actorID)
+	if err != nil || len(organizations) == 0 {
+		return []primitive.ObjectID{auditmodel.SystemChainID}
+	}
+
+	chainIDs := make([]primitive.ObjectID, 0, len(organizations))
+	for _, organization := range organizations {
+		chainIDs = append(chainIDs, organization.ID)
+	}
+
+	return chainIDs
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+func contextObjectID(c echo.Context, key string) primitive.ObjectID {
+	value, ok := c.Get(key).(string)
+	if !ok {
+		return primitive.NilObjectID
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return primitive.NilObjectID
+	}
+
+	return objectID
+}
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
@@ -8,18 +9,29 @@ import (
 
 	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
 	"github.com/Roll-Play/togglelabs/pkg/logger"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
 	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 )
 
 var ErrMissingAuthHeader = errors.New("missing authorization header")
 var ErrInvalidSignMethod = errors.New("invalid signing method")
 var ErrInvalidToken = errors.New("invalid token")
+var ErrRevokedSession = errors.New("revoked session")
 
-func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+// AuthMiddleware authenticates the bearer token of the request and rejects
+// sessions revoked by a password change.
+func AuthMiddleware(db *mongo.Database) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return authenticate(db, next)
+	}
+}
+
+func authenticate(db *mongo.Database, next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		logger, _ := logger.GetInstance()
 		authHeader := c.Request().Header.Get(echo.HeaderAuthorization)
@@ -65,7 +77,36 @@ func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 				})
 			}
 
+			sessionVersion, _ := claims[apiutils.SessionVersionClaim].(float64)
+			userModel := usermodel.New(db)
+			user, err := userModel.FindByID(context.Background(), userID)
+			// Handlers answer for users that don't exist anymore
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				logger.Debug("Server error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusInternalServerError,
+					apierrors.InternalServerError,
+				)
+			}
+
+			if user != nil && int(sessionVersion) != user.SessionVersion {
+				logger.Debug("Client error",
+					zap.Error(ErrRevokedSession))
+				return c.JSON(http.StatusUnauthorized, apierrors.Error{
+					Error:   ErrRevokedSession.Error(),
+					Message: http.StatusText(http.StatusUnauthorized),
+				})
+			}
+
 			c.Set("user", userID.Hex())
+			if issuedAt, ok := claims["iat"].(float64); ok {
+				c.Set("session_issued_at", int64(issuedAt))
+			}
+			if secondFactor, ok := claims[apiutils.SecondFactorClaim].(bool); ok {
+				c.Set("session_second_factor", secondFactor)
+			}
 			return next(c)
 		}
 
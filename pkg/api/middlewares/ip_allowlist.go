@@ -0,0 +1,65 @@
+package middlewares
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/logger"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// IPAllowlist rejects requests from networks the organization doesn't allow
+// its members in. It runs after the permission middlewares, so only members
+// learn of the allowlist. The IP of the client comes from the IP extractor of
+// the server, which only trusts the forwarding headers of known proxies.
+func IPAllowlist(db *mongo.Database) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			logger, _ := logger.GetInstance()
+			organization, err := loadOrganization(c, db)
+			if err != nil {
+				if errors.Is(err, apiutils.ErrNoOrganization) {
+					logger.Debug("Client error",
+						zap.Error(err))
+					return apierrors.CustomError(
+						c,
+						http.StatusBadRequest,
+						apierrors.BadRequestError,
+					)
+				}
+
+				// Handlers answer for organizations that don't exist
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return next(c)
+				}
+
+				logger.Debug("Server error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusInternalServerError,
+					apierrors.InternalServerError,
+				)
+			}
+
+			ip := c.RealIP()
+			if !organization.IPAllowlist.Allows(net.ParseIP(ip)) {
+				logger.Debug("Client error",
+					zap.String("cause", apierrors.IPNotAllowedError),
+					zap.String("ip", ip))
+				return apierrors.CustomError(
+					c,
+					http.StatusForbidden,
+					apierrors.IPNotAllowedError,
+				)
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/logger"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// RequirePermission lets through the members of the organization in context
+// who have permission somewhere in it, through their permission level or one
+// of their roles. Handlers still check the project and environment of what
+// they change. It runs after AuthMiddleware and OrganizationMiddleware and
+// leaves the organization in context, see
+// apiutils.GetOrganizationRecordFromContext.
+func RequirePermission(db *mongo.Database, permission organizationmodel.Permission) echo.MiddlewareFunc {
+	return requireMember(db, func(userID primitive.ObjectID, organization *organizationmodel.OrganizationRecord) bool {
+		return apiutils.UserIsAllowedAnywhere(userID, organization, permission)
+	})
+}
+
+// RequireLevel lets through the members of the organization in context with
+// at least permissionLevel, for routes no role can open like the security
+// settings. Like RequirePermission, it leaves the organization in context.
+func RequireLevel(db *mongo.Database, permissionLevel organizationmodel.PermissionLevelEnum) echo.MiddlewareFunc {
+	return requireMember(db, func(userID primitive.ObjectID, organization *organizationmodel.OrganizationRecord) bool {
+		return apiutils.UserHasPermission(userID, organization, permissionLevel)
+	})
+}
+
+func requireMember(
+	db *mongo.Database,
+	allowed func(userID primitive.ObjectID, organization *organizationmodel.OrganizationRecord) bool,
+) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			logger, _ := logger.GetInstance()
+			userID, err := apiutils.GetUserFromContext(c)
+			if err != nil {
+				logger.Debug("Client error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusUnauthorized,
+					apierrors.UnauthorizedError,
+				)
+			}
+
+			organization, err := loadOrganization(c, db)
+			if err != nil {
+				// Organizations that don't exist look like those of others
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					logger.Debug("Client error",
+						zap.Error(err))
+					return apierrors.CustomError(
+						c,
+						http.StatusForbidden,
+						apierrors.ForbiddenError,
+					)
+				}
+
+				if errors.Is(err, apiutils.ErrNoOrganization) {
+					logger.Debug("Client error",
+						zap.Error(err))
+					return apierrors.CustomError(
+						c,
+						http.StatusBadRequest,
+						apierrors.BadRequestError,
+					)
+				}
+
+				logger.Debug("Server error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusInternalServerError,
+					apierrors.InternalServerError,
+				)
+			}
+
+			if !allowed(userID, organization) {
+				logger.Debug("Client error",
+					zap.String("cause", apierrors.ForbiddenError),
+					zap.String("path", c.Path()))
+				return apierrors.CustomError(
+					c,
+					http.StatusForbidden,
+					apierrors.ForbiddenError,
+				)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// loadOrganization returns the organization in context, reading it once per
+// request for all the middlewares and the handler.
+func loadOrganization(c echo.Context, db *mongo.Database) (*organizationmodel.OrganizationRecord, error) {
+	if organization, err := apiutils.GetOrganizationRecordFromContext(c); err == nil {
+		return organization, nil
+	}
+
+	organizationID, err := apiutils.GetOrganizationFromContext(c)
+	if err != nil {
+		return nil, apiutils.ErrNoOrganization
+	}
+
+	organizationModel := organizationmodel.New(db)
+	organization, err := organizationModel.FindByID(context.Background(), organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set("organization_record", organization)
+	return organization, nil
+}
@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/logger"
+	"github.com/Roll-Play/togglelabs/pkg/ratelimit"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// RateLimitKey returns what the request counts against, requests without one
+// aren't limited.
+type RateLimitKey func(c echo.Context) string
+
+// IPExtractor reads the IP of clients from X-Forwarded-For when they connect
+// through the trusted proxies, and from the connection otherwise.
+func IPExtractor(proxies []*net.IPNet) echo.IPExtractor {
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, proxy := range proxies {
+		options = append(options, echo.TrustIPRange(proxy))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// ByIP counts requests against the IP of the client, as read by the
+// IPExtractor of the server so clients can't pick their own.
+func ByIP(c echo.Context) string {
+	return c.RealIP()
+}
+
+// ByUser counts requests against the signed in user, it runs after
+// AuthMiddleware.
+func ByUser(c echo.Context) string {
+	userID, err := apiutils.GetUserFromContext(c)
+	if err != nil {
+		return ""
+	}
+
+	return userID.Hex()
+}
+
+// RateLimit rejects the requests that go over limit with 429 and how long to
+// wait in Retry-After. Requests go through when the store fails, so an outage
+// of the store doesn't take the API down.
+func RateLimit(store ratelimit.Store, limit ratelimit.Limit, key RateLimitKey) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			logger, _ := logger.GetInstance()
+			subject := key(c)
+			if subject == "" {
+				return next(c)
+			}
+
+			retryAfter, err := limit.Allow(context.Background(), store, subject, time.Now())
+			if err != nil {
+				logger.Debug("Server error",
+					zap.Error(err))
+				return next(c)
+			}
+
+			if retryAfter > 0 {
+				logger.Debug("Client error",
+					zap.String("cause", apierrors.TooManyRequestsError),
+					zap.String("limit", limit.Name))
+				apiutils.SetRetryAfter(c, retryAfter)
+				return apierrors.CustomError(
+					c,
+					http.StatusTooManyRequests,
+					apierrors.TooManyRequestsError,
+				)
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequestID keeps the X-Request-ID sent by the client, or generates one, and
+// echoes it back in the response so entries of the audit log can be traced.
+func RequestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(echo.HeaderXRequestID)
+		if id == "" {
+			bytes := make([]byte, 16)
+			if _, err := rand.Read(bytes); err != nil {
+				return err
+			}
+			id = hex.EncodeToString(bytes)
+			c.Request().Header.Set(echo.HeaderXRequestID, id)
+		}
+
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		return next(c)
+	}
+}
@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/logger"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// TwoFactorPolicy rejects users without two-factor authentication from the
+// organizations that require it, and sessions of enrolled users that didn't
+// pass it. It runs after the permission middlewares. Users
+// without a password nor an app sign in through an identity provider, which is
+// trusted to enforce its own second factor.
+func TwoFactorPolicy(db *mongo.Database) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			logger, _ := logger.GetInstance()
+			userID, err := apiutils.GetUserFromContext(c)
+			if err != nil {
+				logger.Debug("Client error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusUnauthorized,
+					apierrors.UnauthorizedError,
+				)
+			}
+
+			organization, err := loadOrganization(c, db)
+			if err != nil {
+				if errors.Is(err, apiutils.ErrNoOrganization) {
+					logger.Debug("Client error",
+						zap.Error(err))
+					return apierrors.CustomError(
+						c,
+						http.StatusBadRequest,
+						apierrors.BadRequestError,
+					)
+				}
+
+				// Handlers answer for organizations that don't exist
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return next(c)
+				}
+
+				logger.Debug("Server error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusInternalServerError,
+					apierrors.InternalServerError,
+				)
+			}
+
+			if !organization.RequireTwoFactor {
+				return next(c)
+			}
+
+			userModel := usermodel.New(db)
+			user, err := userModel.FindByID(context.Background(), userID)
+			if err != nil {
+				logger.Debug("Server error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusInternalServerError,
+					apierrors.InternalServerError,
+				)
+			}
+
+			if user.TwoFactorEnabled() && !apiutils.GetSessionSecondFactorFromContext(c) ||
+				user.Password != "" && !user.TwoFactorEnabled() {
+				logger.Debug("Client error",
+					zap.String("cause", apierrors.TwoFactorRequiredError))
+				return apierrors.CustomError(
+					c,
+					http.StatusForbidden,
+					apierrors.TwoFactorRequiredError,
+				)
+			}
+
+			return next(c)
+		}
+	}
+}
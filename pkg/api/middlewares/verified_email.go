@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+
+	apierrors "github.com/Roll-Play/togglelabs/pkg/api/error"
+	"github.com/Roll-Play/togglelabs/pkg/logger"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// VerifiedEmail rejects users that didn't verify their email address yet, it
+// runs after AuthMiddleware on the routes config.EmailVerificationPolicy
+// reserves to verified users.
+func VerifiedEmail(db *mongo.Database) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			logger, _ := logger.GetInstance()
+			userID, err := apiutils.GetUserFromContext(c)
+			if err != nil {
+				logger.Debug("Client error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusUnauthorized,
+					apierrors.UnauthorizedError,
+				)
+			}
+
+			userModel := usermodel.New(db)
+			user, err := userModel.FindByID(context.Background(), userID)
+			if err != nil {
+				logger.Debug("Server error",
+					zap.Error(err))
+				return apierrors.CustomError(
+					c,
+					http.StatusInternalServerError,
+					apierrors.InternalServerError,
+				)
+			}
+
+			if !user.EmailVerified {
+				logger.Debug("Client error",
+					zap.String("cause", apierrors.EmailNotVerifiedError))
+				return apierrors.CustomError(
+					c,
+					http.StatusForbidden,
+					apierrors.EmailNotVerifiedError,
+				)
+			}
+
+			return next(c)
+		}
+	}
+}
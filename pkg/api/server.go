@@ -1,29 +1,76 @@
 package api
 
 import (
+	"context"
 	"os"
+	"time"
 
 	"github.com/Roll-Play/togglelabs/pkg/api/handlers"
 	"github.com/Roll-Play/togglelabs/pkg/api/middlewares"
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/events"
+	"github.com/Roll-Play/togglelabs/pkg/export"
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+	auditmodel "github.com/Roll-Play/togglelabs/pkg/models/audit"
+	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
+	"github.com/Roll-Play/togglelabs/pkg/notification"
+	"github.com/Roll-Play/togglelabs/pkg/oidc"
+	"github.com/Roll-Play/togglelabs/pkg/ratelimit"
 	"github.com/Roll-Play/togglelabs/pkg/storage"
-	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	httputils "github.com/Roll-Play/togglelabs/pkg/utils/http_utils"
+	"github.com/Roll-Play/togglelabs/pkg/webhook"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
 type App struct {
-	port    string
-	server  *echo.Echo
-	storage *storage.MongoStorage
-	logger  *zap.Logger
+	port        string
+	server      *echo.Echo
+	storage     *storage.MongoStorage
+	logger      *zap.Logger
+	dispatcher  *webhook.Dispatcher
+	notifier    *notification.Notifier
+	mailer      *mailer.Sender
+	events      *events.Bus
+	rateLimits  ratelimit.Store
+	limits      ratelimit.Limits
+	emailPolicy config.EmailVerificationPolicy
+	exports     export.Uploader
 }
 
+// Timeout of the requests sent to URLs given by users
+const outgoingRequestTimeout = 30 * time.Second
+
+// Interval between links of the audit entries left pending
+const auditLinkInterval = time.Minute
+
 func (a *App) Listen() error {
+	go a.dispatcher.Run(context.Background())
+	go a.mailer.Run(context.Background())
+	go a.linkAuditEntries(context.Background())
+
 	return a.server.Start(a.port)
 }
 
+// linkAuditEntries links the audit entries left pending by contended appends
+// to chains that no later request appends to, until ctx is done.
+func (a *App) linkAuditEntries(ctx context.Context) {
+	ticker := time.NewTicker(auditLinkInterval)
+	defer ticker.Stop()
+
+	model := auditmodel.New(a.storage.DB())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := model.LinkPending(ctx); err != nil {
+				a.logger.Error("Unable to link audit entries", zap.Error(err))
+			}
+		}
+	}
+}
+
 func normalizePort(port string) string {
 	if []byte(port)[0] != ':' {
 		return string(append([]byte(":"), []byte(port)...))
@@ -32,77 +79,330 @@ func normalizePort(port string) string {
 	return port
 }
 
-func NewApp(port string, storage *storage.MongoStorage, logger *zap.Logger) *App {
+func NewApp(port string, storage *storage.MongoStorage, logger *zap.Logger) (*App, error) {
+	proxies, err := config.TrustedProxiesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
 	server := echo.New()
+	server.IPExtractor = middlewares.IPExtractor(proxies)
+
+	// Webhooks and notifications go to URLs given by users, which must not
+	// reach the network of the deployment
+	dispatcher := webhook.NewDispatcher(storage.DB(), logger, httputils.NewPublicClient(outgoingRequestTimeout))
+	notifier := notification.NewNotifier(
+		storage.DB(),
+		logger,
+		httputils.NewPublicClient(outgoingRequestTimeout),
+		os.Getenv("DASHBOARD_URL"),
+	)
+
+	transport, err := mailer.NewTransportFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	emailPolicy, err := config.EmailVerificationPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimits, err := ratelimit.NewStoreFromEnv(storage.DB())
+	if err != nil {
+		return nil, err
+	}
+
+	exports, err := export.NewS3UploaderFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
 	app := &App{
-		server:  server,
-		port:    normalizePort(port),
-		storage: storage,
-		logger:  logger,
+		server:      server,
+		port:        normalizePort(port),
+		storage:     storage,
+		logger:      logger,
+		dispatcher:  dispatcher,
+		notifier:    notifier,
+		mailer:      mailer.NewSender(transport, logger, mailer.FromAddressFromEnv()),
+		events:      events.NewBus(dispatcher, notifier),
+		rateLimits:  rateLimits,
+		limits:      ratelimit.LimitsFromEnv(),
+		emailPolicy: emailPolicy,
+		exports:     exports,
 	}
-	app.server.Use(middlewares.ZapLogger(logger))
+	app.server.Use(
+		middlewares.RequestID,
+		middlewares.ZapLogger(logger),
+		middlewares.RateLimit(app.rateLimits, app.limits.IP, middlewares.ByIP),
+		middlewares.AuditLog(storage.DB(), logger),
+	)
 
 	registerRoutes(app)
 
-	return app
+	return app, nil
 }
 
 func registerRoutes(app *App) {
 	app.server.GET("/healthz", handlers.HealthHandler)
 
-	oauthConfig := &oauth2.Config{
-		RedirectURL:  os.Getenv("REDIRECT_URL"),
-		ClientID:     os.Getenv("CLIENT_ID"),
-		ClientSecret: os.Getenv("CLIENT_SECRET"),
-		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "openid"},
-		Endpoint:     google.Endpoint,
+	// Signed in users are limited on their own, wherever they come from
+	userLimit := middlewares.RateLimit(app.rateLimits, app.limits.User, middlewares.ByUser)
+	authenticate := middlewares.AuthMiddleware(app.storage.DB())
+	authMiddleware := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return authenticate(userLimit(next))
 	}
+	authLimit := middlewares.RateLimit(app.rateLimits, app.limits.Auth, middlewares.ByIP)
 
 	oauthHandler := handlers.NewOAuthHandler(
 		app.storage.DB(),
-		oauthConfig,
 		app.logger,
-		&apiutils.HTTPClient{},
-		apiutils.NewOAuthClient(oauthConfig),
+		oidc.NewRegistry(nil),
+		// Providers of organizations are given by their admins, which must
+		// not reach the network of the deployment
+		oidc.NewTenantRegistry(httputils.NewPublicClient(outgoingRequestTimeout)),
+		oidc.ConfigFromEnv(),
+		config.OAuthRedirectAllowlistFromEnv(),
 	)
 	app.server.POST("/oauth", oauthHandler.SignIn)
-	app.server.GET("/callback", oauthHandler.Callback)
+	app.server.GET("/callback", oauthHandler.Callback, middlewares.AuditRequest(app.storage.DB(), app.logger))
+	app.server.POST("/oauth/:organizationID", oauthHandler.SignIn)
+	app.server.GET(
+		"/oauth/:organizationID/callback",
+		oauthHandler.Callback,
+		middlewares.AuditRequest(app.storage.DB(), app.logger),
+	)
+
+	dashboardURL := os.Getenv("DASHBOARD_URL")
+	signUpHandler := handlers.NewSignUpHandler(app.storage.DB(), app.logger, app.mailer, dashboardURL)
+	app.server.POST("/signup", signUpHandler.PostUser, authLimit)
 
-	signUpHandler := handlers.NewSignUpHandler(app.storage.DB(), app.logger)
-	app.server.POST("/signup", signUpHandler.PostUser)
+	emailVerificationHandler := handlers.NewEmailVerificationHandler(
+		app.storage.DB(),
+		app.logger,
+		app.mailer,
+		dashboardURL,
+	)
+	app.server.POST("/verify-email", emailVerificationHandler.PostVerifyEmail)
 
 	signInHandler := handlers.NewSignInHandler(app.storage.DB(), app.logger)
-	app.server.POST("/signin", signInHandler.PostSignIn)
+	app.server.POST("/signin", signInHandler.PostSignIn, authLimit)
+	app.server.POST("/signin/2fa", signInHandler.PostSignInTwoFactor, authLimit)
+
+	passwordHandler := handlers.NewPasswordHandler(app.storage.DB(), app.logger, app.mailer, dashboardURL)
+	app.server.POST("/password/forgot", passwordHandler.PostPasswordResetRequest, authLimit)
+	app.server.POST("/password/reset", passwordHandler.PostPasswordReset, authLimit)
 
 	userHandler := handlers.NewUserHandler(app.storage.DB(), app.logger)
-	userGroup := app.server.Group("/user", middlewares.AuthMiddleware)
+	userGroup := app.server.Group("/user", authMiddleware)
 	userGroup.GET("", userHandler.GetUser)
 	userGroup.PATCH("", userHandler.PatchUser)
+	userGroup.POST("/verify-email/resend", emailVerificationHandler.PostResendVerification)
+	userGroup.PATCH("/password", passwordHandler.PatchPassword)
+
+	userGroup.GET("/identities", oauthHandler.ListIdentities)
+	userGroup.POST("/identities", oauthHandler.PostIdentity)
+	userGroup.DELETE("/identities/:identityID", oauthHandler.DeleteIdentity)
+
+	twoFactorHandler := handlers.NewTwoFactorHandler(app.storage.DB(), app.logger)
+	userGroup.POST("/2fa", twoFactorHandler.PostEnrollment)
+	userGroup.POST("/2fa/verify", twoFactorHandler.PostVerification)
+	userGroup.DELETE("/2fa", twoFactorHandler.DeleteTwoFactor)
+
+	twoFactorPolicy := middlewares.TwoFactorPolicy(app.storage.DB())
+	ipAllowlist := middlewares.IPAllowlist(app.storage.DB())
+	// Members reach organizations from the networks they allow, with a second
+	// factor when they require one. Membership is checked first, so others
+	// don't learn the policies of the organization.
+	withPolicies := func(permission echo.MiddlewareFunc) echo.MiddlewareFunc {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return permission(ipAllowlist(twoFactorPolicy(next)))
+		}
+	}
+
+	isMember := withPolicies(middlewares.RequireLevel(app.storage.DB(), organizationmodel.ReadOnly))
+	isAdmin := withPolicies(middlewares.RequireLevel(app.storage.DB(), organizationmodel.Admin))
+	canReadFlags := withPolicies(middlewares.RequirePermission(app.storage.DB(), organizationmodel.FlagRead))
+	canEditFlags := withPolicies(middlewares.RequirePermission(app.storage.DB(), organizationmodel.FlagEdit))
+	canToggleFlags := withPolicies(middlewares.RequirePermission(app.storage.DB(), organizationmodel.FlagToggle))
+	canApproveFlags := withPolicies(middlewares.RequirePermission(app.storage.DB(), organizationmodel.FlagApprove))
+	canEditProjects := withPolicies(middlewares.RequirePermission(app.storage.DB(), organizationmodel.ProjectEdit))
 
-	organizationHandler := handlers.NewOrganizationHandler(app.storage.DB(), app.logger)
-	app.server.POST("/organizations", middlewares.AuthMiddleware(organizationHandler.PostOrganization))
-	app.server.GET("/organizations", middlewares.AuthMiddleware(organizationHandler.GetOrganization), middlewares.OrganizationMiddleware)
-	app.server.POST("/projects", middlewares.AuthMiddleware(organizationHandler.PostProject), middlewares.OrganizationMiddleware)
-	app.server.DELETE("/projects/:projectID", middlewares.AuthMiddleware(organizationHandler.DeleteProject), middlewares.OrganizationMiddleware)
-
-	featureFlagHandler := handlers.NewFeatureFlagHandler(app.storage.DB(), app.logger)
-	featureGroup := app.server.Group("/features", middlewares.AuthMiddleware, middlewares.OrganizationMiddleware)
-	featureGroup.POST("", featureFlagHandler.PostFeatureFlag)
-	featureGroup.GET("", featureFlagHandler.ListFeatureFlags)
-	featureGroup.PATCH("/:featureFlagID", featureFlagHandler.PatchFeatureFlag)
+	organizationHandler := handlers.NewOrganizationHandler(app.storage.DB(), app.logger, app.events)
+	postOrganization := organizationHandler.PostOrganization
+	if app.emailPolicy.Organizations {
+		postOrganization = middlewares.VerifiedEmail(app.storage.DB())(postOrganization)
+	}
+	app.server.POST("/organizations", authMiddleware(postOrganization))
+	app.server.GET(
+		"/organizations",
+		authMiddleware(isMember(organizationHandler.GetOrganization)),
+		middlewares.OrganizationMiddleware,
+	)
+	app.server.PATCH(
+		"/organizations/settings",
+		authMiddleware(isAdmin(organizationHandler.PatchOrganizationSettings)),
+		middlewares.OrganizationMiddleware,
+	)
+	app.server.PUT(
+		"/organizations/ip-allowlist",
+		authMiddleware(isAdmin(organizationHandler.PutIPAllowlist)),
+		middlewares.OrganizationMiddleware,
+	)
+	app.server.PUT(
+		"/organizations/oidc",
+		authMiddleware(isAdmin(oauthHandler.PutOrganizationProvider)),
+		middlewares.OrganizationMiddleware,
+	)
+	app.server.DELETE(
+		"/organizations/oidc",
+		authMiddleware(isAdmin(oauthHandler.DeleteOrganizationProvider)),
+		middlewares.OrganizationMiddleware,
+	)
+
+	scimHandler := handlers.NewSCIMHandler(app.storage.DB(), app.logger, app.events)
+	app.server.POST(
+		"/organizations/scim",
+		authMiddleware(isAdmin(scimHandler.PostSCIMToken)),
+		middlewares.OrganizationMiddleware,
+	)
+	app.server.DELETE(
+		"/organizations/scim",
+		authMiddleware(isAdmin(scimHandler.DeleteSCIMToken)),
+		middlewares.OrganizationMiddleware,
+	)
+	// Identity providers call SCIM from their own networks rather than the ones
+	// members work from, so the dashboard allowlist doesn't apply to them
+	scimGroup := app.server.Group(handlers.SCIMBasePath, scimHandler.Authenticate)
+	scimGroup.GET("/ServiceProviderConfig", scimHandler.GetServiceProviderConfig)
+	scimGroup.GET("/Users", scimHandler.ListUsers)
+	scimGroup.POST("/Users", scimHandler.PostUser)
+	scimGroup.GET("/Users/:userID", scimHandler.GetUser)
+	scimGroup.PUT("/Users/:userID", scimHandler.PutUser)
+	scimGroup.PATCH("/Users/:userID", scimHandler.PatchUser)
+	scimGroup.DELETE("/Users/:userID", scimHandler.DeleteUser)
+	scimGroup.GET("/Groups", scimHandler.ListGroups)
+	scimGroup.GET("/Groups/:groupID", scimHandler.GetGroup)
+	scimGroup.PUT("/Groups/:groupID", scimHandler.PutGroup)
+	scimGroup.PATCH("/Groups/:groupID", scimHandler.PatchGroup)
+
+	roleHandler := handlers.NewRoleHandler(app.storage.DB(), app.logger)
+	roleGroup := app.server.Group("/roles", authMiddleware, middlewares.OrganizationMiddleware)
+	roleGroup.GET("", roleHandler.ListRoles, isMember)
+	roleGroup.POST("", roleHandler.PostRole, isAdmin)
+	roleGroup.PUT("/:roleID", roleHandler.PutRole, isAdmin)
+	roleGroup.DELETE("/:roleID", roleHandler.DeleteRole, isAdmin)
+	app.server.PUT(
+		"/members/:userID/roles",
+		authMiddleware(isAdmin(roleHandler.PutMemberRoles)),
+		middlewares.OrganizationMiddleware,
+	)
+
+	environmentHandler := handlers.NewEnvironmentHandler(app.storage.DB(), app.logger)
+	environmentGroup := app.server.Group(
+		"/environments",
+		authMiddleware,
+		middlewares.OrganizationMiddleware,
+	)
+	environmentGroup.GET("", environmentHandler.ListEnvironments, isMember)
+	environmentGroup.PUT("/:name", environmentHandler.PutEnvironment, isAdmin)
+	environmentGroup.DELETE("/:name", environmentHandler.DeleteEnvironment, isAdmin)
+
+	freezeWindowHandler := handlers.NewFreezeWindowHandler(app.storage.DB(), app.logger)
+	freezeWindowGroup := app.server.Group(
+		"/freeze-windows",
+		authMiddleware,
+		middlewares.OrganizationMiddleware,
+	)
+	freezeWindowGroup.GET("", freezeWindowHandler.ListFreezeWindows, isMember)
+	freezeWindowGroup.POST("", freezeWindowHandler.PostFreezeWindow, isAdmin)
+	freezeWindowGroup.DELETE("/:windowID", freezeWindowHandler.DeleteFreezeWindow, isAdmin)
+
+	app.server.POST(
+		"/projects",
+		authMiddleware(canEditProjects(organizationHandler.PostProject)),
+		middlewares.OrganizationMiddleware,
+	)
+	app.server.DELETE(
+		"/projects/:projectID",
+		authMiddleware(canEditProjects(organizationHandler.DeleteProject)),
+		middlewares.OrganizationMiddleware,
+	)
+
+	featureFlagHandler := handlers.NewFeatureFlagHandler(app.storage.DB(), app.logger, app.events)
+	featureGroup := app.server.Group("/features", authMiddleware, middlewares.OrganizationMiddleware)
+	featureGroup.POST("", featureFlagHandler.PostFeatureFlag, canEditFlags)
+	featureGroup.GET("", featureFlagHandler.ListFeatureFlags, canReadFlags)
+	featureGroup.GET("/:featureFlagID", featureFlagHandler.GetFeatureFlag, canReadFlags)
+	featureGroup.PATCH("/:featureFlagID", featureFlagHandler.PatchFeatureFlag, canEditFlags)
 	featureGroup.PATCH(
 		"/:featureFlagID/revisions/:revisionID",
 		featureFlagHandler.ApproveRevision,
+		canApproveFlags,
 	)
-	featureGroup.DELETE("/:featureFlagID", featureFlagHandler.DeleteFeatureFlag)
+	featureGroup.DELETE("/:featureFlagID", featureFlagHandler.DeleteFeatureFlag, canEditFlags)
 	featureGroup.PATCH(
 		"/:featureFlagID/rollback",
 		featureFlagHandler.RollbackFeatureFlagVersion,
+		canApproveFlags,
 	)
 	featureGroup.PATCH(
 		"/:featureFlagID/toggle",
 		featureFlagHandler.ToggleFeatureFlag,
+		canToggleFlags,
+	)
+	featureGroup.PATCH("/:featureFlagID/tags", featureFlagHandler.PatchFeatureFlagTags, canEditFlags)
+	featureGroup.PATCH("/:featureFlagID/name", featureFlagHandler.PatchFeatureFlagName, canEditFlags)
+
+	timelineHandler := handlers.NewTimelineHandler(app.storage.DB(), app.logger)
+	featureGroup.GET("/:featureFlagID/timeline", timelineHandler.ListFeatureFlagTimeline, canReadFlags)
+	app.server.GET(
+		"/activity",
+		timelineHandler.ListActivity,
+		authMiddleware,
+		middlewares.OrganizationMiddleware,
+		canReadFlags,
+	)
+
+	auditHandler := handlers.NewAuditHandler(app.storage.DB(), app.logger, app.exports)
+	auditGroup := app.server.Group(
+		"/audit",
+		authMiddleware,
+		middlewares.OrganizationMiddleware,
+		isAdmin,
+	)
+	auditGroup.GET("", auditHandler.ListAuditLog)
+	auditGroup.GET("/verify", auditHandler.VerifyAuditLog)
+	auditGroup.GET("/export", auditHandler.ExportAuditLog)
+	auditGroup.POST("/exports", auditHandler.PostAuditExport)
+
+	webhookHandler := handlers.NewWebhookHandler(app.storage.DB(), app.logger, app.dispatcher)
+	webhookGroup := app.server.Group(
+		"/webhooks",
+		authMiddleware,
+		middlewares.OrganizationMiddleware,
+		isAdmin,
+	)
+	webhookGroup.POST("", webhookHandler.PostWebhook)
+	webhookGroup.GET("", webhookHandler.ListWebhooks)
+	webhookGroup.GET("/:webhookID", webhookHandler.GetWebhook)
+	webhookGroup.PATCH("/:webhookID", webhookHandler.PatchWebhook)
+	webhookGroup.DELETE("/:webhookID", webhookHandler.DeleteWebhook)
+	webhookGroup.GET("/:webhookID/deliveries", webhookHandler.ListDeliveries)
+	webhookGroup.POST("/:webhookID/deliveries/:deliveryID/redeliver", webhookHandler.Redeliver)
+	webhookGroup.POST("/:webhookID/test", webhookHandler.PostTestEvent)
+
+	notificationHandler := handlers.NewNotificationHandler(app.storage.DB(), app.logger, app.notifier)
+	notificationGroup := app.server.Group(
+		"/notifications",
+		authMiddleware,
+		middlewares.OrganizationMiddleware,
+		isAdmin,
 	)
-	featureGroup.PATCH("/:featureFlagID/tags", featureFlagHandler.PatchFeatureFlagTags)
+	notificationGroup.POST("", notificationHandler.PostIntegration)
+	notificationGroup.GET("", notificationHandler.ListIntegrations)
+	notificationGroup.PATCH("/:integrationID", notificationHandler.PatchIntegration)
+	notificationGroup.DELETE("/:integrationID", notificationHandler.DeleteIntegration)
+	notificationGroup.POST("/:integrationID/test", notificationHandler.PostTestNotification)
 }
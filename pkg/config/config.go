@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
 
 const (
 	DBConnectionTimeout   = 10
@@ -10,6 +15,9 @@ const (
 	TestDBName            = "togglelabs_test"
 	DevEnvironment        = "DEV"
 	ProductionEnvironment = "PRODUCTION"
+	TimelineRecentEntries = 10
+	DefaultPageSize       = 10
+	MaxPageSize           = 100
 )
 
 var Environment string
@@ -24,3 +32,66 @@ func StartEnvironment() {
 
 	Environment = DevEnvironment
 }
+
+// EmailVerificationPolicy lists the actions reserved to users with a verified
+// email, read from the comma separated REQUIRE_VERIFIED_EMAIL, like
+// "organizations".
+type EmailVerificationPolicy struct {
+	Organizations bool
+}
+
+// EmailVerificationPolicyFromEnv fails on actions it can't enforce, so a
+// policy never looks stricter than it is.
+func EmailVerificationPolicyFromEnv() (EmailVerificationPolicy, error) {
+	policy := EmailVerificationPolicy{}
+	for _, action := range strings.Split(os.Getenv("REQUIRE_VERIFIED_EMAIL"), ",") {
+		switch action = strings.TrimSpace(action); action {
+		case "":
+		case "organizations":
+			policy.Organizations = true
+		default:
+			return policy, fmt.Errorf("unsupported REQUIRE_VERIFIED_EMAIL action %q", action)
+		}
+	}
+
+	return policy, nil
+}
+
+// OAuthRedirectAllowlistFromEnv lists the origins OAuth sign-ins may send
+// users back to, read from the comma separated OAUTH_REDIRECT_ALLOWLIST and
+// falling back to the origin of DASHBOARD_URL.
+func OAuthRedirectAllowlistFromEnv() []string {
+	origins := make([]string, 0)
+	for _, origin := range strings.Split(os.Getenv("OAUTH_REDIRECT_ALLOWLIST"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	if len(origins) == 0 && os.Getenv("DASHBOARD_URL") != "" {
+		origins = append(origins, os.Getenv("DASHBOARD_URL"))
+	}
+
+	return origins
+}
+
+// TrustedProxiesFromEnv lists the networks of the proxies whose forwarding
+// headers tell the IP of clients, read from the comma separated CIDRs of
+// TRUSTED_PROXIES. Invalid entries fail, since skipping them would change the
+// IP that allowlists and rate limits see.
+func TrustedProxiesFromEnv() ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0)
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES network %q: %w", proxy, err)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
@@ -0,0 +1,31 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	proxies, err := config.TrustedProxiesFromEnv()
+	assert.NoError(t, err)
+	assert.Empty(t, proxies)
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 2001:db8::/32,")
+	proxies, err = config.TrustedProxiesFromEnv()
+	assert.NoError(t, err)
+	if assert.Len(t, proxies, 2) {
+		assert.Equal(t, "10.0.0.0/8", proxies[0].String())
+		assert.Equal(t, "2001:db8::/32", proxies[1].String())
+	}
+}
+
+func TestTrustedProxiesFromEnvFailsOnInvalidNetworks(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "10.0.0.1", "10.0.0.0/8,proxy"} {
+		t.Setenv("TRUSTED_PROXIES", value)
+
+		_, err := config.TrustedProxiesFromEnv()
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"time"
+
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Type = string
+
+// Flag events share their type with the timeline event they're built from.
+const (
+	ProjectCreated Type = "project.created"
+	ProjectDeleted Type = "project.deleted"
+	MemberAdded    Type = "member.added"
+	MemberRemoved  Type = "member.removed"
+)
+
+// Types are the event types integrations can subscribe to.
+var Types = append(append([]Type{}, timelinemodel.EventTypes...),
+	ProjectCreated,
+	ProjectDeleted,
+	MemberAdded,
+	MemberRemoved,
+)
+
+func IsType(eventType string) bool {
+	for _, known := range Types {
+		if known == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Event struct {
+	ID             primitive.ObjectID `json:"id"`
+	Type           Type               `json:"type"`
+	OrganizationID primitive.ObjectID `json:"organization_id"`
+	ActorID        primitive.ObjectID `json:"actor_id"`
+	Timestamp      primitive.DateTime `json:"timestamp"`
+	Data           interface{}        `json:"data"`
+}
+
+func New(organizationID, actorID primitive.ObjectID, eventType Type, data interface{}) *Event {
+	return &Event{
+		ID:             primitive.NewObjectID(),
+		Type:           eventType,
+		OrganizationID: organizationID,
+		ActorID:        actorID,
+		Timestamp:      primitive.NewDateTimeFromTime(time.Now().UTC()),
+		Data:           data,
+	}
+}
+
+// FromTimeline returns the event of a committed timeline record, carrying the
+// record with its before and after snapshots.
+func FromTimeline(record *timelinemodel.TimelineRecord) *Event {
+	return &Event{
+		ID:             record.ID,
+		Type:           record.EventType,
+		OrganizationID: record.OrganizationID,
+		ActorID:        record.ActorID,
+		Timestamp:      record.Timestamp,
+		Data:           record,
+	}
+}
+
+// Subscriber reacts to published events. Handle runs on the request path, so
+// slow work like network calls has to happen in the background.
+type Subscriber interface {
+	Handle(event *Event)
+}
+
+type Publisher interface {
+	Publish(event *Event)
+}
+
+// Bus hands every published event to all of its subscribers.
+type Bus struct {
+	subscribers []Subscriber
+}
+
+func NewBus(subscribers ...Subscriber) *Bus {
+	return &Bus{subscribers: subscribers}
+}
+
+// Publish must only be called once the change behind event is committed.
+func (b *Bus) Publish(event *Event) {
+	for _, subscriber := range b.subscribers {
+		subscriber.Handle(event)
+	}
+}
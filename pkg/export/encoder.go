@@ -0,0 +1,188 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	auditmodel "github.com/Roll-Play/togglelabs/pkg/models/audit"
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Format string
+
+const (
+	JSONLines Format = "jsonl"
+	CSV       Format = "csv"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case "", JSONLines:
+		return JSONLines, nil
+	case CSV:
+		return CSV, nil
+	}
+
+	return "", ErrUnknownFormat
+}
+
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv"
+	}
+
+	return "application/x-ndjson"
+}
+
+func (f Format) Extension() string {
+	return string(f)
+}
+
+// Encoder writes one entry per line. JSON Lines encodes the whole entry while
+// CSV flattens it to the columns of the header.
+type Encoder struct {
+	format Format
+	buffer *bufio.Writer
+	json   *json.Encoder
+	csv    *csv.Writer
+}
+
+// NewEncoder returns an encoder writing to w. CSV exports start with header
+// so empty exports are still valid files.
+func NewEncoder(w io.Writer, format Format, header []string) (*Encoder, error) {
+	buffer := bufio.NewWriter(w)
+	encoder := &Encoder{
+		format: format,
+		buffer: buffer,
+	}
+
+	if format == CSV {
+		encoder.csv = csv.NewWriter(buffer)
+		if err := encoder.csv.Write(header); err != nil {
+			return nil, err
+		}
+		return encoder, nil
+	}
+
+	encoder.json = json.NewEncoder(buffer)
+	return encoder, nil
+}
+
+// Encode writes value as a JSON line, or row as a CSV record.
+func (e *Encoder) Encode(value interface{}, row []string) error {
+	if e.format == CSV {
+		return e.csv.Write(escapeFormulas(row))
+	}
+
+	return e.json.Encode(value)
+}
+
+// escapeFormulas prefixes with a quote the cells spreadsheets would run as a
+// formula, like a user agent starting with '='.
+func escapeFormulas(row []string) []string {
+	escaped := make([]string, len(row))
+	for i, cell := range row {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cell = "'" + cell
+		}
+		escaped[i] = cell
+	}
+
+	return escaped
+}
+
+// Flush writes buffered entries to the underlying writer.
+func (e *Encoder) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+
+	return e.buffer.Flush()
+}
+
+var AuditHeader = []string{
+	"sequence",
+	"timestamp",
+	"actor_id",
+	"action",
+	"params",
+	"status",
+	"ip",
+	"user_agent",
+	"request_id",
+	"previous_hash",
+	"hash",
+}
+
+func AuditRow(record *auditmodel.AuditRecord) []string {
+	params := ""
+	if len(record.Params) > 0 {
+		// Marshaling a map of strings can't fail
+		data, _ := json.Marshal(record.Params)
+		params = string(data)
+	}
+
+	return []string{
+		strconv.FormatInt(record.Sequence, 10),
+		formatDateTime(record.Timestamp),
+		formatObjectID(record.ActorID),
+		record.Action,
+		params,
+		strconv.Itoa(record.Status),
+		record.IP,
+		record.UserAgent,
+		record.RequestID,
+		record.PreviousHash,
+		record.Hash,
+	}
+}
+
+var TimelineHeader = []string{
+	"_id",
+	"timestamp",
+	"event_type",
+	"feature_flag_id",
+	"actor_id",
+	"environment",
+	"revision_id",
+	"last_revision_id",
+}
+
+// TimelineRow leaves out the flag snapshots, which are only part of JSON Lines
+// exports.
+func TimelineRow(record *timelinemodel.TimelineRecord) []string {
+	return []string{
+		record.ID.Hex(),
+		formatDateTime(record.Timestamp),
+		record.EventType,
+		record.FeatureFlagID.Hex(),
+		record.ActorID.Hex(),
+		record.Environment,
+		formatObjectID(record.RevisionID),
+		formatObjectID(record.LastRevisionID),
+	}
+}
+
+func formatDateTime(dateTime primitive.DateTime) string {
+	return dateTime.Time().UTC().Format(time.RFC3339Nano)
+}
+
+func formatObjectID(objectID *primitive.ObjectID) string {
+	if objectID == nil {
+		return ""
+	}
+
+	return objectID.Hex()
+}
@@ -0,0 +1,85 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const defaultS3Region = "us-east-1"
+
+// Uploader delivers exports to an object storage.
+type Uploader interface {
+	Upload(ctx context.Context, key, contentType string, body io.Reader) (string, error)
+}
+
+type S3Uploader struct {
+	bucket   string
+	uploader *s3manager.Uploader
+}
+
+var ErrS3BucketRequired = errors.New("EXPORT_S3_BUCKET is required along with the other EXPORT_S3 variables")
+
+// NewS3UploaderFromEnv configures delivery to the EXPORT_S3_BUCKET bucket and
+// returns nil when no EXPORT_S3 variable is set. EXPORT_S3_ENDPOINT points it
+// to an S3-compatible server like MinIO, credentials are read from the usual
+// AWS environment variables.
+func NewS3UploaderFromEnv() (Uploader, error) {
+	bucket := os.Getenv("EXPORT_S3_BUCKET")
+	region := os.Getenv("EXPORT_S3_REGION")
+	endpoint := os.Getenv("EXPORT_S3_ENDPOINT")
+	if bucket == "" {
+		if region != "" || endpoint != "" {
+			return nil, ErrS3BucketRequired
+		}
+
+		return nil, nil
+	}
+
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	config := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		parsed, err := url.Parse(endpoint)
+		if err != nil || parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid EXPORT_S3_ENDPOINT %q", endpoint)
+		}
+
+		// S3-compatible servers don't resolve buckets as subdomains
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	awsSession, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Uploader{
+		bucket:   bucket,
+		uploader: s3manager.NewUploader(awsSession),
+	}, nil
+}
+
+// Upload streams body to key and returns the location of the object.
+func (su *S3Uploader) Upload(ctx context.Context, key, contentType string, body io.Reader) (string, error) {
+	output, err := su.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(su.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        body,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return output.Location, nil
+}
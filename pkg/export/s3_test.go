@@ -0,0 +1,107 @@
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/export"
+	auditmodel "github.com/Roll-Play/togglelabs/pkg/models/audit"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestS3UploaderUploadsToMinIO runs against the MinIO of docker-compose, with
+// the export variables of .env.example.
+func TestS3UploaderUploadsToMinIO(t *testing.T) {
+	for _, variable := range []string{"EXPORT_S3_BUCKET", "EXPORT_S3_ENDPOINT", "AWS_ACCESS_KEY_ID"} {
+		if os.Getenv(variable) == "" {
+			t.Skipf("%s isn't set, start MinIO with docker compose and set the export variables", variable)
+		}
+	}
+
+	uploader, err := export.NewS3UploaderFromEnv()
+	assert.NoError(t, err)
+
+	var body bytes.Buffer
+	encoder, err := export.NewEncoder(&body, export.CSV, export.AuditHeader)
+	assert.NoError(t, err)
+	record := auditmodel.NewAuditRecord(primitive.NewObjectID(), nil, "POST /features", nil, http.StatusCreated, "", "", "")
+	assert.NoError(t, encoder.Encode(record, export.AuditRow(record)))
+	assert.NoError(t, encoder.Flush())
+	content := body.String()
+
+	key := "tests/" + primitive.NewObjectID().Hex() + "." + export.CSV.Extension()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	location, err := uploader.Upload(ctx, key, export.CSV.ContentType(), strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(location, "/"+os.Getenv("EXPORT_S3_BUCKET")+"/"+key), location)
+
+	region := os.Getenv("EXPORT_S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	awsSession, err := session.NewSession(aws.NewConfig().
+		WithRegion(region).
+		WithEndpoint(os.Getenv("EXPORT_S3_ENDPOINT")).
+		WithS3ForcePathStyle(true))
+	assert.NoError(t, err)
+	client := s3.New(awsSession)
+
+	object, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("EXPORT_S3_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer object.Body.Close()
+
+	uploaded, err := io.ReadAll(object.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(uploaded))
+	assert.Equal(t, export.CSV.ContentType(), aws.StringValue(object.ContentType))
+
+	_, err = client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(os.Getenv("EXPORT_S3_BUCKET")),
+		Key:    aws.String(key),
+	})
+	assert.NoError(t, err)
+}
+
+func TestNewS3UploaderFromEnvDisabled(t *testing.T) {
+	t.Setenv("EXPORT_S3_BUCKET", "")
+	t.Setenv("EXPORT_S3_REGION", "")
+	t.Setenv("EXPORT_S3_ENDPOINT", "")
+
+	uploader, err := export.NewS3UploaderFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, uploader)
+}
+
+func TestNewS3UploaderFromEnvMisconfigured(t *testing.T) {
+	t.Setenv("EXPORT_S3_BUCKET", "")
+	t.Setenv("EXPORT_S3_REGION", "")
+	t.Setenv("EXPORT_S3_ENDPOINT", "http://localhost:9000")
+
+	_, err := export.NewS3UploaderFromEnv()
+	assert.ErrorIs(t, err, export.ErrS3BucketRequired)
+
+	t.Setenv("EXPORT_S3_BUCKET", "togglelabs-exports")
+	for _, endpoint := range []string{"localhost:9000", "ftp://localhost:9000", "http://"} {
+		t.Setenv("EXPORT_S3_ENDPOINT", endpoint)
+
+		_, err = export.NewS3UploaderFromEnv()
+		assert.Error(t, err, endpoint)
+	}
+}
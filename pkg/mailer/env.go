@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+var ErrTransportRequired = errors.New("MAIL_TRANSPORT is required outside of development")
+
+const (
+	defaultFrom      = "Toggle Labs <no-reply@togglelabs.net>"
+	defaultSMTPPort  = "587"
+	defaultSQSRegion = "us-east-1"
+)
+
+// NewTransportFromEnv picks the transport named by MAIL_TRANSPORT:
+//
+//   - "smtp" relays through SMTP_HOST, SMTP_PORT, SMTP_USERNAME and SMTP_PASSWORD
+//   - "queue" publishes to the MAIL_QUEUE_URL SQS queue in MAIL_QUEUE_REGION
+//   - "file" stores .eml files in MAIL_DIR
+//   - "stdout" prints messages, the default during development only
+func NewTransportFromEnv() (Transport, error) {
+	switch transport := os.Getenv("MAIL_TRANSPORT"); transport {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = defaultSMTPPort
+		}
+
+		return NewSMTPTransport(
+			os.Getenv("SMTP_HOST"),
+			port,
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+		), nil
+	case "queue":
+		region := os.Getenv("MAIL_QUEUE_REGION")
+		if region == "" {
+			region = defaultSQSRegion
+		}
+
+		awsSession, err := session.NewSession(aws.NewConfig().WithRegion(region))
+		if err != nil {
+			return nil, err
+		}
+
+		return NewQueueTransport(sqs.New(awsSession), os.Getenv("MAIL_QUEUE_URL")), nil
+	case "file":
+		return NewFileTransport(os.Getenv("MAIL_DIR"))
+	case "":
+		// Emails printed to stdout never reach users, which must not go
+		// unnoticed in production
+		if config.Environment != config.DevEnvironment {
+			return nil, ErrTransportRequired
+		}
+
+		return NewWriterTransport(os.Stdout), nil
+	case "stdout":
+		return NewWriterTransport(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", transport)
+	}
+}
+
+// FromAddressFromEnv is the MAIL_FROM address messages are sent from.
+func FromAddressFromEnv() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+
+	return defaultFrom
+}
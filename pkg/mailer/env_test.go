@@ -0,0 +1,42 @@
+package mailer_test
+
+import (
+	"testing"
+
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+func setEnvironment(t *testing.T, environment string) {
+	previous := config.Environment
+	config.Environment = environment
+	t.Cleanup(func() {
+		config.Environment = previous
+	})
+}
+
+func TestNewTransportFromEnvDefaultsToStdoutInDev(t *testing.T) {
+	setEnvironment(t, config.DevEnvironment)
+	t.Setenv("MAIL_TRANSPORT", "")
+
+	transport, err := mailer.NewTransportFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &mailer.WriterTransport{}, transport)
+}
+
+func TestNewTransportFromEnvRequiredInProduction(t *testing.T) {
+	setEnvironment(t, config.ProductionEnvironment)
+	t.Setenv("MAIL_TRANSPORT", "")
+
+	_, err := mailer.NewTransportFromEnv()
+	assert.ErrorIs(t, err, mailer.ErrTransportRequired)
+}
+
+func TestNewTransportFromEnvUnknown(t *testing.T) {
+	setEnvironment(t, config.ProductionEnvironment)
+	t.Setenv("MAIL_TRANSPORT", "pigeon")
+
+	_, err := mailer.NewTransportFromEnv()
+	assert.Error(t, err)
+}
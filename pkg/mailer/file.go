@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WriterTransport prints messages to w, usually os.Stdout during development.
+type WriterTransport struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func NewWriterTransport(writer io.Writer) *WriterTransport {
+	return &WriterTransport{
+		writer: writer,
+	}
+}
+
+func (wt *WriterTransport) Send(_ context.Context, message *Message) error {
+	if len(message.To) == 0 {
+		return ErrNoRecipients
+	}
+
+	body, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	wt.lock.Lock()
+	defer wt.lock.Unlock()
+
+	_, err = fmt.Fprintf(wt.writer, "%s\r\n\r\n", body)
+	return err
+}
+
+// FileTransport stores every message as an .eml file of dir, which most mail
+// clients can open.
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileTransport{
+		dir: dir,
+	}, nil
+}
+
+func (ft *FileTransport) Send(_ context.Context, message *Message) error {
+	if len(message.To) == 0 {
+		return ErrNoRecipients
+	}
+
+	body, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), primitive.NewObjectID().Hex())
+	return os.WriteFile(filepath.Join(ft.dir, name), body, 0o644)
+}
@@ -0,0 +1,50 @@
+package mailer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTransportSend(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	transport, err := mailer.NewFileTransport(dir)
+	assert.NoError(t, err)
+
+	message := &mailer.Message{
+		From:    "Toggle Labs <no-reply@togglelabs.net>",
+		To:      []string{"jane@togglelabs.com"},
+		Subject: "Welcome",
+		Text:    "Welcome to Toggle Labs",
+	}
+	assert.NoError(t, transport.Send(context.Background(), message))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	body, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+
+	header, parts := parseMessage(t, body)
+	assert.Equal(t, "jane@togglelabs.com", header.Get("To"))
+	assert.Equal(t, []part{
+		{contentType: "text/plain; charset=utf-8", body: message.Text},
+	}, parts)
+}
+
+func TestFileTransportSendWithoutRecipients(t *testing.T) {
+	dir := t.TempDir()
+	transport, err := mailer.NewFileTransport(dir)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, transport.Send(context.Background(), &mailer.Message{Subject: "Welcome"}), mailer.ErrNoRecipients)
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
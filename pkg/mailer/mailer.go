@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var ErrNoRecipients = errors.New("message has no recipients")
+
+// Message is a rendered email with a plain text and an HTML body.
+type Message struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Text    string   `json:"text"`
+	HTML    string   `json:"html"`
+}
+
+// Transport hands messages over to whatever delivers them.
+type Transport interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+// Bytes encodes the message as a multipart/alternative MIME message.
+func (m *Message) Bytes() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	body := multipart.NewWriter(buffer)
+
+	headers := []struct {
+		key   string
+		value string
+	}{
+		{"From", m.From},
+		{"To", strings.Join(m.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", time.Now().UTC().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", body.Boundary())},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(buffer, "%s: %s\r\n", header.key, header.value)
+	}
+	buffer.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+
+		writer, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package mailer_test
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+type part struct {
+	contentType string
+	body        string
+}
+
+// parseMessage decodes body back into its headers and parts.
+func parseMessage(t *testing.T, body []byte) (mail.Header, []part) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(body))
+	assert.NoError(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	var parts []part
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+
+		// The reader decodes quoted-printable parts
+		content, err := io.ReadAll(p)
+		assert.NoError(t, err)
+		parts = append(parts, part{contentType: p.Header.Get("Content-Type"), body: string(content)})
+	}
+
+	return parsed.Header, parts
+}
+
+func TestMessageBytes(t *testing.T) {
+	message := &mailer.Message{
+		From:    "Toggle Labs <no-reply@togglelabs.net>",
+		To:      []string{"jane@togglelabs.com", "john@togglelabs.com"},
+		Subject: "Confirmação de e-mail",
+		Text:    "Olá Jane, confirme seu e-mail em https://togglelabs.net/verify?token=abc",
+		HTML:    `<p style="color: #333">Olá Jane, <a href="https://togglelabs.net/verify?token=abc">confirme</a></p>`,
+	}
+
+	body, err := message.Bytes()
+	assert.NoError(t, err)
+
+	header, parts := parseMessage(t, body)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, message.Subject, subject)
+	assert.Equal(t, message.From, header.Get("From"))
+	assert.Equal(t, "jane@togglelabs.com, john@togglelabs.com", header.Get("To"))
+	assert.Equal(t, "1.0", header.Get("MIME-Version"))
+
+	assert.Equal(t, []part{
+		{contentType: "text/plain; charset=utf-8", body: message.Text},
+		{contentType: "text/html; charset=utf-8", body: message.HTML},
+	}, parts)
+}
+
+func TestMessageBytesSkipsEmptyParts(t *testing.T) {
+	message := &mailer.Message{
+		From:    "Toggle Labs <no-reply@togglelabs.net>",
+		To:      []string{"jane@togglelabs.com"},
+		Subject: "Welcome",
+		Text:    "Welcome to Toggle Labs",
+	}
+
+	body, err := message.Bytes()
+	assert.NoError(t, err)
+
+	_, parts := parseMessage(t, body)
+	assert.Equal(t, []part{
+		{contentType: "text/plain; charset=utf-8", body: message.Text},
+	}, parts)
+}
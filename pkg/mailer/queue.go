@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// QueueTransport publishes messages as JSON to an SQS queue, leaving the
+// delivery to the email worker of the infrastructure stack.
+type QueueTransport struct {
+	client   sqsiface.SQSAPI
+	queueURL string
+}
+
+func NewQueueTransport(client sqsiface.SQSAPI, queueURL string) *QueueTransport {
+	return &QueueTransport{
+		client:   client,
+		queueURL: queueURL,
+	}
+}
+
+func (qt *QueueTransport) Send(ctx context.Context, message *Message) error {
+	if len(message.To) == 0 {
+		return ErrNoRecipients
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	_, err = qt.client.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(qt.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	return err
+}
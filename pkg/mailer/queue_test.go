@@ -0,0 +1,72 @@
+package mailer_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Roll-Play/togglelabs/pkg/mailer"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// sqsClient records sent messages, other SQS calls are not implemented.
+type sqsClient struct {
+	sqsiface.SQSAPI
+	err   error
+	input []*sqs.SendMessageInput
+}
+
+func (c *sqsClient) SendMessageWithContext(
+	_ aws.Context,
+	input *sqs.SendMessageInput,
+	_ ...request.Option,
+) (*sqs.SendMessageOutput, error) {
+	c.input = append(c.input, input)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	return &sqs.SendMessageOutput{MessageId: aws.String("1")}, nil
+}
+
+func TestQueueTransportSend(t *testing.T) {
+	client := &sqsClient{}
+	transport := mailer.NewQueueTransport(client, "https://sqs.us-east-1.amazonaws.com/1/mail")
+
+	message := &mailer.Message{
+		From:    "Toggle Labs <no-reply@togglelabs.net>",
+		To:      []string{"jane@togglelabs.com"},
+		Subject: "Welcome",
+		Text:    "Welcome to Toggle Labs",
+		HTML:    "<p>Welcome to Toggle Labs</p>",
+	}
+	assert.NoError(t, transport.Send(context.Background(), message))
+
+	assert.Len(t, client.input, 1)
+	assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/1/mail", aws.StringValue(client.input[0].QueueUrl))
+
+	var queued mailer.Message
+	assert.NoError(t, json.Unmarshal([]byte(aws.StringValue(client.input[0].MessageBody)), &queued))
+	assert.Equal(t, *message, queued)
+}
+
+func TestQueueTransportSendFailure(t *testing.T) {
+	errQueue := errors.New("queue unavailable")
+	transport := mailer.NewQueueTransport(&sqsClient{err: errQueue}, "https://sqs.us-east-1.amazonaws.com/1/mail")
+
+	err := transport.Send(context.Background(), &mailer.Message{To: []string{"jane@togglelabs.com"}})
+	assert.ErrorIs(t, err, errQueue)
+}
+
+func TestQueueTransportSendWithoutRecipients(t *testing.T) {
+	client := &sqsClient{}
+	transport := mailer.NewQueueTransport(client, "https://sqs.us-east-1.amazonaws.com/1/mail")
+
+	assert.ErrorIs(t, transport.Send(context.Background(), &mailer.Message{}), mailer.ErrNoRecipients)
+	assert.Empty(t, client.input)
+}
@@ -0,0 +1,131 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	MaxAttempts      = 5
+	RetryInterval    = 10 * time.Second
+	MaxRetryInterval = 10 * time.Minute
+	sendTimeout      = 30 * time.Second
+	queueSize        = 256
+)
+
+var ErrQueueFull = errors.New("mail queue is full")
+
+type delivery struct {
+	message *Message
+	attempt int
+}
+
+// Sender delivers messages through its transport in the background, retrying
+// failed attempts with an exponential backoff.
+type Sender struct {
+	transport Transport
+	logger    *zap.Logger
+	from      string
+	queue     chan *delivery
+	backoff   func(attempt int) time.Duration
+}
+
+func NewSender(transport Transport, logger *zap.Logger, from string) *Sender {
+	return &Sender{
+		transport: transport,
+		logger:    logger,
+		from:      from,
+		queue:     make(chan *delivery, queueSize),
+		backoff:   Backoff,
+	}
+}
+
+func Backoff(attempt int) time.Duration {
+	backoff := RetryInterval
+	for i := 1; i < attempt && backoff < MaxRetryInterval; i++ {
+		backoff *= 2
+	}
+
+	if backoff > MaxRetryInterval {
+		return MaxRetryInterval
+	}
+
+	return backoff
+}
+
+// Enqueue schedules message for delivery without waiting for it, messages
+// without a sender are sent from the default address.
+func (s *Sender) Enqueue(message *Message) error {
+	if len(message.To) == 0 {
+		return ErrNoRecipients
+	}
+
+	if message.From == "" {
+		message.From = s.from
+	}
+
+	select {
+	case s.queue <- &delivery{message: message}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Send renders template for the recipient to and enqueues it.
+func (s *Sender) Send(template Template, to string, data interface{}) error {
+	message, err := Render(template, to, data)
+	if err != nil {
+		return err
+	}
+
+	return s.Enqueue(message)
+}
+
+// Run delivers queued messages until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-s.queue:
+			s.attempt(ctx, delivery)
+		}
+	}
+}
+
+func (s *Sender) attempt(ctx context.Context, delivery *delivery) {
+	delivery.attempt++
+
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	err := s.transport.Send(sendCtx, delivery.message)
+	cancel()
+	if err == nil {
+		return
+	}
+
+	if delivery.attempt >= MaxAttempts {
+		s.logger.Error("Unable to send email",
+			zap.String("subject", delivery.message.Subject),
+			zap.Int("attempts", delivery.attempt),
+			zap.Error(err),
+		)
+		return
+	}
+
+	s.logger.Warn("Email delivery failed, retrying",
+		zap.String("subject", delivery.message.Subject),
+		zap.Int("attempt", delivery.attempt),
+		zap.Error(err),
+	)
+	// Retries wait outside of the queue so they don't hold up other messages
+	time.AfterFunc(s.backoff(delivery.attempt), func() {
+		select {
+		case s.queue <- delivery:
+		case <-ctx.Done():
+		}
+	})
+}
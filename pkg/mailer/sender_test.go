@@ -0,0 +1,116 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var errUnavailable = errors.New("transport unavailable")
+
+// failingTransport fails the first failures sends, then delivers.
+type failingTransport struct {
+	lock      sync.Mutex
+	failures  int
+	attempts  int
+	delivered []*Message
+}
+
+func (ft *failingTransport) Send(_ context.Context, message *Message) error {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	ft.attempts++
+	if ft.attempts <= ft.failures {
+		return errUnavailable
+	}
+
+	ft.delivered = append(ft.delivered, message)
+	return nil
+}
+
+func (ft *failingTransport) counts() (int, int) {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	return ft.attempts, len(ft.delivered)
+}
+
+func newTestSender(transport Transport) *Sender {
+	sender := NewSender(transport, zap.NewNop(), "Toggle Labs <no-reply@togglelabs.net>")
+	sender.backoff = func(int) time.Duration {
+		return time.Millisecond
+	}
+
+	return sender
+}
+
+func TestSenderRetriesFailedDeliveries(t *testing.T) {
+	transport := &failingTransport{failures: 2}
+	sender := newTestSender(transport)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sender.Run(ctx)
+
+	assert.NoError(t, sender.Enqueue(&Message{To: []string{"jane@togglelabs.com"}, Subject: "Welcome"}))
+
+	assert.Eventually(t, func() bool {
+		_, delivered := transport.counts()
+		return delivered == 1
+	}, time.Second, time.Millisecond*10)
+
+	attempts, _ := transport.counts()
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, "Toggle Labs <no-reply@togglelabs.net>", transport.delivered[0].From)
+}
+
+func TestSenderGivesUpAfterMaxAttempts(t *testing.T) {
+	transport := &failingTransport{failures: MaxAttempts + 1}
+	sender := newTestSender(transport)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sender.Run(ctx)
+
+	assert.NoError(t, sender.Enqueue(&Message{To: []string{"jane@togglelabs.com"}, Subject: "Welcome"}))
+
+	assert.Eventually(t, func() bool {
+		attempts, _ := transport.counts()
+		return attempts == MaxAttempts
+	}, time.Second, time.Millisecond*10)
+	// Leaves time for an extra attempt to show up
+	time.Sleep(time.Millisecond * 50)
+
+	attempts, delivered := transport.counts()
+	assert.Equal(t, MaxAttempts, attempts)
+	assert.Equal(t, 0, delivered)
+}
+
+func TestSenderEnqueueWithoutRecipients(t *testing.T) {
+	sender := newTestSender(&failingTransport{})
+
+	assert.ErrorIs(t, sender.Enqueue(&Message{Subject: "Welcome"}), ErrNoRecipients)
+}
+
+func TestSenderEnqueueFullQueue(t *testing.T) {
+	sender := newTestSender(&failingTransport{})
+
+	for i := 0; i < queueSize; i++ {
+		assert.NoError(t, sender.Enqueue(&Message{To: []string{"jane@togglelabs.com"}}))
+	}
+
+	assert.ErrorIs(t, sender.Enqueue(&Message{To: []string{"jane@togglelabs.com"}}), ErrQueueFull)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, RetryInterval, Backoff(1))
+	assert.Equal(t, RetryInterval*2, Backoff(2))
+	assert.Equal(t, RetryInterval*8, Backoff(4))
+	assert.Equal(t, MaxRetryInterval, Backoff(20))
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+)
+
+// SMTPTransport relays messages through an SMTP server, upgrading the
+// connection with STARTTLS when the server supports it.
+type SMTPTransport struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPTransport authenticates with username and password unless username
+// is empty, like local development servers expect.
+func NewSMTPTransport(host, port, username, password string) *SMTPTransport {
+	transport := &SMTPTransport{
+		addr: net.JoinHostPort(host, port),
+	}
+	if username != "" {
+		transport.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return transport
+}
+
+func (st *SMTPTransport) Send(_ context.Context, message *Message) error {
+	if len(message.To) == 0 {
+		return ErrNoRecipients
+	}
+
+	body, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(st.addr, st.auth, message.From, message.To, body)
+}
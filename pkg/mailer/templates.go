@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Template names an email of the templates directory. Each one has a .txt
+// file defining "<name>.subject" and the plain text body, and a .html file
+// rendering the HTML body with the shared layout.
+type Template = string
+
+const (
+	InviteTemplate        Template = "invite"
+	VerificationTemplate  Template = "verification"
+	PasswordResetTemplate Template = "password_reset"
+	ReviewRequestTemplate Template = "review_request"
+)
+
+type InviteData struct {
+	OrganizationName string
+	InviterName      string
+	Link             string
+}
+
+type VerificationData struct {
+	Name      string
+	Link      string
+	ExpiresIn time.Duration
+}
+
+type PasswordResetData struct {
+	Name      string
+	Link      string
+	ExpiresIn time.Duration
+}
+
+type ReviewRequestData struct {
+	AuthorName string
+	FlagName   string
+	Link       string
+}
+
+type buttonData struct {
+	Link string
+	Text string
+}
+
+var templateFuncs = map[string]interface{}{
+	"duration": formatDuration,
+	"button": func(link, text string) buttonData {
+		return buttonData{Link: link, Text: text}
+	},
+}
+
+var (
+	textTemplates = texttemplate.Must(
+		texttemplate.New("").Funcs(templateFuncs).ParseFS(templateFS, "templates/*.txt"),
+	)
+	htmlTemplates = htmltemplate.Must(
+		htmltemplate.New("").Funcs(templateFuncs).ParseFS(templateFS, "templates/*.html"),
+	)
+)
+
+// Render builds the message of template for the recipient to, leaving the
+// sender to the Sender.
+func Render(template Template, to string, data interface{}) (*Message, error) {
+	subject := new(bytes.Buffer)
+	if err := textTemplates.ExecuteTemplate(subject, template+".subject", data); err != nil {
+		return nil, err
+	}
+
+	text := new(bytes.Buffer)
+	if err := textTemplates.ExecuteTemplate(text, template+".txt", data); err != nil {
+		return nil, err
+	}
+
+	html := new(bytes.Buffer)
+	if err := htmlTemplates.ExecuteTemplate(html, template+".html", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:      []string{to},
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// formatDuration writes durations the way people read them, like "24 hours".
+func formatDuration(duration time.Duration) string {
+	unit, amount := "minute", int(duration.Minutes())
+	if duration >= time.Hour && duration%time.Hour == 0 {
+		unit, amount = "hour", int(duration.Hours())
+	}
+
+	if amount == 1 {
+		return "1 " + unit
+	}
+
+	return fmt.Sprintf("%d %ss", amount, unit)
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"context"
+	"strconv"
+
+	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// featureFlagKeys derives a key from the name of the flags created before
+// flags had keys. Names that derive the same key in an organization get a
+// numbered suffix.
+func featureFlagKeys(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection(featureflagmodel.FeatureFlagCollectionName)
+	cursor, err := collection.Find(ctx, bson.D{{Key: "key", Value: bson.M{"$not": bson.M{"$type": "string"}}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var flag struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Name string             `bson:"name"`
+		}
+		if err := cursor.Decode(&flag); err != nil {
+			return err
+		}
+
+		base := featureflagmodel.KeyFromName(flag.Name)
+		for attempt := 1; ; attempt++ {
+			key := base
+			if attempt > 1 {
+				key = base + "-" + strconv.Itoa(attempt)
+			}
+
+			_, err := collection.UpdateOne(
+				ctx,
+				bson.D{{Key: "_id", Value: flag.ID}},
+				bson.D{{Key: "$set", Value: bson.M{"key": key}}},
+			)
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			break
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"context"
+
+	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// featureFlagSearchNames stores the lowercase name that searches match on the
+// flags created before it was kept.
+func featureFlagSearchNames(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection(featureflagmodel.FeatureFlagCollectionName)
+	cursor, err := collection.Find(ctx, bson.D{{Key: "search_name", Value: bson.M{"$exists": false}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var flag struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Name string             `bson:"name"`
+		}
+		if err := cursor.Decode(&flag); err != nil {
+			return err
+		}
+
+		_, err := collection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: flag.ID}},
+			bson.D{{Key: "$set", Value: bson.M{"search_name": featureflagmodel.SearchName(flag.Name)}}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const MigrationCollectionName = "migration"
+
+// Migration brings documents written by earlier versions to the current
+// schema. Up runs once per database and must be safe to run again if it
+// fails half way.
+type Migration struct {
+	ID string
+	Up func(ctx context.Context, db *mongo.Database) error
+}
+
+// All lists the migrations in the order they run, new ones go at the end.
+var All = []Migration{
+	{ID: "0001_feature_flag_keys", Up: featureFlagKeys},
+	{ID: "0002_feature_flag_search_names", Up: featureFlagSearchNames},
+	{ID: "0003_timeline_events", Up: timelineEvents},
+}
+
+type migrationRecord struct {
+	ID        string    `bson:"_id"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Run applies the migrations that haven't been applied to db yet.
+func Run(ctx context.Context, db *mongo.Database, logger *zap.Logger) error {
+	collection := db.Collection(MigrationCollectionName)
+	for _, migration := range All {
+		count, err := collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: migration.ID}})
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		if err := migration.Up(ctx, db); err != nil {
+			return err
+		}
+
+		if _, err := collection.InsertOne(ctx, migrationRecord{ID: migration.ID, AppliedAt: time.Now().UTC()}); err != nil {
+			return err
+		}
+
+		logger.Info("Migration applied",
+			zap.String("migration", migration.ID),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	"github.com/Roll-Play/togglelabs/pkg/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Actions of the entries timelines kept per flag before events were typed
+var legacyTimelineActions = map[string]timelinemodel.EventType{
+	"FeatureFlag created":  timelinemodel.FeatureFlagCreated,
+	"FeatureFlag renamed":  timelinemodel.FeatureFlagRenamed,
+	"FeatureFlag rollback": timelinemodel.FeatureFlagRollback,
+	"FeatureFlag deleted":  timelinemodel.FeatureFlagDeleted,
+	"Revision created":     timelinemodel.RevisionCreated,
+	"Revision approved":    timelinemodel.RevisionApproved,
+}
+
+type legacyTimeline struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	FeatureFlagID primitive.ObjectID `bson:"feature_flag_id"`
+	Entries       []struct {
+		UserID    primitive.ObjectID `bson:"user_id"`
+		Action    string             `bson:"action"`
+		Timestamp primitive.DateTime `bson:"timestamp"`
+	} `bson:"entries"`
+}
+
+// timelineEvents replaces the document holding every entry of a flag with
+// one typed event per entry, scoped to the organization of the flag.
+// Timelines of flags that no longer exist can't be scoped and are left as
+// they are, no query of the timeline reads them.
+func timelineEvents(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection(timelinemodel.TimelineCollectionName)
+	flags := db.Collection(featureflagmodel.FeatureFlagCollectionName)
+
+	cursor, err := collection.Find(ctx, bson.D{{Key: "entries", Value: bson.M{"$exists": true}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var timeline legacyTimeline
+		if err := cursor.Decode(&timeline); err != nil {
+			return err
+		}
+
+		var flag struct {
+			OrganizationID primitive.ObjectID `bson:"organization_id"`
+		}
+		err := flags.FindOne(ctx, bson.D{{Key: "_id", Value: timeline.FeatureFlagID}}).Decode(&flag)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		events := make([]interface{}, 0, len(timeline.Entries))
+		for _, entry := range timeline.Entries {
+			record := timelinemodel.NewTimelineRecord(
+				flag.OrganizationID,
+				timeline.FeatureFlagID,
+				entry.UserID,
+				legacyEventType(entry.Action),
+			)
+			record.ID = primitive.NewObjectID()
+			record.Environment = legacyEnvironment(entry.Action)
+			record.Timestamp = entry.Timestamp
+			events = append(events, record)
+		}
+
+		err = storage.WithTransaction(ctx, db, func(ctx context.Context) error {
+			if len(events) > 0 {
+				if _, err := collection.InsertMany(ctx, events); err != nil {
+					return err
+				}
+			}
+
+			_, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: timeline.ID}})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func legacyEventType(action string) timelinemodel.EventType {
+	if legacyEnvironment(action) != "" {
+		return timelinemodel.FeatureFlagToggled
+	}
+
+	if eventType, ok := legacyTimelineActions[action]; ok {
+		return eventType
+	}
+
+	return action
+}
+
+// legacyEnvironment returns the environment of "FeatureFlag environment %s
+// toggle" actions, empty for the others.
+func legacyEnvironment(action string) string {
+	environment, found := strings.CutPrefix(action, "FeatureFlag environment ")
+	if !found {
+		return ""
+	}
+
+	environment, found = strings.CutSuffix(environment, " toggle")
+	if !found {
+		return ""
+	}
+
+	return environment
+}
@@ -0,0 +1,479 @@
+package auditmodel
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/storage"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditCollectionName = "audit"
+	HeadCollectionName  = "audit_head"
+)
+
+// Number of attempts to link pending entries when concurrent writers race for
+// the same sequence number.
+const appendAttempts = 5
+
+// Number of pending entries linked in one transaction.
+const linkBatchSize = 100
+
+var (
+	ErrChainContention = errors.New("unable to link audit entries to chain")
+	errAlreadyLinked   = errors.New("audit entry already linked")
+)
+
+// SystemChainID identifies the chain of the entries that don't belong to an
+// organization.
+var SystemChainID = primitive.NilObjectID
+
+// Entries are listed newest first.
+var AuditSort = bson.D{
+	{Key: "sequence", Value: -1},
+	{Key: "_id", Value: -1},
+}
+
+type AuditModel struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+	heads      *mongo.Collection
+}
+
+func New(db *mongo.Database) *AuditModel {
+	return &AuditModel{
+		db:         db,
+		collection: db.Collection(AuditCollectionName),
+		heads:      db.Collection(HeadCollectionName),
+	}
+}
+
+// AuditRecord is an append-only entry of an audit chain. Each entry stores the
+// hash of the previous one, so altering or removing an entry breaks every hash
+// after it. Entries are stored pending, with sequence 0, until they are linked
+// to the chain. Entries that don't belong to an organization are appended to
+// the system chain. Hashes are keyed with a secret kept out of the database,
+// so whoever can write to it can't compute them again.
+type AuditRecord struct {
+	ID             primitive.ObjectID  `json:"_id" bson:"_id"`
+	OrganizationID primitive.ObjectID  `json:"organization_id" bson:"organization_id"`
+	Sequence       int64               `json:"sequence" bson:"sequence"`
+	ActorID        *primitive.ObjectID `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	Action         string              `json:"action" bson:"action"`
+	Params         map[string]string   `json:"params,omitempty" bson:"params,omitempty"`
+	Status         int                 `json:"status" bson:"status"`
+	IP             string              `json:"ip" bson:"ip"`
+	UserAgent      string              `json:"user_agent" bson:"user_agent"`
+	RequestID      string              `json:"request_id" bson:"request_id"`
+	Timestamp      primitive.DateTime  `json:"timestamp" bson:"timestamp"`
+	PreviousHash   string              `json:"previous_hash" bson:"previous_hash"`
+	Hash           string              `json:"hash" bson:"hash"`
+}
+
+func NewAuditRecord(
+	organizationID primitive.ObjectID,
+	actorID *primitive.ObjectID,
+	action string,
+	params map[string]string,
+	status int,
+	ip,
+	userAgent,
+	requestID string,
+) *AuditRecord {
+	return &AuditRecord{
+		OrganizationID: organizationID,
+		ActorID:        actorID,
+		Action:         action,
+		Params:         params,
+		Status:         status,
+		IP:             ip,
+		UserAgent:      userAgent,
+		RequestID:      requestID,
+		Timestamp:      primitive.NewDateTimeFromTime(time.Now().UTC()),
+	}
+}
+
+// ChainHead is the last entry of a chain. It is kept apart from
+// the entries, so removing the last ones is detected.
+type ChainHead struct {
+	OrganizationID primitive.ObjectID `json:"-" bson:"_id"`
+	Sequence       int64              `json:"sequence" bson:"sequence"`
+	Hash           string             `json:"hash" bson:"hash"`
+	UpdatedAt      primitive.DateTime `json:"updated_at" bson:"updated_at"`
+}
+
+// ComputeHash returns the HMAC of every field of the record but Hash itself.
+func (ar *AuditRecord) ComputeHash() (string, error) {
+	content := *ar
+	content.Hash = ""
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, hashKey())
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// hashKey is read from AUDIT_HMAC_KEY, and derived from the key signing
+// sessions when it isn't set.
+func hashKey() []byte {
+	if key := os.Getenv("AUDIT_HMAC_KEY"); key != "" {
+		return []byte(key)
+	}
+
+	return apiutils.SigningKey("audit")
+}
+
+// Append stores record and links it to the end of its chain, along with the
+// entries of the chain still pending before it. When concurrent writers keep
+// taking the sequence number, the record is stored pending and linked by a
+// later append to the chain or by LinkPending, so it is never dropped.
+func (am *AuditModel) Append(ctx context.Context, record *AuditRecord) error {
+	record.ID = primitive.NewObjectID()
+	record.Sequence = 0
+	record.PreviousHash = ""
+	record.Hash = ""
+
+	if _, err := am.collection.InsertOne(ctx, record); err != nil {
+		return err
+	}
+
+	err := am.linkChain(ctx, record.OrganizationID)
+	if errors.Is(err, ErrChainContention) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The record may have been linked by another writer
+	return am.collection.FindOne(ctx, bson.D{{Key: "_id", Value: record.ID}}).Decode(record)
+}
+
+// LinkPending links the entries left pending by contended appends to their
+// chains.
+func (am *AuditModel) LinkPending(ctx context.Context) error {
+	chainIDs, err := am.collection.Distinct(ctx, "organization_id", bson.D{{Key: "sequence", Value: 0}})
+	if err != nil {
+		return err
+	}
+
+	for _, chainID := range chainIDs {
+		objectID, ok := chainID.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+
+		if err := am.linkChain(ctx, objectID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkChain links the pending entries of the chain, batch by batch. It fails
+// with ErrChainContention when concurrent writers keep moving the head.
+func (am *AuditModel) linkChain(ctx context.Context, chainID primitive.ObjectID) error {
+	for attempt := 0; attempt < appendAttempts; {
+		linked, err := am.link(ctx, chainID)
+		if err != nil {
+			// The unique sequence index and the head rejects entries linked
+			// concurrently
+			if !mongo.IsDuplicateKeyError(err) && !errors.Is(err, errAlreadyLinked) {
+				return err
+			}
+			attempt++
+			continue
+		}
+
+		if linked < linkBatchSize {
+			return nil
+		}
+	}
+
+	return ErrChainContention
+}
+
+// link links the oldest pending entries of the chain to its end, moves its
+// head to the last of them and returns how many were linked.
+func (am *AuditModel) link(ctx context.Context, chainID primitive.ObjectID) (int, error) {
+	linked := 0
+	err := storage.WithTransaction(ctx, am.db, func(ctx context.Context) error {
+		linked = 0
+
+		head, err := am.Head(ctx, chainID)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		headSequence, previousHash := int64(0), ""
+		if head != nil {
+			headSequence, previousHash = head.Sequence, head.Hash
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetLimit(linkBatchSize)
+		cursor, err := am.collection.Find(
+			ctx,
+			bson.D{
+				{Key: "organization_id", Value: chainID},
+				{Key: "sequence", Value: 0},
+			},
+			opts,
+		)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+
+		records := make([]AuditRecord, 0)
+		if err := cursor.All(ctx, &records); err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		for i := range records {
+			record := &records[i]
+			record.Sequence = headSequence + int64(i) + 1
+			record.PreviousHash = previousHash
+			record.Hash, err = record.ComputeHash()
+			if err != nil {
+				return err
+			}
+
+			result, err := am.collection.UpdateOne(
+				ctx,
+				bson.D{
+					{Key: "_id", Value: record.ID},
+					{Key: "sequence", Value: 0},
+				},
+				bson.D{{Key: "$set", Value: bson.M{
+					"sequence":      record.Sequence,
+					"previous_hash": record.PreviousHash,
+					"hash":          record.Hash,
+				}}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errAlreadyLinked
+			}
+			previousHash = record.Hash
+		}
+
+		// The head only moves from the entry it was read at, when another
+		// writer moved it first the upsert fails on the _id
+		_, err = am.heads.UpdateOne(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: chainID},
+				{Key: "sequence", Value: headSequence},
+			},
+			bson.D{{Key: "$set", Value: bson.M{
+				"sequence":   headSequence + int64(len(records)),
+				"hash":       previousHash,
+				"updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+			}}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+
+		linked = len(records)
+		return nil
+	})
+
+	return linked, err
+}
+
+// Head returns the head of the chain, mongo.ErrNoDocuments when nothing was
+// appended to it.
+func (am *AuditModel) Head(ctx context.Context, organizationID primitive.ObjectID) (*ChainHead, error) {
+	head := new(ChainHead)
+	err := am.heads.FindOne(ctx, bson.D{{Key: "_id", Value: organizationID}}).Decode(head)
+	if err != nil {
+		return nil, err
+	}
+
+	return head, nil
+}
+
+var EmptyAuditRecordList = []AuditRecord{}
+
+// linkedFilter matches the entries linked to the chain of the organization.
+func linkedFilter(organizationID primitive.ObjectID) bson.D {
+	return bson.D{
+		{Key: "organization_id", Value: organizationID},
+		{Key: "sequence", Value: bson.M{"$gt": 0}},
+	}
+}
+
+// FindMany returns up to limit linked entries of the organization matching
+// filter, sorted by AuditSort.
+func (am *AuditModel) FindMany(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+	limit int,
+) ([]AuditRecord, error) {
+	opts := options.Find()
+	opts.SetLimit(int64(limit))
+	opts.SetSort(AuditSort)
+
+	records := make([]AuditRecord, 0)
+	cursor, err := am.collection.Find(
+		ctx,
+		append(linkedFilter(organizationID), filter...),
+		opts,
+	)
+	if err != nil {
+		return EmptyAuditRecordList, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &records); err != nil {
+		return EmptyAuditRecordList, err
+	}
+
+	return records, nil
+}
+
+// Each calls fn with every linked entry of the organization matching filter,
+// oldest first, without loading the whole chain in memory.
+func (am *AuditModel) Each(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+	fn func(record *AuditRecord) error,
+) error {
+	opts := options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}})
+	cursor, err := am.collection.Find(
+		ctx,
+		append(linkedFilter(organizationID), filter...),
+		opts,
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		record := new(AuditRecord)
+		if err := cursor.Decode(record); err != nil {
+			return err
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+type VerificationResult struct {
+	Valid   bool  `json:"valid"`
+	Entries int64 `json:"entries"`
+	// Sequence of the first entry whose link or hash doesn't match, or that
+	// is missing
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	// Head of the chain, keeping it elsewhere proves later that the chain
+	// still holds every entry up to it
+	Head *ChainHead `json:"head,omitempty"`
+	// Entries stored but not linked to the chain yet
+	Pending int64 `json:"pending"`
+}
+
+// Verify walks the whole chain of the organization, or the system chain with
+// SystemChainID, and checks that sequences are contiguous, every hash matches
+// its entry and the previous one, and the chain ends at its head.
+func (am *AuditModel) Verify(ctx context.Context, organizationID primitive.ObjectID) (*VerificationResult, error) {
+	pending, err := am.collection.CountDocuments(ctx, bson.D{
+		{Key: "organization_id", Value: organizationID},
+		{Key: "sequence", Value: 0},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}})
+	cursor, err := am.collection.Find(
+		ctx,
+		linkedFilter(organizationID),
+		opts,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	result := &VerificationResult{Valid: true, Pending: pending}
+	previousHash := ""
+	for cursor.Next(ctx) {
+		record := new(AuditRecord)
+		if err := cursor.Decode(record); err != nil {
+			return nil, err
+		}
+		result.Entries++
+
+		hash, err := record.ComputeHash()
+		if err != nil {
+			return nil, err
+		}
+
+		if record.Sequence != result.Entries || record.PreviousHash != previousHash || record.Hash != hash {
+			brokenAt := result.Entries
+			result.Valid = false
+			result.BrokenAt = &brokenAt
+			return result, nil
+		}
+		previousHash = record.Hash
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	head, err := am.Head(ctx, organizationID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	result.Head = head
+
+	headSequence, headHash := int64(0), ""
+	if head != nil {
+		headSequence, headHash = head.Sequence, head.Hash
+	}
+
+	if headSequence != result.Entries || headHash != previousHash {
+		// Entries after the head were forged, the ones up to it were removed
+		brokenAt := headSequence + 1
+		if result.Entries < headSequence {
+			brokenAt = result.Entries + 1
+		}
+		result.Valid = false
+		result.BrokenAt = &brokenAt
+	}
+
+	return result, nil
+}
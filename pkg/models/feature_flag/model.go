@@ -3,6 +3,8 @@ package featureflagmodel
 import (
 	"context"
 	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/Roll-Play/togglelabs/pkg/models"
@@ -51,6 +53,8 @@ type Revision struct {
 	LastRevisionID *primitive.ObjectID `json:"last_revision_id,omitempty" bson:"last_revision_id,omitempty"`
 	ChangeSet      string              `json:"change_set,omitempty" bson:"change_set,omitempty"`
 	Rules          []Rule              `json:"rules,omitempty" bson:"rules,omitempty"`
+	// Members other than the author who approved the revision
+	Approvals []primitive.ObjectID `json:"approvals,omitempty" bson:"approvals,omitempty"`
 }
 
 type FlagType = string
@@ -63,25 +67,108 @@ const (
 )
 
 type FeatureFlagRecord struct {
-	ID             primitive.ObjectID         `json:"_id,omitempty" bson:"_id"`
-	OrganizationID primitive.ObjectID         `json:"organization_id" bson:"organization_id"`
-	UserID         primitive.ObjectID         `json:"user_id" bson:"user_id"`
-	Version        int                        `json:"version" bson:"version"`
-	Name           string                     `json:"name" bson:"name"`
-	Type           FlagType                   `json:"type" bson:"type"`
-	Revisions      []Revision                 `json:"revisions" bson:"revisions"`
-	Environments   []FeatureFlagEnvironment   `json:"environments,omitempty" bson:"environments,omitempty"`
-	Project        *organizationmodel.Project `json:"project,omitempty" bson:"project,omitempty"`
-	Tags           []string                   `json:"tags" bson:"tags"`
+	ID             primitive.ObjectID `json:"_id,omitempty" bson:"_id"`
+	OrganizationID primitive.ObjectID `json:"organization_id" bson:"organization_id"`
+	UserID         primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Version        int                `json:"version" bson:"version"`
+	Key            string             `json:"key" bson:"key"`
+	Name           string             `json:"name" bson:"name"`
+	// Lowercase copy of Name that searches match prefixes of with an index
+	SearchName   string                     `json:"-" bson:"search_name"`
+	Type         FlagType                   `json:"type" bson:"type"`
+	Revisions    []Revision                 `json:"revisions" bson:"revisions"`
+	Environments []FeatureFlagEnvironment   `json:"environments,omitempty" bson:"environments,omitempty"`
+	Project      *organizationmodel.Project `json:"project,omitempty" bson:"project,omitempty"`
+	Tags         []string                   `json:"tags" bson:"tags"`
 	models.Timestamps
 }
 
+func (ffr *FeatureFlagRecord) LiveRevision() *Revision {
+	for index, revision := range ffr.Revisions {
+		if revision.Status == Live {
+			return &ffr.Revisions[index]
+		}
+	}
+
+	return nil
+}
+
+// Revision returns the revision with id, nil when there is none.
+func (ffr *FeatureFlagRecord) Revision(id primitive.ObjectID) *Revision {
+	for index, revision := range ffr.Revisions {
+		if revision.ID == id {
+			return &ffr.Revisions[index]
+		}
+	}
+
+	return nil
+}
+
+func (ffr *FeatureFlagRecord) DraftRevisions() []Revision {
+	drafts := make([]Revision, 0)
+	for _, revision := range ffr.Revisions {
+		if revision.Status == Draft {
+			drafts = append(drafts, revision)
+		}
+	}
+
+	return drafts
+}
+
 type FeatureFlagEnvironment struct {
 	Name      string `json:"name" bson:"name"`
 	IsEnabled bool   `json:"is_enabled" bson:"is_enabled"`
 }
 
+const KeyMaxLength = 64
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9]+(?:[-_.][a-z0-9]+)*$`)
+
+// IsValidKey reports whether key can be used as a flag key: lowercase
+// alphanumeric words separated by '-', '_' or '.'. Flags are looked up by ID
+// or key, so keys can't be IDs.
+func IsValidKey(key string) bool {
+	return len(key) <= KeyMaxLength && keyPattern.MatchString(key) && !primitive.IsValidObjectID(key)
+}
+
+// SearchName is the form of name that searches match.
+func SearchName(name string) string {
+	return strings.ToLower(name)
+}
+
+// KeyFromName derives a valid key from the name of a flag, lowercasing it and
+// joining its words with '-'. It leaves room for a numbered suffix.
+func KeyFromName(name string) string {
+	key := make([]byte, 0, len(name))
+	for _, char := range strings.ToLower(name) {
+		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') {
+			key = append(key, byte(char))
+			continue
+		}
+
+		if len(key) > 0 && key[len(key)-1] != '-' {
+			key = append(key, '-')
+		}
+	}
+
+	if len(key) > KeyMaxLength-4 {
+		key = key[:KeyMaxLength-4]
+	}
+
+	result := strings.Trim(string(key), "-")
+	if result == "" {
+		return "flag"
+	}
+
+	if primitive.IsValidObjectID(result) {
+		return "flag-" + result
+	}
+
+	return result
+}
+
 func NewFeatureFlagRecord(
+	key,
 	name,
 	defaultValue string,
 	flagType FlagType,
@@ -100,7 +187,9 @@ func NewFeatureFlagRecord(
 		OrganizationID: organizationID,
 		UserID:         userID,
 		Version:        1,
+		Key:            key,
 		Name:           name,
+		SearchName:     SearchName(name),
 		Type:           flagType,
 		Revisions: []Revision{
 			{
@@ -171,23 +260,60 @@ func (ffm *FeatureFlagModel) InsertOne(ctx context.Context, record *FeatureFlagR
 	return objectID, nil
 }
 
-func (ffm *FeatureFlagModel) FindByID(ctx context.Context, id primitive.ObjectID) (*FeatureFlagRecord, error) {
-	record := new(FeatureFlagRecord)
-	if err := ffm.collection.FindOne(ctx, bson.D{
+// FindByID looks up a flag of the organization that hasn't been deleted. Flags
+// of other organizations are reported as mongo.ErrNoDocuments.
+func (ffm *FeatureFlagModel) FindByID(
+	ctx context.Context,
+	organizationID,
+	id primitive.ObjectID,
+) (*FeatureFlagRecord, error) {
+	return ffm.FindOne(ctx, organizationID, bson.D{
 		{Key: "_id", Value: id},
 		{Key: "deleted_at", Value: bson.M{
 			"$exists": false},
-		}}).Decode(record); err != nil {
-		return nil, err
+		}})
+}
+
+// FindByIdentifier looks up a flag of the organization either by its ObjectID
+// or, when the identifier is not a valid ObjectID, by its key.
+func (ffm *FeatureFlagModel) FindByIdentifier(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	identifier string,
+) (*FeatureFlagRecord, error) {
+	if id, err := primitive.ObjectIDFromHex(identifier); err == nil {
+		return ffm.FindByID(ctx, organizationID, id)
 	}
-	return record, nil
+
+	return ffm.FindByKey(ctx, organizationID, identifier)
+}
+
+func (ffm *FeatureFlagModel) FindByKey(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	key string,
+) (*FeatureFlagRecord, error) {
+	return ffm.FindOne(ctx, organizationID, bson.D{
+		{Key: "key", Value: key},
+		{Key: "deleted_at", Value: bson.M{
+			"$exists": false},
+		}})
 }
 
 var EmptyFeatureRecordList = []FeatureFlagRecord{}
 
+func organizationFilter(organizationID primitive.ObjectID, filter bson.D) bson.D {
+	return append(bson.D{
+		{Key: "organization_id", Value: organizationID},
+		{Key: "deleted_at", Value: bson.M{
+			"$exists": false},
+		}}, filter...)
+}
+
 func (ffm *FeatureFlagModel) FindMany(
 	ctx context.Context,
 	organizationID primitive.ObjectID,
+	filter bson.D,
 	page,
 	limit int,
 	sort bson.D,
@@ -198,11 +324,7 @@ func (ffm *FeatureFlagModel) FindMany(
 	opts.SetSort(sort)
 
 	records := make([]FeatureFlagRecord, 0)
-	cursor, err := ffm.collection.Find(ctx, bson.D{
-		{Key: "organization_id", Value: organizationID},
-		{Key: "deleted_at", Value: bson.M{
-			"$exists": false},
-		}}, opts)
+	cursor, err := ffm.collection.Find(ctx, organizationFilter(organizationID, filter), opts)
 	if err != nil {
 		return EmptyFeatureRecordList, err
 	}
@@ -215,9 +337,20 @@ func (ffm *FeatureFlagModel) FindMany(
 	return records, nil
 }
 
+func (ffm *FeatureFlagModel) CountMany(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+) (int64, error) {
+	return ffm.collection.CountDocuments(ctx, organizationFilter(organizationID, filter))
+}
+
+// UpdateOne updates the flag of the organization with id. It fails with
+// mongo.ErrNoDocuments when the organization has no such flag.
 func (ffm *FeatureFlagModel) UpdateOne(
 	ctx context.Context,
-	filter interface{},
+	organizationID,
+	id primitive.ObjectID,
 	update bson.D,
 ) error {
 	update = append(update, bson.E{
@@ -229,23 +362,86 @@ func (ffm *FeatureFlagModel) UpdateOne(
 			},
 		},
 	})
-	_, err := ffm.collection.UpdateOne(ctx, filter, update)
+	result, err := ffm.collection.UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "organization_id", Value: organizationID},
+	}, update)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
 }
 
-func (ffm *FeatureFlagModel) UpdateMany(ctx context.Context, filter bson.D, update bson.D) error {
-	_, err := ffm.collection.UpdateMany(ctx, filter, update)
+// AddApproval records the approval of the user on the draft revision of the
+// flag with revisionID and returns the flag as updated. Concurrent approvals
+// all count. It fails with mongo.ErrNoDocuments when the flag has no such
+// draft.
+func (ffm *FeatureFlagModel) AddApproval(
+	ctx context.Context,
+	organizationID,
+	id,
+	revisionID,
+	userID primitive.ObjectID,
+) (*FeatureFlagRecord, error) {
+	opts := options.FindOneAndUpdate()
+	opts.SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"r._id": revisionID}},
+	})
+	opts.SetReturnDocument(options.After)
+
+	record := new(FeatureFlagRecord)
+	err := ffm.collection.FindOneAndUpdate(
+		ctx,
+		organizationFilter(organizationID, bson.D{
+			{Key: "_id", Value: id},
+			{Key: "revisions", Value: bson.M{"$elemMatch": bson.M{"_id": revisionID, "status": Draft}}},
+		}),
+		bson.D{
+			{Key: "$addToSet", Value: bson.M{"revisions.$[r].approvals": userID}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+		opts,
+	).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// UpdateMany updates the flags of the organization matching filter.
+func (ffm *FeatureFlagModel) UpdateMany(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+	update bson.D,
+) error {
+	_, err := ffm.collection.UpdateMany(
+		ctx,
+		append(bson.D{{Key: "organization_id", Value: organizationID}}, filter...),
+		update,
+	)
 	return err
 }
 
+// FindOne returns the first flag of the organization matching filter,
+// deleted ones included.
 func (ffm *FeatureFlagModel) FindOne(
 	ctx context.Context,
-	filter interface{},
+	organizationID primitive.ObjectID,
+	filter bson.D,
 ) (*FeatureFlagRecord, error) {
 	record := new(FeatureFlagRecord)
 
-	err := ffm.collection.FindOne(ctx, filter).Decode(record)
+	err := ffm.collection.FindOne(
+		ctx,
+		append(bson.D{{Key: "organization_id", Value: organizationID}}, filter...),
+	).Decode(record)
 	if err != nil {
 		return nil, err
 	}
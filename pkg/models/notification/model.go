@@ -0,0 +1,236 @@
+package notificationmodel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const IntegrationCollectionName = "notification_integration"
+
+type Format = string
+
+const (
+	Slack      Format = "slack"
+	Mattermost Format = "mattermost"
+	Teams      Format = "teams"
+)
+
+type IntegrationModel struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+func New(db *mongo.Database) *IntegrationModel {
+	return &IntegrationModel{
+		db:         db,
+		collection: db.Collection(IntegrationCollectionName),
+	}
+}
+
+// IntegrationRecord posts flag changes to a chat incoming webhook. Empty
+// filters match everything.
+type IntegrationRecord struct {
+	ID             primitive.ObjectID `json:"_id" bson:"_id"`
+	OrganizationID primitive.ObjectID `json:"organization_id" bson:"organization_id"`
+	Name           string             `json:"name" bson:"name"`
+	// Incoming webhook URLs embed their credentials, so they're never returned
+	URL          string               `json:"-" bson:"url"`
+	Format       Format               `json:"format" bson:"format"`
+	Events       []string             `json:"events" bson:"events"`
+	Projects     []primitive.ObjectID `json:"projects" bson:"projects"`
+	Environments []string             `json:"environments" bson:"environments"`
+	Active       bool                 `json:"active" bson:"active"`
+	CreatedBy    primitive.ObjectID   `json:"created_by" bson:"created_by"`
+	models.Timestamps
+}
+
+func NewIntegrationRecord(
+	organizationID primitive.ObjectID,
+	name,
+	url string,
+	format Format,
+	events []string,
+	projects []primitive.ObjectID,
+	environments []string,
+	createdBy primitive.ObjectID,
+) *IntegrationRecord {
+	if events == nil {
+		events = []string{}
+	}
+	if projects == nil {
+		projects = []primitive.ObjectID{}
+	}
+	if environments == nil {
+		environments = []string{}
+	}
+
+	return &IntegrationRecord{
+		OrganizationID: organizationID,
+		Name:           name,
+		URL:            url,
+		Format:         format,
+		Events:         events,
+		Projects:       projects,
+		Environments:   environments,
+		Active:         true,
+		CreatedBy:      createdBy,
+		Timestamps: models.Timestamps{
+			CreatedAt: primitive.NewDateTimeFromTime(time.Now().UTC()),
+			UpdatedAt: primitive.NewDateTimeFromTime(time.Now().UTC()),
+		},
+	}
+}
+
+// Matches reports whether a change of eventType to a flag of project, scoped
+// to environment, passes the filters. Changes that aren't scoped to an
+// environment, like revision approvals, affect every environment.
+func (ir *IntegrationRecord) Matches(eventType string, project *primitive.ObjectID, environment string) bool {
+	if len(ir.Events) > 0 && !containsString(ir.Events, eventType) {
+		return false
+	}
+
+	if len(ir.Projects) > 0 {
+		if project == nil {
+			return false
+		}
+
+		found := false
+		for _, projectID := range ir.Projects {
+			if projectID == *project {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(ir.Environments) > 0 && environment != "" && !containsString(ir.Environments, environment) {
+		return false
+	}
+
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (im *IntegrationModel) InsertOne(ctx context.Context, record *IntegrationRecord) (primitive.ObjectID, error) {
+	record.ID = primitive.NewObjectID()
+	result, err := im.collection.InsertOne(ctx, record)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	objectID, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("unable to assert type of objectID")
+	}
+
+	return objectID, nil
+}
+
+func (im *IntegrationModel) FindByID(
+	ctx context.Context,
+	organizationID,
+	id primitive.ObjectID,
+) (*IntegrationRecord, error) {
+	record := new(IntegrationRecord)
+	err := im.collection.FindOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "organization_id", Value: organizationID},
+	}).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+var EmptyIntegrationRecordList = []IntegrationRecord{}
+
+func (im *IntegrationModel) FindMany(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+) ([]IntegrationRecord, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	records := make([]IntegrationRecord, 0)
+	cursor, err := im.collection.Find(
+		ctx,
+		append(bson.D{{Key: "organization_id", Value: organizationID}}, filter...),
+		opts,
+	)
+	if err != nil {
+		return EmptyIntegrationRecordList, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &records); err != nil {
+		return EmptyIntegrationRecordList, err
+	}
+
+	return records, nil
+}
+
+func (im *IntegrationModel) UpdateOne(
+	ctx context.Context,
+	organizationID,
+	id primitive.ObjectID,
+	values bson.D,
+) error {
+	values = append(values, bson.E{Key: "timestamps.updated_at", Value: primitive.NewDateTimeFromTime(time.Now().UTC())})
+	result, err := im.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "organization_id", Value: organizationID},
+		},
+		bson.D{{Key: "$set", Value: values}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (im *IntegrationModel) DeleteOne(
+	ctx context.Context,
+	organizationID,
+	id primitive.ObjectID,
+) error {
+	result, err := im.collection.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "organization_id", Value: organizationID},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
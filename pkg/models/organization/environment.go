@@ -0,0 +1,197 @@
+package organizationmodel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Policy = string
+
+// Policies of protected environments, named when they block a change
+const (
+	AllowedRolesPolicy     Policy = "allowed_roles"
+	FreezeWindowPolicy     Policy = "freeze_window"
+	ApprovedRevisionPolicy Policy = "approved_revision"
+	MinReviewersPolicy     Policy = "min_reviewers"
+)
+
+// EnvironmentProtection holds the policies changes to a protected environment
+// have to follow.
+type EnvironmentProtection struct {
+	// Flags only change through approved revisions, toggles are rejected
+	RequireApprovedRevision bool `json:"require_approved_revision" bson:"require_approved_revision"`
+	// Members other than the author who have to approve a revision before it
+	// goes live
+	MinReviewers int `json:"min_reviewers" bson:"min_reviewers" validate:"gte=0"`
+	// Only members with one of these levels or roles change the environment,
+	// anyone can when both are empty
+	AllowedLevels []PermissionLevelEnum `json:"allowed_levels,omitempty" bson:"allowed_levels,omitempty" validate:"dive,oneof=ADMIN COLLABORATOR READ_ONLY"`
+	AllowedRoles  []primitive.ObjectID  `json:"allowed_roles,omitempty" bson:"allowed_roles,omitempty"`
+}
+
+// PolicyViolation explains which policy of a protected environment blocks a
+// change.
+type PolicyViolation struct {
+	Environment string `json:"environment"`
+	Policy      Policy `json:"policy"`
+	Message     string `json:"message"`
+}
+
+func (pv *PolicyViolation) Error() string {
+	return pv.Environment + ": " + pv.Message
+}
+
+// Environment returns the environment of the organization with name, nil
+// when there is none.
+func (or *OrganizationRecord) Environment(name string) *Environment {
+	for i := range or.Environments {
+		if or.Environments[i].Name == name {
+			return &or.Environments[i]
+		}
+	}
+
+	return nil
+}
+
+// CheckChange tells which policy, if any, keeps member from changing the
+// environment. Toggles are direct changes, approvals are not. Freeze windows
+// are checked separately, as admins can override them.
+func (e *Environment) CheckChange(member *OrganizationMember, direct bool) *PolicyViolation {
+	protection := e.Protection
+	if protection == nil {
+		return nil
+	}
+
+	if !protection.allows(member) {
+		return &PolicyViolation{
+			Environment: e.Name,
+			Policy:      AllowedRolesPolicy,
+			Message:     "only some levels and roles can change this environment",
+		}
+	}
+
+	if direct && protection.RequireApprovedRevision {
+		return &PolicyViolation{
+			Environment: e.Name,
+			Policy:      ApprovedRevisionPolicy,
+			Message:     "changes go through approved revisions",
+		}
+	}
+
+	return nil
+}
+
+// CheckReviewers tells whether a revision with reviewers approvals can go
+// live in the environment.
+func (e *Environment) CheckReviewers(reviewers int) *PolicyViolation {
+	if e.Protection == nil || reviewers >= e.Protection.MinReviewers {
+		return nil
+	}
+
+	return &PolicyViolation{
+		Environment: e.Name,
+		Policy:      MinReviewersPolicy,
+		Message: fmt.Sprintf(
+			"revisions need %d reviewers other than their author, %d approved",
+			e.Protection.MinReviewers,
+			reviewers,
+		),
+	}
+}
+
+func (ep *EnvironmentProtection) allows(member *OrganizationMember) bool {
+	if len(ep.AllowedLevels) == 0 && len(ep.AllowedRoles) == 0 {
+		return true
+	}
+
+	for _, level := range ep.AllowedLevels {
+		if member.PermissionLevel == level {
+			return true
+		}
+	}
+
+	for _, allowed := range ep.AllowedRoles {
+		for _, roleID := range member.Roles {
+			if roleID == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// PutEnvironment adds the environment to the organization, or replaces the
+// one with the same name.
+func (om *OrganizationModel) PutEnvironment(ctx context.Context, id primitive.ObjectID, environment *Environment) error {
+	updatedAt := primitive.NewDateTimeFromTime(time.Now().UTC())
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "environments.name", Value: environment.Name},
+		},
+		bson.D{{Key: "$set", Value: bson.M{
+			"environments.$":        environment,
+			"timestamps.updated_at": updatedAt,
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	result, err = om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "environments.name", Value: bson.M{"$ne": environment.Name}},
+		},
+		bson.D{
+			{Key: "$push", Value: bson.M{"environments": environment}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": updatedAt}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// RemoveEnvironment deletes the environment of the organization with name. It
+// fails with mongo.ErrNoDocuments when there is none.
+func (om *OrganizationModel) RemoveEnvironment(ctx context.Context, id primitive.ObjectID, name string) error {
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "environments.name", Value: name},
+		},
+		bson.D{
+			{Key: "$pull", Value: bson.M{"environments": bson.M{"name": name}}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
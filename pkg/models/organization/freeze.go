@@ -0,0 +1,167 @@
+package organizationmodel
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+	// Windows are in the timezone of the team, whether or not the host has
+	// the timezone database
+	_ "time/tzdata"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+const minutesPerDay = 24 * 60
+
+// FreezeWindow keeps flags from changing in some environments of the
+// organization, either every week or once. Admins can still change them by
+// giving a reason.
+type FreezeWindow struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	Name         string             `json:"name" bson:"name" validate:"required"`
+	Environments []string           `json:"environments" bson:"environments" validate:"required,min=1"`
+	// Set for windows that happen every week
+	Recurrence *WeeklyRecurrence `json:"recurrence,omitempty" bson:"recurrence,omitempty" validate:"required_without=Start,omitempty"`
+	// Set for windows that happen once
+	Start *time.Time `json:"start,omitempty" bson:"start,omitempty" validate:"required_without=Recurrence"`
+	End   *time.Time `json:"end,omitempty" bson:"end,omitempty" validate:"required_with=Start"`
+}
+
+// WeeklyRecurrence is a period of the week in a timezone, such as friday
+// 16:00 to monday 09:00. Days are lowercase english names, times are HH:MM.
+type WeeklyRecurrence struct {
+	Timezone  string `json:"timezone" bson:"timezone" validate:"required,timezone"`
+	StartDay  string `json:"start_day" bson:"start_day" validate:"required,oneof=sunday monday tuesday wednesday thursday friday saturday"`
+	StartTime string `json:"start_time" bson:"start_time" validate:"required,datetime=15:04"`
+	EndDay    string `json:"end_day" bson:"end_day" validate:"required,oneof=sunday monday tuesday wednesday thursday friday saturday"`
+	EndTime   string `json:"end_time" bson:"end_time" validate:"required,datetime=15:04"`
+}
+
+func NewFreezeWindow(name string, environments []string) *FreezeWindow {
+	return &FreezeWindow{
+		ID:           primitive.NewObjectID(),
+		Name:         name,
+		Environments: environments,
+	}
+}
+
+// IsValid tells whether the window is either recurring over a period of the
+// week or a range that ends after it starts.
+func (fw *FreezeWindow) IsValid() bool {
+	if fw.Recurrence != nil {
+		return fw.Start == nil && fw.End == nil &&
+			weekMinute(fw.Recurrence.StartDay, fw.Recurrence.StartTime) !=
+				weekMinute(fw.Recurrence.EndDay, fw.Recurrence.EndTime)
+	}
+
+	return fw.Start != nil && fw.End != nil && fw.End.After(*fw.Start)
+}
+
+// Freezes tells whether the window keeps environment from changing at now.
+func (fw *FreezeWindow) Freezes(environment string, now time.Time) bool {
+	found := false
+	for _, frozen := range fw.Environments {
+		found = found || frozen == environment
+	}
+
+	if !found {
+		return false
+	}
+
+	if fw.Recurrence != nil {
+		return fw.Recurrence.includes(now)
+	}
+
+	return !now.Before(*fw.Start) && now.Before(*fw.End)
+}
+
+// includes tells whether now is in the period. Periods in a timezone that
+// can't be loaded freeze all the time rather than never.
+func (wr *WeeklyRecurrence) includes(now time.Time) bool {
+	location, err := time.LoadLocation(wr.Timezone)
+	if err != nil {
+		return true
+	}
+
+	now = now.In(location)
+	minute := int(now.Weekday())*minutesPerDay + now.Hour()*60 + now.Minute()
+	start := weekMinute(wr.StartDay, wr.StartTime)
+	end := weekMinute(wr.EndDay, wr.EndTime)
+
+	// Windows such as friday to monday go over the end of the week
+	if end < start {
+		return minute >= start || minute < end
+	}
+
+	return minute >= start && minute < end
+}
+
+// weekMinute returns the minutes from the start of sunday to time of day.
+func weekMinute(day, clock string) int {
+	hours, minutes, _ := strings.Cut(clock, ":")
+	hour, _ := strconv.Atoi(hours)
+	minute, _ := strconv.Atoi(minutes)
+
+	return int(weekdays[day])*minutesPerDay + hour*60 + minute
+}
+
+// ActiveFreeze returns the freeze window that keeps environment from
+// changing at now, nil when there is none.
+func (or *OrganizationRecord) ActiveFreeze(environment string, now time.Time) *FreezeWindow {
+	for i := range or.FreezeWindows {
+		if or.FreezeWindows[i].Freezes(environment, now) {
+			return &or.FreezeWindows[i]
+		}
+	}
+
+	return nil
+}
+
+func (om *OrganizationModel) AddFreezeWindow(ctx context.Context, id primitive.ObjectID, window *FreezeWindow) error {
+	return om.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{
+			{Key: "$push", Value: bson.M{"freeze_windows": window}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+	)
+}
+
+// RemoveFreezeWindow deletes the freeze window of the organization. It fails
+// with mongo.ErrNoDocuments when there is none.
+func (om *OrganizationModel) RemoveFreezeWindow(ctx context.Context, id, windowID primitive.ObjectID) error {
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "freeze_windows._id", Value: windowID},
+		},
+		bson.D{
+			{Key: "$pull", Value: bson.M{"freeze_windows": bson.M{"_id": windowID}}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package organizationmodel
+
+import "net"
+
+// IPAllowlist holds the networks, in CIDR notation, members can reach the
+// organization from. Empty lists allow any network.
+type IPAllowlist struct {
+	// Dashboard and API requests of members. SDK keys get a list of their
+	// own once routes authenticate them.
+	Dashboard []string `json:"dashboard" bson:"dashboard" validate:"dive,cidr"`
+}
+
+// Allows tells whether members can reach the organization from ip.
+func (al *IPAllowlist) Allows(ip net.IP) bool {
+	if al == nil || len(al.Dashboard) == 0 {
+		return true
+	}
+
+	for _, network := range al.Dashboard {
+		_, allowed, err := net.ParseCIDR(network)
+		if err == nil && ip != nil && allowed.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
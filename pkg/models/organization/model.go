@@ -79,6 +79,99 @@ func (om *OrganizationModel) FindByMember(
 		{Key: "members.user._id", Value: memberID}})
 }
 
+// FindBySCIMToken returns the organization provisioned with the token of
+// hash.
+func (om *OrganizationModel) FindBySCIMToken(ctx context.Context, hash string) (*OrganizationRecord, error) {
+	record := new(OrganizationRecord)
+	if err := om.collection.FindOne(ctx, bson.D{{Key: "scim.token_hash", Value: hash}}).Decode(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// AddMember adds member to the organization. It fails with
+// mongo.ErrNoDocuments when the user already is a member.
+func (om *OrganizationModel) AddMember(ctx context.Context, id primitive.ObjectID, member *OrganizationMember) error {
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "members.user._id", Value: bson.M{"$ne": member.User.ID}},
+		},
+		bson.D{
+			{Key: "$push", Value: bson.M{"members": member}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// UpdateMember sets fields of the member userID, keyed by their bson name. It
+// fails with mongo.ErrNoDocuments when the user isn't a member.
+func (om *OrganizationModel) UpdateMember(
+	ctx context.Context,
+	id,
+	userID primitive.ObjectID,
+	fields bson.M,
+) error {
+	update := bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}
+	for field, value := range fields {
+		update["members.$."+field] = value
+	}
+
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "members.user._id", Value: userID},
+		},
+		bson.D{{Key: "$set", Value: update}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// RemoveMember removes the member userID from the organization. It fails with
+// mongo.ErrNoDocuments when the user isn't a member.
+func (om *OrganizationModel) RemoveMember(ctx context.Context, id, userID primitive.ObjectID) error {
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "members.user._id", Value: userID},
+		},
+		bson.D{
+			{Key: "$pull", Value: bson.M{"members": bson.M{"user._id": userID}}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
 func (om *OrganizationModel) UpdateOne(
 	ctx context.Context,
 	filter,
@@ -97,9 +190,16 @@ const (
 	ReadOnly     PermissionLevelEnum = "READ_ONLY"
 )
 
+// PermissionLevels lists every permission level, highest first.
+var PermissionLevels = []PermissionLevelEnum{Admin, Collaborator, ReadOnly}
+
 type OrganizationMember struct {
 	User            usermodel.UserRecord `json:"user" bson:"user"`
 	PermissionLevel PermissionLevelEnum  `json:"permission_level" bson:"permission_level"`
+	// ID of the member at the identity provider that provisioned them
+	ExternalID string `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	// Custom roles of the organization granting more than PermissionLevel
+	Roles []primitive.ObjectID `json:"roles,omitempty" bson:"roles,omitempty"`
 }
 
 type OrganizationInviteStatus = string
@@ -124,12 +224,50 @@ type OrganizationRecord struct {
 	Environments []Environment        `json:"environments,omitempty" bson:"environments,omitempty"`
 	Projects     []Project            `json:"projects" bson:"projects"`
 	Tags         []string             `json:"tags" bson:"tags"`
+	Roles        []Role               `json:"roles,omitempty" bson:"roles,omitempty"`
+	// Flags don't change in the chosen environments during these windows
+	FreezeWindows []FreezeWindow `json:"freeze_windows,omitempty" bson:"freeze_windows,omitempty"`
+	// Members have to enable two-factor authentication to access it
+	RequireTwoFactor bool `json:"require_two_factor" bson:"require_two_factor"`
+	// Members can sign in through the identity provider of the organization
+	OIDC *OIDCProvider `json:"oidc,omitempty" bson:"oidc,omitempty"`
+	// Identity providers can manage members through SCIM
+	SCIM *SCIMProvisioning `json:"scim,omitempty" bson:"scim,omitempty"`
+	// Networks members reach the organization from
+	IPAllowlist *IPAllowlist `json:"ip_allowlist,omitempty" bson:"ip_allowlist,omitempty"`
 	models.Timestamps
 }
 
+// Member returns the membership of the user, nil when they aren't a member.
+func (or *OrganizationRecord) Member(userID primitive.ObjectID) *OrganizationMember {
+	for i := range or.Members {
+		if or.Members[i].User.ID == userID {
+			return &or.Members[i]
+		}
+	}
+
+	return nil
+}
+
+// SCIMProvisioning holds the hash of the bearer token identity providers use
+// to provision the organization.
+type SCIMProvisioning struct {
+	TokenHash string             `json:"-" bson:"token_hash"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+}
+
+type OIDCProvider struct {
+	Issuer       string `json:"issuer" bson:"issuer"`
+	ClientID     string `json:"client_id" bson:"client_id"`
+	ClientSecret string `json:"-" bson:"client_secret"`
+	RedirectURL  string `json:"redirect_url" bson:"redirect_url"`
+}
+
 type Environment struct {
 	Name        string `json:"name" bson:"name"`
 	Description string `json:"description" bson:"description"`
+	// Changes to protected environments follow its policies
+	Protection *EnvironmentProtection `json:"protection,omitempty" bson:"protection,omitempty"`
 }
 
 type Project struct {
@@ -0,0 +1,218 @@
+package organizationmodel
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Permission = string
+
+const (
+	FlagRead    Permission = "flag.read"
+	FlagEdit    Permission = "flag.edit"
+	FlagToggle  Permission = "flag.toggle"
+	FlagApprove Permission = "flag.approve"
+	ProjectEdit Permission = "project.edit"
+	// Reserved for segments and invitations, so roles can already grant them
+	SegmentEdit  Permission = "segment.edit"
+	MemberInvite Permission = "member.invite"
+)
+
+// Permissions lists every permission roles can grant.
+var Permissions = []Permission{
+	FlagRead,
+	FlagEdit,
+	FlagToggle,
+	FlagApprove,
+	ProjectEdit,
+	SegmentEdit,
+	MemberInvite,
+}
+
+// levelPermissions are granted everywhere to the members of each level.
+var levelPermissions = map[PermissionLevelEnum][]Permission{
+	Admin:        Permissions,
+	Collaborator: {FlagRead, FlagEdit, FlagToggle, FlagApprove, ProjectEdit, SegmentEdit},
+	ReadOnly:     {FlagRead},
+}
+
+func IsPermission(permission string) bool {
+	for _, known := range Permissions {
+		if known == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LevelAllows tells whether members of level have permission.
+func LevelAllows(level PermissionLevelEnum, permission Permission) bool {
+	for _, granted := range levelPermissions[level] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Scope is what an action applies to: the project of the feature flag and,
+// for changes limited to one, the environment.
+type Scope struct {
+	ProjectID   primitive.ObjectID
+	Environment string
+}
+
+// Role is a custom set of permissions given to members on top of their
+// permission level.
+type Role struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Grants      []Grant            `json:"grants" bson:"grants"`
+}
+
+// Grant gives a permission, limited to some projects and environments when
+// they are set.
+type Grant struct {
+	Permission   Permission           `json:"permission" bson:"permission" validate:"required"`
+	Projects     []primitive.ObjectID `json:"projects,omitempty" bson:"projects,omitempty"`
+	Environments []string             `json:"environments,omitempty" bson:"environments,omitempty" validate:"dive,required"`
+}
+
+func NewRole(name, description string, grants []Grant) *Role {
+	return &Role{
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		Description: description,
+		Grants:      grants,
+	}
+}
+
+// Allows tells whether the role grants permission within scope. Grants
+// limited to some environments only allow changes to one of them.
+func (r *Role) Allows(permission Permission, scope Scope) bool {
+	for _, grant := range r.Grants {
+		if grant.Permission == permission && grant.covers(scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsAnywhere tells whether the role grants permission in some scope.
+func (r *Role) AllowsAnywhere(permission Permission) bool {
+	for _, grant := range r.Grants {
+		if grant.Permission == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *Grant) covers(scope Scope) bool {
+	if len(g.Projects) > 0 {
+		covered := false
+		for _, projectID := range g.Projects {
+			covered = covered || projectID == scope.ProjectID
+		}
+
+		if !covered {
+			return false
+		}
+	}
+
+	if len(g.Environments) > 0 {
+		for _, environment := range g.Environments {
+			if environment == scope.Environment {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// Role returns the role of the organization with id, nil when there is none.
+func (or *OrganizationRecord) Role(id primitive.ObjectID) *Role {
+	for i := range or.Roles {
+		if or.Roles[i].ID == id {
+			return &or.Roles[i]
+		}
+	}
+
+	return nil
+}
+
+func (om *OrganizationModel) AddRole(ctx context.Context, id primitive.ObjectID, role *Role) error {
+	return om.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{
+			{Key: "$push", Value: bson.M{"roles": role}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+	)
+}
+
+// ReplaceRole replaces the role of the organization with the same ID. It
+// fails with mongo.ErrNoDocuments when there is none.
+func (om *OrganizationModel) ReplaceRole(ctx context.Context, id primitive.ObjectID, role *Role) error {
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "roles._id", Value: role.ID},
+		},
+		bson.D{{Key: "$set", Value: bson.M{
+			"roles.$":               role,
+			"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// RemoveRole deletes the role of the organization and takes it from its
+// members. It fails with mongo.ErrNoDocuments when there is no such role.
+func (om *OrganizationModel) RemoveRole(ctx context.Context, id, roleID primitive.ObjectID) error {
+	result, err := om.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "roles._id", Value: roleID},
+		},
+		bson.D{
+			{Key: "$pull", Value: bson.M{
+				"roles":             bson.M{"_id": roleID},
+				"members.$[].roles": roleID,
+			}},
+			{Key: "$set", Value: bson.M{"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
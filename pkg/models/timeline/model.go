@@ -5,22 +5,48 @@ import (
 	"errors"
 	"time"
 
+	featureflagmodel "github.com/Roll-Play/togglelabs/pkg/models/feature_flag"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const TimelineCollectionName = "timeline"
 
+type EventType = string
+
 const (
-	Created             = "FeatureFlag created"
-	RevisionCreated     = "Revision created"
-	RevisionApproved    = "Revision approved"
-	FeatureFlagRollback = "FeatureFlag rollback"
-	FeatureFlagDeleted  = "FeatureFlag deleted"
-	FeatureFlagToggle   = "FeatureFlag environment %s toggle"
+	FeatureFlagCreated     EventType = "feature_flag.created"
+	FeatureFlagRenamed     EventType = "feature_flag.renamed"
+	FeatureFlagTagsUpdated EventType = "feature_flag.tags_updated"
+	FeatureFlagToggled     EventType = "feature_flag.toggled"
+	FeatureFlagRollback    EventType = "feature_flag.rolled_back"
+	FeatureFlagDeleted     EventType = "feature_flag.deleted"
+	RevisionCreated        EventType = "revision.created"
+	RevisionApproved       EventType = "revision.approved"
+	RevisionReviewed       EventType = "revision.reviewed"
 )
 
+var EventTypes = []EventType{
+	FeatureFlagCreated,
+	FeatureFlagRenamed,
+	FeatureFlagTagsUpdated,
+	FeatureFlagToggled,
+	FeatureFlagRollback,
+	FeatureFlagDeleted,
+	RevisionCreated,
+	RevisionApproved,
+	RevisionReviewed,
+}
+
+// Events are listed newest first, the _id breaks ties between events
+// recorded in the same millisecond.
+var TimelineSort = bson.D{
+	{Key: "timestamp", Value: -1},
+	{Key: "_id", Value: -1},
+}
+
 type TimelineModel struct {
 	db         *mongo.Database
 	collection *mongo.Collection
@@ -33,23 +59,70 @@ func New(db *mongo.Database) *TimelineModel {
 	}
 }
 
-type TimelineEntry struct {
-	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
-	Action    string             `json:"action" bson:"action"`
-	Timestamp primitive.DateTime `json:"timestamp" bson:"timestamp"`
+// Snapshot is the state of a flag right before or after an event.
+type Snapshot struct {
+	Key          string                                    `json:"key" bson:"key"`
+	ProjectID    *primitive.ObjectID                       `json:"project_id,omitempty" bson:"project_id,omitempty"`
+	Name         string                                    `json:"name" bson:"name"`
+	Version      int                                       `json:"version" bson:"version"`
+	LiveRevision *featureflagmodel.Revision                `json:"live_revision,omitempty" bson:"live_revision,omitempty"`
+	Environments []featureflagmodel.FeatureFlagEnvironment `json:"environments" bson:"environments"`
+	Tags         []string                                  `json:"tags" bson:"tags"`
+}
+
+// NewSnapshot copies the current state of record, so later changes to the
+// record are not reflected in the snapshot.
+func NewSnapshot(record *featureflagmodel.FeatureFlagRecord) *Snapshot {
+	snapshot := &Snapshot{
+		Key:          record.Key,
+		Name:         record.Name,
+		Version:      record.Version,
+		Environments: append([]featureflagmodel.FeatureFlagEnvironment{}, record.Environments...),
+		Tags:         append([]string{}, record.Tags...),
+	}
+
+	if record.Project != nil {
+		projectID := record.Project.ID
+		snapshot.ProjectID = &projectID
+	}
+
+	if liveRevision := record.LiveRevision(); liveRevision != nil {
+		revision := *liveRevision
+		revision.Rules = append([]featureflagmodel.Rule{}, liveRevision.Rules...)
+		snapshot.LiveRevision = &revision
+	}
+
+	return snapshot
 }
 
 type TimelineRecord struct {
-	ID            primitive.ObjectID `json:"_id" bson:"_id"`
-	FeatureFlagID primitive.ObjectID `json:"feature_flag_id" bson:"feature_flag_id"`
-	Entries       []TimelineEntry    `json:"entries" bson:"entries"`
+	ID             primitive.ObjectID  `json:"_id" bson:"_id"`
+	OrganizationID primitive.ObjectID  `json:"organization_id" bson:"organization_id"`
+	FeatureFlagID  primitive.ObjectID  `json:"feature_flag_id" bson:"feature_flag_id"`
+	EventType      EventType           `json:"event_type" bson:"event_type"`
+	ActorID        primitive.ObjectID  `json:"actor_id" bson:"actor_id"`
+	Environment    string              `json:"environment,omitempty" bson:"environment,omitempty"`
+	RevisionID     *primitive.ObjectID `json:"revision_id,omitempty" bson:"revision_id,omitempty"`
+	LastRevisionID *primitive.ObjectID `json:"last_revision_id,omitempty" bson:"last_revision_id,omitempty"`
+	Before         *Snapshot           `json:"before,omitempty" bson:"before,omitempty"`
+	After          *Snapshot           `json:"after,omitempty" bson:"after,omitempty"`
+	Timestamp      primitive.DateTime  `json:"timestamp" bson:"timestamp"`
+	// Why an admin changed the flag during a freeze window
+	OverrideReason string `json:"override_reason,omitempty" bson:"override_reason,omitempty"`
 }
 
-func NewTimelineEntry(userID primitive.ObjectID, action string) *TimelineEntry {
-	return &TimelineEntry{
-		UserID:    userID,
-		Action:    action,
-		Timestamp: primitive.NewDateTimeFromTime(time.Now().UTC()),
+func NewTimelineRecord(
+	organizationID,
+	featureFlagID,
+	actorID primitive.ObjectID,
+	eventType EventType,
+) *TimelineRecord {
+	return &TimelineRecord{
+		OrganizationID: organizationID,
+		FeatureFlagID:  featureFlagID,
+		EventType:      eventType,
+		ActorID:        actorID,
+		Timestamp:      primitive.NewDateTimeFromTime(time.Now().UTC()),
 	}
 }
 
@@ -68,27 +141,70 @@ func (tm *TimelineModel) InsertOne(ctx context.Context, record *TimelineRecord)
 	return objectID, nil
 }
 
-func (tm *TimelineModel) UpdateOne(
+var EmptyTimelineRecordList = []TimelineRecord{}
+
+// FindMany returns up to limit events of the organization matching filter,
+// sorted by TimelineSort.
+func (tm *TimelineModel) FindMany(
 	ctx context.Context,
-	featureFlagID primitive.ObjectID,
-	entry *TimelineEntry,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+	limit int,
+) ([]TimelineRecord, error) {
+	opts := options.Find()
+	opts.SetLimit(int64(limit))
+	opts.SetSort(TimelineSort)
+
+	records := make([]TimelineRecord, 0)
+	cursor, err := tm.collection.Find(
+		ctx,
+		append(bson.D{{Key: "organization_id", Value: organizationID}}, filter...),
+		opts,
+	)
+	if err != nil {
+		return EmptyTimelineRecordList, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &records); err != nil {
+		return EmptyTimelineRecordList, err
+	}
+
+	return records, nil
+}
+
+// Each calls fn with every event of the organization matching filter, oldest
+// first, without loading them all in memory.
+func (tm *TimelineModel) Each(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+	fn func(record *TimelineRecord) error,
 ) error {
-	_, err := tm.collection.UpdateOne(
+	opts := options.Find().SetSort(bson.D{
+		{Key: "timestamp", Value: 1},
+		{Key: "_id", Value: 1},
+	})
+	cursor, err := tm.collection.Find(
 		ctx,
-		bson.D{{Key: "feature_flag_id", Value: featureFlagID}},
-		bson.D{{Key: "$push", Value: bson.M{"entries": entry}}},
+		append(bson.D{{Key: "organization_id", Value: organizationID}}, filter...),
+		opts,
 	)
 	if err != nil {
 		return err
 	}
+	defer cursor.Close(ctx)
 
-	return nil
-}
+	for cursor.Next(ctx) {
+		record := new(TimelineRecord)
+		if err := cursor.Decode(record); err != nil {
+			return err
+		}
 
-func (ffm *TimelineModel) FindByID(ctx context.Context, id primitive.ObjectID) (*TimelineRecord, error) {
-	record := new(TimelineRecord)
-	if err := ffm.collection.FindOne(ctx, bson.D{{Key: "feature_flag_id", Value: id}}).Decode(record); err != nil {
-		return nil, err
+		if err := fn(record); err != nil {
+			return err
+		}
 	}
-	return record, nil
+
+	return cursor.Err()
 }
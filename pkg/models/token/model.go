@@ -0,0 +1,200 @@
+package tokenmodel
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const TokenCollectionName = "user_token"
+
+type Purpose = string
+
+const (
+	EmailVerification Purpose = "email_verification"
+	PasswordReset     Purpose = "password_reset"
+	// Proves the password step of a sign-in until the second factor is given
+	TwoFactorChallenge Purpose = "two_factor_challenge"
+)
+
+type TokenModel struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+func New(db *mongo.Database) *TokenModel {
+	return &TokenModel{
+		db:         db,
+		collection: db.Collection(TokenCollectionName),
+	}
+}
+
+// TokenRecord is a single-use token sent to a user by email. Only the hash of
+// the token is stored, so a leaked collection can't be used to take over
+// accounts.
+type TokenRecord struct {
+	ID        primitive.ObjectID  `json:"_id" bson:"_id"`
+	UserID    primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Purpose   Purpose             `json:"purpose" bson:"purpose"`
+	Hash      string              `json:"-" bson:"hash"`
+	ExpiresAt primitive.DateTime  `json:"expires_at" bson:"expires_at"`
+	UsedAt    *primitive.DateTime `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	// Failed attempts at the step the token guards
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	CreatedAt primitive.DateTime `json:"created_at" bson:"created_at"`
+}
+
+// Generate returns a random URL-safe token and the hash to store.
+func Generate() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(bytes)
+	return token, Hash(token), nil
+}
+
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func NewTokenRecord(userID primitive.ObjectID, purpose Purpose, hash string, ttl time.Duration) *TokenRecord {
+	now := time.Now().UTC()
+
+	return &TokenRecord{
+		UserID:    userID,
+		Purpose:   purpose,
+		Hash:      hash,
+		ExpiresAt: primitive.NewDateTimeFromTime(now.Add(ttl)),
+		CreatedAt: primitive.NewDateTimeFromTime(now),
+	}
+}
+
+func (tm *TokenModel) InsertOne(ctx context.Context, record *TokenRecord) (primitive.ObjectID, error) {
+	record.ID = primitive.NewObjectID()
+	result, err := tm.collection.InsertOne(ctx, record)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	objectID, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("unable to assert type of objectID")
+	}
+
+	return objectID, nil
+}
+
+func validTokenFilter(purpose Purpose, token string) bson.D {
+	return bson.D{
+		{Key: "hash", Value: Hash(token)},
+		{Key: "purpose", Value: purpose},
+		{Key: "used_at", Value: bson.M{"$exists": false}},
+		{Key: "expires_at", Value: bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now().UTC())}},
+	}
+}
+
+// FindValid returns the unused and unexpired token of purpose without using
+// it, or mongo.ErrNoDocuments when there is no such token.
+func (tm *TokenModel) FindValid(ctx context.Context, purpose Purpose, token string) (*TokenRecord, error) {
+	record := new(TokenRecord)
+	if err := tm.collection.FindOne(ctx, validTokenFilter(purpose, token)).Decode(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Fail counts a failed attempt at the token and uses it up after
+// maxAttempts.
+func (tm *TokenModel) Fail(ctx context.Context, id primitive.ObjectID, maxAttempts int) error {
+	_, err := tm.collection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"attempts": bson.M{"$add": bson.A{"$attempts", 1}},
+			"used_at": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{bson.M{"$add": bson.A{"$attempts", 1}}, maxAttempts}},
+				primitive.NewDateTimeFromTime(time.Now().UTC()),
+				"$used_at",
+			}},
+		}}}},
+	)
+
+	return err
+}
+
+// Consume marks the unused and unexpired token of purpose as used and returns
+// it, or mongo.ErrNoDocuments when there is no such token.
+func (tm *TokenModel) Consume(ctx context.Context, purpose Purpose, token string) (*TokenRecord, error) {
+	record := new(TokenRecord)
+	err := tm.collection.FindOneAndUpdate(
+		ctx,
+		validTokenFilter(purpose, token),
+		bson.D{{Key: "$set", Value: bson.M{"used_at": primitive.NewDateTimeFromTime(time.Now().UTC())}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Revoke marks every unused token of purpose sent to the user as used.
+func (tm *TokenModel) Revoke(ctx context.Context, userID primitive.ObjectID, purpose Purpose) error {
+	_, err := tm.collection.UpdateMany(
+		ctx,
+		bson.D{
+			{Key: "user_id", Value: userID},
+			{Key: "purpose", Value: purpose},
+			{Key: "used_at", Value: bson.M{"$exists": false}},
+		},
+		bson.D{{Key: "$set", Value: bson.M{"used_at": primitive.NewDateTimeFromTime(time.Now().UTC())}}},
+	)
+
+	return err
+}
+
+// FindLatestSince returns the tokens of purpose sent to the user after since,
+// newest first.
+func (tm *TokenModel) FindLatestSince(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	purpose Purpose,
+	since time.Time,
+) ([]TokenRecord, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	records := make([]TokenRecord, 0)
+	cursor, err := tm.collection.Find(
+		ctx,
+		bson.D{
+			{Key: "user_id", Value: userID},
+			{Key: "purpose", Value: purpose},
+			{Key: "created_at", Value: bson.M{"$gt": primitive.NewDateTimeFromTime(since)}},
+		},
+		opts,
+	)
+	if err != nil {
+		return records, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &records); err != nil {
+		return records, err
+	}
+
+	return records, nil
+}
@@ -38,6 +38,7 @@ type UserWithOrganization struct {
 	Email         string             `json:"email" bson:"email"`
 	FirstName     string             `json:"first_name,omitempty" bson:"first_name,omitempty"`
 	LastName      string             `json:"last_name,omitempty" bson:"last_name,omitempty"`
+	EmailVerified bool               `json:"email_verified" bson:"email_verified"`
 	Organizations []UserOrganization `json:"organizations" bson:"organizations"`
 }
 
@@ -51,11 +52,12 @@ func (um *UserModel) FindUserOrganization(ctx context.Context, id primitive.Obje
 			"as":           "organizations",
 		}}},
 		{{Key: "$project", Value: bson.M{
-			"_id":        1,
-			"email":      1,
-			"oauth_id":   1,
-			"first_name": 1,
-			"last_name":  1,
+			"_id":            1,
+			"email":          1,
+			"oauth_id":       1,
+			"first_name":     1,
+			"last_name":      1,
+			"email_verified": 1,
 			"organizations": bson.M{
 				"_id":  1,
 				"name": 1,
@@ -96,6 +98,18 @@ func (um *UserModel) FindByEmail(ctx context.Context, email string) (*UserRecord
 	return record, nil
 }
 
+func (um *UserModel) FindByIdentity(ctx context.Context, provider, subject string) (*UserRecord, error) {
+	record := new(UserRecord)
+	filter := bson.D{{Key: "identities", Value: bson.M{
+		"$elemMatch": bson.M{"provider": provider, "subject": subject},
+	}}}
+	if err := um.collection.FindOne(ctx, filter).Decode(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
 func (um *UserModel) InsertOne(ctx context.Context, record *UserRecord) (primitive.ObjectID, error) {
 	record.ID = primitive.NewObjectID()
 	result, err := um.collection.InsertOne(ctx, record)
@@ -128,6 +142,190 @@ func (um *UserModel) UpdateOne(
 	return err
 }
 
+// RecordSignInAttempt counts a sign-in attempt of the user at now as failed,
+// until ResetSignInLockout forgets it. Attempts are counted one at a time: it
+// fails with mongo.ErrNoDocuments when the lockout of record is outdated, as
+// another attempt was counted since it was read.
+func (um *UserModel) RecordSignInAttempt(ctx context.Context, record *UserRecord, now time.Time) error {
+	filter := bson.D{{Key: "_id", Value: record.ID}}
+	if record.SignInLockout == nil {
+		filter = append(filter, bson.E{Key: "sign_in_lockout", Value: bson.M{"$exists": false}})
+	} else {
+		filter = append(filter, bson.E{Key: "sign_in_lockout.failures", Value: record.SignInLockout.Failures})
+	}
+
+	result, err := um.collection.UpdateOne(
+		ctx,
+		filter,
+		bson.D{
+			{Key: "$inc", Value: bson.M{"sign_in_lockout.failures": 1}},
+			{Key: "$set", Value: bson.M{"sign_in_lockout.last_failed_at": primitive.NewDateTimeFromTime(now)}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// ResetSignInLockout forgets the failed sign-ins of the user.
+func (um *UserModel) ResetSignInLockout(ctx context.Context, id primitive.ObjectID) error {
+	_, err := um.collection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$unset", Value: bson.M{"sign_in_lockout": ""}}},
+	)
+
+	return err
+}
+
+// UseTwoFactorStep records step as the latest TOTP step of the user. It fails
+// with mongo.ErrNoDocuments when a code of this step was already used.
+func (um *UserModel) UseTwoFactorStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "two_factor.last_step", Value: bson.M{"$lt": step}},
+		},
+		bson.D{{Key: "$set", Value: bson.M{"two_factor.last_step": step}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// UseRecoveryCode removes the recovery code with hash from the user. It fails
+// with mongo.ErrNoDocuments when the user doesn't have it.
+func (um *UserModel) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "two_factor.recovery_codes", Value: hash},
+		},
+		bson.D{{Key: "$pull", Value: bson.M{"two_factor.recovery_codes": hash}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// DisableTwoFactor removes the second factor of the user and revokes every
+// session of the user.
+func (um *UserModel) DisableTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	_, err := um.collection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{
+			{Key: "$unset", Value: bson.M{"two_factor": 1}},
+			{Key: "$set", Value: bson.M{
+				"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+			}},
+			{Key: "$inc", Value: bson.M{"session_version": 1}},
+		},
+	)
+
+	return err
+}
+
+// AddIdentity links identity to the user, who can only have one identity of
+// each provider. It fails with mongo.ErrNoDocuments when the user already has
+// one, and with a duplicate key error when another user linked the identity
+// since callers checked it with FindByIdentity.
+func (um *UserModel) AddIdentity(ctx context.Context, id primitive.ObjectID, identity *Identity) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "identities.provider", Value: bson.M{"$ne": identity.Provider}},
+		},
+		bson.D{
+			{Key: "$push", Value: bson.M{"identities": identity}},
+			{Key: "$set", Value: bson.M{
+				"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+			}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// RemoveIdentity unlinks the identity with identityID from the user. It fails
+// with mongo.ErrNoDocuments when the user doesn't have it.
+func (um *UserModel) RemoveIdentity(ctx context.Context, id, identityID primitive.ObjectID) error {
+	result, err := um.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "identities._id", Value: identityID},
+		},
+		bson.D{
+			{Key: "$pull", Value: bson.M{"identities": bson.M{"_id": identityID}}},
+			{Key: "$set", Value: bson.M{
+				"timestamps.updated_at": primitive.NewDateTimeFromTime(time.Now().UTC()),
+			}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// UpdatePassword stores the hash of password, revokes every session of the
+// user and lifts its sign-in lockout.
+func (um *UserModel) UpdatePassword(ctx context.Context, id primitive.ObjectID, password string) error {
+	encryptedPassword, err := encryptPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = um.collection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "password", Value: encryptedPassword},
+				{Key: "timestamps.updated_at", Value: primitive.NewDateTimeFromTime(time.Now().UTC())},
+			}},
+			{Key: "$unset", Value: bson.M{"sign_in_lockout": ""}},
+			{Key: "$inc", Value: bson.M{"session_version": 1}},
+		},
+	)
+
+	return err
+}
+
 type UserRecord struct {
 	ID        primitive.ObjectID `json:"_id" bson:"_id"`
 	Email     string             `json:"email" bson:"email"`
@@ -135,9 +333,86 @@ type UserRecord struct {
 	Password  string             `json:"password,omitempty" bson:"password,omitempty"`
 	FirstName string             `json:"first_name,omitempty" bson:"first_name,omitempty"`
 	LastName  string             `json:"last_name,omitempty" bson:"last_name,omitempty"`
+	// Set once the user opened the link of a verification email
+	EmailVerified bool `json:"email_verified" bson:"email_verified"`
+	// Sessions carry the version they were created at, bumping it signs the
+	// user out everywhere
+	SessionVersion int        `json:"-" bson:"session_version"`
+	TwoFactor      *TwoFactor `json:"-" bson:"two_factor,omitempty"`
+	// External accounts the user signs in with
+	Identities []Identity `json:"-" bson:"identities,omitempty"`
+	// Failed password sign-ins since the last successful one
+	SignInLockout *SignInLockout `json:"-" bson:"sign_in_lockout,omitempty"`
 	models.Timestamps
 }
 
+// Identity links an account of an OIDC provider to the user. Provider is the
+// issuer URL and Subject the ID of the account there.
+type Identity struct {
+	ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Provider string             `json:"provider" bson:"provider"`
+	Subject  string             `json:"subject" bson:"subject"`
+	Email    string             `json:"email,omitempty" bson:"email,omitempty"`
+	LinkedAt primitive.DateTime `json:"linked_at" bson:"linked_at"`
+}
+
+func NewIdentity(provider, subject, email string) *Identity {
+	return &Identity{
+		ID:       primitive.NewObjectID(),
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+		LinkedAt: primitive.NewDateTimeFromTime(time.Now().UTC()),
+	}
+}
+
+// TwoFactor holds the TOTP second factor of a password user. It is pending
+// until the user proves their app generates valid codes.
+type TwoFactor struct {
+	Secret  string `bson:"secret"`
+	Enabled bool   `bson:"enabled"`
+	// SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `bson:"recovery_codes"`
+	// Latest TOTP step used, so codes can't be replayed
+	LastStep int64 `bson:"last_step"`
+}
+
+// SignInLockout counts the failed sign-ins of a user. Past the threshold, each
+// failure locks the account out twice as long as the previous one.
+type SignInLockout struct {
+	Failures     int                `bson:"failures"`
+	LastFailedAt primitive.DateTime `bson:"last_failed_at"`
+}
+
+const (
+	// Failed sign-ins allowed before the account is locked out
+	SignInLockoutThreshold = 5
+	SignInLockoutBase      = time.Minute
+	SignInLockoutMax       = 24 * time.Hour
+)
+
+// SignInLockedUntil returns when the user can try to sign in again, the zero
+// time when they aren't locked out.
+func (ur *UserRecord) SignInLockedUntil() time.Time {
+	if ur.SignInLockout == nil || ur.SignInLockout.Failures < SignInLockoutThreshold {
+		return time.Time{}
+	}
+
+	lockout := SignInLockoutMax
+	if exponent := ur.SignInLockout.Failures - SignInLockoutThreshold; exponent < 20 {
+		lockout = SignInLockoutBase << exponent
+		if lockout > SignInLockoutMax {
+			lockout = SignInLockoutMax
+		}
+	}
+
+	return ur.SignInLockout.LastFailedAt.Time().Add(lockout)
+}
+
+func (ur *UserRecord) TwoFactorEnabled() bool {
+	return ur.TwoFactor != nil && ur.TwoFactor.Enabled
+}
+
 func NewUserRecord(email, password, firstName, lastName string) (*UserRecord, error) {
 	ep, err := encryptPassword(password)
 	if err != nil {
@@ -0,0 +1,217 @@
+package webhookmodel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeliveryStatus = string
+
+const (
+	Pending   DeliveryStatus = "PENDING"
+	Succeeded DeliveryStatus = "SUCCEEDED"
+	Failed    DeliveryStatus = "FAILED"
+)
+
+// Deliveries are listed newest first.
+var DeliverySort = bson.D{
+	{Key: "created_at", Value: -1},
+	{Key: "_id", Value: -1},
+}
+
+type DeliveryModel struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+func NewDeliveryModel(db *mongo.Database) *DeliveryModel {
+	return &DeliveryModel{
+		db:         db,
+		collection: db.Collection(DeliveryCollectionName),
+	}
+}
+
+type DeliveryAttempt struct {
+	Timestamp  primitive.DateTime `json:"timestamp" bson:"timestamp"`
+	StatusCode int                `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	Duration   int64              `json:"duration_ms" bson:"duration_ms"`
+}
+
+// DeliveryRecord is a single event sent to a webhook. The payload is stored
+// as sent so redeliveries carry the exact same body.
+type DeliveryRecord struct {
+	ID             primitive.ObjectID `json:"_id" bson:"_id"`
+	OrganizationID primitive.ObjectID `json:"organization_id" bson:"organization_id"`
+	WebhookID      primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	EventID        primitive.ObjectID `json:"event_id" bson:"event_id"`
+	EventType      string             `json:"event_type" bson:"event_type"`
+	Payload        string             `json:"payload" bson:"payload"`
+	Status         DeliveryStatus     `json:"status" bson:"status"`
+	Attempts       []DeliveryAttempt  `json:"attempts" bson:"attempts"`
+	// When the next attempt is due, unset once the delivery is settled
+	NextAttemptAt *primitive.DateTime `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	// Delivery this one was redelivered from
+	RedeliveryOf *primitive.ObjectID `json:"redelivery_of,omitempty" bson:"redelivery_of,omitempty"`
+	CreatedAt    primitive.DateTime  `json:"created_at" bson:"created_at"`
+}
+
+// NewDeliveryRecord returns a pending delivery claimed until claimedUntil, so
+// the retry worker leaves it to the caller for its first attempt.
+func NewDeliveryRecord(
+	organizationID,
+	webhookID,
+	eventID primitive.ObjectID,
+	eventType,
+	payload string,
+	claimedUntil time.Time,
+) *DeliveryRecord {
+	nextAttemptAt := primitive.NewDateTimeFromTime(claimedUntil)
+
+	return &DeliveryRecord{
+		OrganizationID: organizationID,
+		WebhookID:      webhookID,
+		EventID:        eventID,
+		EventType:      eventType,
+		Payload:        payload,
+		Status:         Pending,
+		Attempts:       []DeliveryAttempt{},
+		NextAttemptAt:  &nextAttemptAt,
+		CreatedAt:      primitive.NewDateTimeFromTime(time.Now().UTC()),
+	}
+}
+
+func (dm *DeliveryModel) InsertOne(ctx context.Context, record *DeliveryRecord) (primitive.ObjectID, error) {
+	record.ID = primitive.NewObjectID()
+	result, err := dm.collection.InsertOne(ctx, record)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	objectID, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("unable to assert type of objectID")
+	}
+
+	return objectID, nil
+}
+
+func (dm *DeliveryModel) FindByID(
+	ctx context.Context,
+	organizationID,
+	webhookID,
+	id primitive.ObjectID,
+) (*DeliveryRecord, error) {
+	record := new(DeliveryRecord)
+	err := dm.collection.FindOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "organization_id", Value: organizationID},
+		{Key: "webhook_id", Value: webhookID},
+	}).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+var EmptyDeliveryRecordList = []DeliveryRecord{}
+
+// FindMany returns up to limit deliveries of the webhook matching filter,
+// sorted by DeliverySort.
+func (dm *DeliveryModel) FindMany(
+	ctx context.Context,
+	organizationID,
+	webhookID primitive.ObjectID,
+	filter bson.D,
+	limit int,
+) ([]DeliveryRecord, error) {
+	opts := options.Find()
+	opts.SetLimit(int64(limit))
+	opts.SetSort(DeliverySort)
+
+	records := make([]DeliveryRecord, 0)
+	cursor, err := dm.collection.Find(
+		ctx,
+		append(bson.D{
+			{Key: "organization_id", Value: organizationID},
+			{Key: "webhook_id", Value: webhookID},
+		}, filter...),
+		opts,
+	)
+	if err != nil {
+		return EmptyDeliveryRecordList, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &records); err != nil {
+		return EmptyDeliveryRecordList, err
+	}
+
+	return records, nil
+}
+
+// ClaimDue picks a pending delivery whose next attempt is due and pushes its
+// next attempt to claimedUntil, so concurrent workers don't send it twice.
+func (dm *DeliveryModel) ClaimDue(ctx context.Context, now, claimedUntil time.Time) (*DeliveryRecord, error) {
+	record := new(DeliveryRecord)
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+	err := dm.collection.FindOneAndUpdate(
+		ctx,
+		bson.D{
+			{Key: "status", Value: Pending},
+			{Key: "next_attempt_at", Value: bson.M{"$lte": primitive.NewDateTimeFromTime(now)}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "next_attempt_at", Value: primitive.NewDateTimeFromTime(claimedUntil)},
+		}}},
+		opts,
+	).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// RecordAttempt appends attempt to the delivery and moves it to status. The
+// next attempt is unset when nextAttemptAt is nil.
+func (dm *DeliveryModel) RecordAttempt(
+	ctx context.Context,
+	record *DeliveryRecord,
+	attempt DeliveryAttempt,
+	status DeliveryStatus,
+	nextAttemptAt *primitive.DateTime,
+) error {
+	update := bson.D{
+		{Key: "$push", Value: bson.M{"attempts": attempt}},
+	}
+	if nextAttemptAt != nil {
+		update = append(update, bson.E{Key: "$set", Value: bson.D{
+			{Key: "status", Value: status},
+			{Key: "next_attempt_at", Value: *nextAttemptAt},
+		}})
+	} else {
+		update = append(update,
+			bson.E{Key: "$set", Value: bson.D{{Key: "status", Value: status}}},
+			bson.E{Key: "$unset", Value: bson.M{"next_attempt_at": 1}},
+		)
+	}
+
+	if _, err := dm.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: record.ID}}, update); err != nil {
+		return err
+	}
+
+	record.Attempts = append(record.Attempts, attempt)
+	record.Status = status
+	record.NextAttemptAt = nextAttemptAt
+	return nil
+}
@@ -0,0 +1,192 @@
+package webhookmodel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	WebhookCollectionName  = "webhook"
+	DeliveryCollectionName = "webhook_delivery"
+)
+
+type WebhookModel struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+func New(db *mongo.Database) *WebhookModel {
+	return &WebhookModel{
+		db:         db,
+		collection: db.Collection(WebhookCollectionName),
+	}
+}
+
+type WebhookRecord struct {
+	ID             primitive.ObjectID `json:"_id" bson:"_id"`
+	OrganizationID primitive.ObjectID `json:"organization_id" bson:"organization_id"`
+	URL            string             `json:"url" bson:"url"`
+	Description    string             `json:"description" bson:"description"`
+	// Event types delivered to the webhook, every event when empty
+	Events []string `json:"events" bson:"events"`
+	Active bool     `json:"active" bson:"active"`
+	// Only returned when the webhook is created
+	Secret    string             `json:"-" bson:"secret"`
+	CreatedBy primitive.ObjectID `json:"created_by" bson:"created_by"`
+	models.Timestamps
+}
+
+func NewWebhookRecord(
+	organizationID primitive.ObjectID,
+	url,
+	description string,
+	events []string,
+	secret string,
+	createdBy primitive.ObjectID,
+) *WebhookRecord {
+	if events == nil {
+		events = []string{}
+	}
+
+	return &WebhookRecord{
+		OrganizationID: organizationID,
+		URL:            url,
+		Description:    description,
+		Events:         events,
+		Active:         true,
+		Secret:         secret,
+		CreatedBy:      createdBy,
+		Timestamps: models.Timestamps{
+			CreatedAt: primitive.NewDateTimeFromTime(time.Now().UTC()),
+			UpdatedAt: primitive.NewDateTimeFromTime(time.Now().UTC()),
+		},
+	}
+}
+
+func (wm *WebhookModel) InsertOne(ctx context.Context, record *WebhookRecord) (primitive.ObjectID, error) {
+	record.ID = primitive.NewObjectID()
+	result, err := wm.collection.InsertOne(ctx, record)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	objectID, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("unable to assert type of objectID")
+	}
+
+	return objectID, nil
+}
+
+func (wm *WebhookModel) FindByID(
+	ctx context.Context,
+	organizationID,
+	id primitive.ObjectID,
+) (*WebhookRecord, error) {
+	record := new(WebhookRecord)
+	err := wm.collection.FindOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "organization_id", Value: organizationID},
+	}).Decode(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+var EmptyWebhookRecordList = []WebhookRecord{}
+
+func (wm *WebhookModel) FindMany(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	filter bson.D,
+) ([]WebhookRecord, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	records := make([]WebhookRecord, 0)
+	cursor, err := wm.collection.Find(
+		ctx,
+		append(bson.D{{Key: "organization_id", Value: organizationID}}, filter...),
+		opts,
+	)
+	if err != nil {
+		return EmptyWebhookRecordList, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &records); err != nil {
+		return EmptyWebhookRecordList, err
+	}
+
+	return records, nil
+}
+
+// FindSubscribed returns the active webhooks of the organization that
+// receive eventType.
+func (wm *WebhookModel) FindSubscribed(
+	ctx context.Context,
+	organizationID primitive.ObjectID,
+	eventType string,
+) ([]WebhookRecord, error) {
+	return wm.FindMany(ctx, organizationID, bson.D{
+		{Key: "active", Value: true},
+		{Key: "$or", Value: bson.A{
+			bson.M{"events": bson.M{"$size": 0}},
+			bson.M{"events": eventType},
+		}},
+	})
+}
+
+func (wm *WebhookModel) UpdateOne(
+	ctx context.Context,
+	organizationID,
+	id primitive.ObjectID,
+	values bson.D,
+) error {
+	values = append(values, bson.E{Key: "timestamps.updated_at", Value: primitive.NewDateTimeFromTime(time.Now().UTC())})
+	result, err := wm.collection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "organization_id", Value: organizationID},
+		},
+		bson.D{{Key: "$set", Value: values}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (wm *WebhookModel) DeleteOne(
+	ctx context.Context,
+	organizationID,
+	id primitive.ObjectID,
+) error {
+	result, err := wm.collection.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: id},
+		{Key: "organization_id", Value: organizationID},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
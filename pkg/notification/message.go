@@ -0,0 +1,197 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	notificationmodel "github.com/Roll-Play/togglelabs/pkg/models/notification"
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+)
+
+// Message describes a flag change as "<actor> <action> `<flag>`<detail>".
+type Message struct {
+	Actor    string
+	Action   string
+	Flag     string
+	Detail   string
+	Link     string
+	LinkText string
+}
+
+// NewMessage describes record, linking to the revision diff on the dashboard
+// when the change involves a revision and dashboardURL is set. The flag is
+// named from the record snapshot, since it may no longer exist.
+func NewMessage(
+	record *timelinemodel.TimelineRecord,
+	snapshot *timelinemodel.Snapshot,
+	actor,
+	dashboardURL string,
+) *Message {
+	message := &Message{
+		Actor: actor,
+		Flag:  snapshot.Key,
+	}
+
+	switch record.EventType {
+	case timelinemodel.FeatureFlagCreated:
+		message.Action = "created"
+	case timelinemodel.FeatureFlagRenamed:
+		message.Action = "renamed"
+		if record.Before != nil && record.After != nil {
+			message.Detail = fmt.Sprintf(" from %q to %q", record.Before.Name, record.After.Name)
+		}
+	case timelinemodel.FeatureFlagTagsUpdated:
+		message.Action = "updated the tags of"
+		if record.After != nil && len(record.After.Tags) > 0 {
+			message.Detail = " to " + strings.Join(record.After.Tags, ", ")
+		}
+	case timelinemodel.FeatureFlagToggled:
+		message.Action = "toggled"
+		if record.After != nil {
+			for _, environment := range record.After.Environments {
+				if environment.Name == record.Environment {
+					message.Action = "turned off"
+					if environment.IsEnabled {
+						message.Action = "turned on"
+					}
+				}
+			}
+		}
+		message.Detail = fmt.Sprintf(" in `%s`", record.Environment)
+	case timelinemodel.FeatureFlagRollback:
+		message.Action = "rolled back"
+		message.Detail = " to its previous revision"
+	case timelinemodel.FeatureFlagDeleted:
+		message.Action = "deleted"
+	case timelinemodel.RevisionCreated:
+		message.Action = "proposed a revision of"
+	case timelinemodel.RevisionApproved:
+		message.Action = "approved a revision of"
+		message.Detail = ", it is now live"
+	case timelinemodel.RevisionReviewed:
+		message.Action = "approved a revision of"
+		message.Detail = ", it needs more reviewers"
+	default:
+		message.Action = "changed"
+	}
+
+	if dashboardURL != "" {
+		link := strings.TrimRight(dashboardURL, "/") + "/features/" + record.FeatureFlagID.Hex()
+		message.LinkText = "View flag"
+		if record.RevisionID != nil {
+			link += "/revisions/" + record.RevisionID.Hex()
+			if record.LastRevisionID != nil {
+				link += "?compare=" + record.LastRevisionID.Hex()
+			}
+			message.LinkText = "View diff"
+		}
+		message.Link = link
+	}
+
+	return message
+}
+
+// Text is the message without any markup, used as notification fallback.
+func (m *Message) Text() string {
+	return fmt.Sprintf("%s %s %s%s", m.Actor, m.Action, m.Flag, m.Detail)
+}
+
+// markdown renders the message with bold and link markup of the chat.
+func (m *Message) markdown(bold string, link func(text, url string) string) string {
+	text := fmt.Sprintf("%s%s%s %s `%s`%s", bold, m.Actor, bold, m.Action, m.Flag, m.Detail)
+	if m.Link != "" {
+		text += " " + link(m.LinkText, m.Link)
+	}
+
+	return text
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text"`
+}
+
+type slackPayload struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type mattermostPayload struct {
+	Text string `json:"text"`
+}
+
+type teamsTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsPayload struct {
+	Type            string        `json:"@type"`
+	Context         string        `json:"@context"`
+	Summary         string        `json:"summary"`
+	Text            string        `json:"text"`
+	PotentialAction []teamsAction `json:"potentialAction,omitempty"`
+}
+
+// Payload encodes the message in the incoming webhook format of the chat.
+func (m *Message) Payload(format notificationmodel.Format) ([]byte, error) {
+	markdownLink := func(text, url string) string {
+		return fmt.Sprintf("[%s](%s)", text, url)
+	}
+
+	switch format {
+	case notificationmodel.Mattermost:
+		return json.Marshal(mattermostPayload{
+			Text: m.markdown("**", markdownLink),
+		})
+	case notificationmodel.Teams:
+		payload := teamsPayload{
+			Type:    "MessageCard",
+			Context: "https://schema.org/extensions",
+			Summary: m.Text(),
+			Text:    m.markdown("**", markdownLink),
+		}
+		if m.Link != "" {
+			payload.PotentialAction = []teamsAction{{
+				Type:    "OpenUri",
+				Name:    m.LinkText,
+				Targets: []teamsTarget{{OS: "default", URI: m.Link}},
+			}}
+		}
+		return json.Marshal(payload)
+	}
+
+	return json.Marshal(slackPayload{
+		Text: m.Text(),
+		Blocks: []slackBlock{{
+			Type: "section",
+			Text: &slackText{
+				Type: "mrkdwn",
+				Text: m.markdown("*", func(text, url string) string {
+					return fmt.Sprintf("<%s|%s>", url, text)
+				}),
+			},
+		}},
+	})
+}
+
+// NewTestMessage is sent on demand to check an integration is wired up.
+func NewTestMessage(integration *notificationmodel.IntegrationRecord) *Message {
+	return &Message{
+		Actor:  "togglelabs",
+		Action: "sent a test notification to",
+		Flag:   integration.Name,
+	}
+}
@@ -0,0 +1,175 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/events"
+	notificationmodel "github.com/Roll-Play/togglelabs/pkg/models/notification"
+	timelinemodel "github.com/Roll-Play/togglelabs/pkg/models/timeline"
+	usermodel "github.com/Roll-Play/togglelabs/pkg/models/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	maxAttempts    = 3
+	retryInterval  = time.Second
+	requestTimeout = 10 * time.Second
+)
+
+var ErrUnexpectedStatus = errors.New("unexpected response status")
+
+type HTTPClient interface {
+	Do(request *http.Request) (*http.Response, error)
+}
+
+// Notifier posts flag changes to the chat integrations of their organization.
+type Notifier struct {
+	db           *mongo.Database
+	logger       *zap.Logger
+	client       HTTPClient
+	dashboardURL string
+}
+
+func NewNotifier(db *mongo.Database, logger *zap.Logger, client HTTPClient, dashboardURL string) *Notifier {
+	return &Notifier{
+		db:           db,
+		logger:       logger,
+		client:       client,
+		dashboardURL: dashboardURL,
+	}
+}
+
+// Handle notifies flag changes in the background, other events are ignored.
+func (n *Notifier) Handle(event *events.Event) {
+	record, ok := event.Data.(*timelinemodel.TimelineRecord)
+	if !ok {
+		return
+	}
+
+	go n.notify(context.Background(), record)
+}
+
+func (n *Notifier) notify(ctx context.Context, record *timelinemodel.TimelineRecord) {
+	integrationModel := notificationmodel.New(n.db)
+	integrations, err := integrationModel.FindMany(ctx, record.OrganizationID, bson.D{{Key: "active", Value: true}})
+	if err != nil {
+		n.logger.Error("Unable to find notification integrations",
+			zap.Error(err),
+		)
+		return
+	}
+
+	if len(integrations) == 0 {
+		return
+	}
+
+	// Deleted flags are only known through the snapshot taken before deletion
+	snapshot := record.After
+	if snapshot == nil {
+		snapshot = record.Before
+	}
+	if snapshot == nil {
+		n.logger.Error("Unable to notify timeline record without snapshot",
+			zap.String("_id", record.ID.Hex()),
+			zap.String("event_type", record.EventType),
+		)
+		return
+	}
+
+	var message *Message
+	for i := range integrations {
+		integration := &integrations[i]
+		if !integration.Matches(record.EventType, snapshot.ProjectID, record.Environment) {
+			continue
+		}
+
+		// Only built once an integration needs it
+		if message == nil {
+			message = NewMessage(record, snapshot, n.actorName(ctx, record.ActorID), n.dashboardURL)
+		}
+
+		if err := n.post(ctx, integration, message); err != nil {
+			n.logger.Error("Unable to post notification",
+				zap.Error(err),
+				zap.String("integration_id", integration.ID.Hex()),
+			)
+		}
+	}
+}
+
+// Send posts message to integration right away, without retries.
+func (n *Notifier) Send(ctx context.Context, integration *notificationmodel.IntegrationRecord, message *Message) error {
+	payload, err := message.Payload(integration.Format)
+	if err != nil {
+		return err
+	}
+
+	return n.postPayload(ctx, integration.URL, payload)
+}
+
+func (n *Notifier) post(ctx context.Context, integration *notificationmodel.IntegrationRecord, message *Message) error {
+	payload, err := message.Payload(integration.Format)
+	if err != nil {
+		return err
+	}
+
+	backoff := retryInterval
+	for attempt := 1; ; attempt++ {
+		err = n.postPayload(ctx, integration.URL, payload)
+		if err == nil || attempt == maxAttempts {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) postPayload(ctx context.Context, url string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, response.StatusCode)
+	}
+
+	return nil
+}
+
+// actorName prefers the full name of the user, then their email.
+func (n *Notifier) actorName(ctx context.Context, actorID primitive.ObjectID) string {
+	userModel := usermodel.New(n.db)
+	user, err := userModel.FindByID(ctx, actorID)
+	if err != nil {
+		return actorID.Hex()
+	}
+
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		return name
+	}
+
+	return user.Email
+}
@@ -0,0 +1,26 @@
+package oidc
+
+import (
+	"os"
+	"strings"
+)
+
+const GoogleIssuer = "https://accounts.google.com"
+
+// ConfigFromEnv reads the provider of the deployment: OIDC_ISSUER, Google
+// by default, and the CLIENT_ID, CLIENT_SECRET and REDIRECT_URL registered
+// with it. OIDC_SCOPES optionally lists space separated scopes.
+func ConfigFromEnv() Config {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		issuer = GoogleIssuer
+	}
+
+	return Config{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("CLIENT_ID"),
+		ClientSecret: os.Getenv("CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	}
+}
@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Unknown key IDs refetch the key set, at most this often, so rotated keys
+// are picked up without letting forged tokens hammer the provider.
+const keyRefreshInterval = time.Minute
+
+var ErrUnknownKey = errors.New("signing key not found in the key set of the provider")
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type keySet struct {
+	client    *http.Client
+	url       string
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	now       func() time.Time
+}
+
+func newKeySet(client *http.Client, url string) *keySet {
+	return &keySet{
+		client: client,
+		url:    url,
+		now:    time.Now,
+	}
+}
+
+// Key returns the public key named keyID, a set with a single key also
+// serves tokens without a key ID.
+func (ks *keySet) Key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.lookup(keyID); ok {
+		return key, nil
+	}
+
+	if !ks.fetchedAt.IsZero() && ks.now().Sub(ks.fetchedAt) < keyRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+
+	if err := ks.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, ok := ks.lookup(keyID); ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func (ks *keySet) lookup(keyID string) (crypto.PublicKey, bool) {
+	if keyID == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+
+	key, ok := ks.keys[keyID]
+	return key, ok
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := getJSON(ctx, ks.client, ks.url, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Keys of types this package can't verify are skipped
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = ks.now()
+	return nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() {
+			return nil, errors.New("RSA exponent is too large")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		// Fails for points off the curve
+		if _, err := key.ECDH(); err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	DiscoveryPath  = "/.well-known/openid-configuration"
+	requestTimeout = 10 * time.Second
+	// Responses of the provider larger than this are rejected
+	maxResponseSize = 1 << 20
+)
+
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+var (
+	ErrIssuerMismatch = errors.New("issuer of the discovery document doesn't match")
+	ErrNoIDToken      = errors.New("token response has no id_token")
+)
+
+// Config identifies the client registered with an OIDC provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// DefaultScopes when empty
+	Scopes []string
+}
+
+func (c Config) key() string {
+	return strings.Join([]string{c.Issuer, c.ClientID, c.ClientSecret, c.RedirectURL, strings.Join(c.Scopes, " ")}, "\n")
+}
+
+// Discovery holds the fields of the provider metadata this package uses.
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Discover fetches the metadata of issuer, which has to name itself the same
+// way so tokens of another issuer can't be passed off as its own.
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Discovery, error) {
+	issuer = strings.TrimRight(issuer, "/")
+
+	discovery := new(Discovery)
+	if err := getJSON(ctx, client, issuer+DiscoveryPath, discovery); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimRight(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("%w: expected %q, got %q", ErrIssuerMismatch, issuer, discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+
+	return discovery, nil
+}
+
+// Provider signs users in through the authorization code flow of an OIDC
+// provider and verifies the ID tokens it returns.
+type Provider struct {
+	config    Config
+	discovery *Discovery
+	oauth     *oauth2.Config
+	client    *http.Client
+	verifier  *Verifier
+}
+
+// NewProvider discovers the endpoints of the issuer of config.
+func NewProvider(ctx context.Context, client *http.Client, config Config) (*Provider, error) {
+	discovery, err := Discover(ctx, client, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+
+	return &Provider{
+		config:    config,
+		discovery: discovery,
+		oauth: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+		},
+		client:   client,
+		verifier: NewVerifier(discovery.Issuer, config.ClientID, newKeySet(client, discovery.JWKSURI)),
+	}, nil
+}
+
+func (p *Provider) Discovery() *Discovery {
+	return p.discovery
+}
+
+func (p *Provider) AuthCodeURL(state string, options ...oauth2.AuthCodeOption) string {
+	return p.oauth.AuthCodeURL(state, options...)
+}
+
+// Exchange trades an authorization code for tokens and returns the claims of
+// the ID token, verified to carry nonce.
+func (p *Provider) Exchange(
+	ctx context.Context,
+	code,
+	nonce string,
+	options ...oauth2.AuthCodeOption,
+) (*Claims, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.oauth.Exchange(ctx, code, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrNoIDToken
+	}
+
+	return p.verifier.Verify(ctx, rawIDToken, nonce)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, target interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, target)
+}
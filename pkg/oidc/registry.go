@@ -0,0 +1,92 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrInsecureProvider is returned for providers of tenants whose issuer or
+// endpoints aren't served over https.
+var ErrInsecureProvider = errors.New("identity provider endpoints have to use https")
+
+// DiscoveryTTL bounds how long discovered endpoints are trusted before they
+// are fetched again.
+const DiscoveryTTL = time.Hour
+
+type cachedProvider struct {
+	provider     *Provider
+	discoveredAt time.Time
+}
+
+// Registry discovers providers on first use and caches them, so sign-ins
+// don't fetch the discovery document and key set every time.
+type Registry struct {
+	client *http.Client
+	// Providers configured by tenants have to be served over https
+	requireHTTPS bool
+	mu           sync.Mutex
+	providers    map[string]*cachedProvider
+}
+
+func NewRegistry(client *http.Client) *Registry {
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+
+	return &Registry{
+		client:    client,
+		providers: make(map[string]*cachedProvider),
+	}
+}
+
+// NewTenantRegistry is a Registry for providers configured by organizations,
+// which are only reached over https through client. Client is expected to
+// refuse the addresses of the network of the deployment.
+func NewTenantRegistry(client *http.Client) *Registry {
+	registry := NewRegistry(client)
+	registry.requireHTTPS = true
+
+	return registry
+}
+
+func (r *Registry) Provider(ctx context.Context, config Config) (*Provider, error) {
+	if r.requireHTTPS && !isHTTPS(config.Issuer) {
+		return nil, ErrInsecureProvider
+	}
+
+	key := config.key()
+
+	r.mu.Lock()
+	cached, ok := r.providers[key]
+	r.mu.Unlock()
+	if ok && time.Since(cached.discoveredAt) < DiscoveryTTL {
+		return cached.provider, nil
+	}
+
+	provider, err := NewProvider(ctx, r.client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	discovery := provider.Discovery()
+	if r.requireHTTPS && !(isHTTPS(discovery.AuthorizationEndpoint) &&
+		isHTTPS(discovery.TokenEndpoint) &&
+		isHTTPS(discovery.JWKSURI)) {
+		return nil, ErrInsecureProvider
+	}
+
+	r.mu.Lock()
+	r.providers[key] = &cachedProvider{provider: provider, discoveredAt: time.Now()}
+	r.mu.Unlock()
+
+	return provider, nil
+}
+
+func isHTTPS(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != ""
+}
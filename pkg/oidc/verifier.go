@@ -0,0 +1,165 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Leeway tolerates clock drift between us and the provider
+const Leeway = time.Minute
+
+var ErrInvalidToken = errors.New("invalid ID token")
+
+var signingMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+}
+
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type claims Claims
+	raw := struct {
+		*claims
+		EmailVerified flexBool `json:"email_verified"`
+	}{claims: (*claims)(c)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	c.EmailVerified = bool(raw.EmailVerified)
+	return nil
+}
+
+// Valid is checked by Verifier instead, which knows the expected issuer and
+// audience.
+func (c *Claims) Valid() error {
+	return nil
+}
+
+// Verifier checks the signature and the claims of ID tokens an issuer gives
+// to a client.
+type Verifier struct {
+	issuer   string
+	clientID string
+	keys     *keySet
+	now      func() time.Time
+}
+
+func NewVerifier(issuer, clientID string, keys *keySet) *Verifier {
+	return &Verifier{
+		issuer:   issuer,
+		clientID: clientID,
+		keys:     keys,
+		now:      time.Now,
+	}
+}
+
+// Verify checks rawIDToken, which has to carry the nonce of the login it
+// ends.
+func (v *Verifier) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	claims := new(Claims)
+	parser := &jwt.Parser{
+		ValidMethods:         signingMethods,
+		SkipClaimsValidation: true,
+	}
+
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return v.keys.Key(ctx, keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+
+	now := v.now()
+	switch {
+	case claims.Issuer != v.issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidToken, claims.Issuer)
+	case !claims.Audience.contains(v.clientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != "" && claims.AuthorizedBy != v.clientID:
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidToken, claims.AuthorizedBy)
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(Leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case claims.IssuedAt != 0 && now.Add(Leeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// audience is a single string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+
+	*a = many
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, value := range a {
+		if value == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flexBool accepts the "true" strings some providers send for booleans
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var value bool
+	if err := json.Unmarshal(data, &value); err == nil {
+		*b = flexBool(value)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	value, err := strconv.ParseBool(text)
+	if err != nil {
+		return err
+	}
+
+	*b = flexBool(value)
+	return nil
+}
@@ -0,0 +1,221 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testClientID = "client-id"
+	testNonce    = "nonce"
+)
+
+// jwksServer serves the public keys it holds and counts how often they are
+// fetched.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys map[string]*rsa.PrivateKey) *jwksServer {
+	server := &jwksServer{keys: keys}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.fetches++
+
+		set := struct {
+			Keys []jsonWebKey `json:"keys"`
+		}{}
+		for keyID, key := range server.keys {
+			set.Keys = append(set.Keys, jsonWebKey{
+				KeyType: "RSA",
+				KeyID:   keyID,
+				Use:     "sig",
+				N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(set))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *jwksServer) rotate(keys map[string]*rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	return key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, keyID string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	return signed
+}
+
+func validClaims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"sub":   "12345",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testNonce,
+		"email": "test@test.com",
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	key := generateKey(t)
+	server := newJWKSServer(t, map[string]*rsa.PrivateKey{"key": key})
+
+	verifier := NewVerifier(testIssuer, testClientID, newKeySet(server.Client(), server.URL))
+	verifier.now = func() time.Time { return now }
+
+	with := func(changes jwt.MapClaims) jwt.MapClaims {
+		claims := validClaims(now)
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+				continue
+			}
+			claims[name] = value
+		}
+
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		valid bool
+	}{
+		{"valid token", signToken(t, key, "key", validClaims(now)), testNonce, true},
+		{"audience list", signToken(t, key, "key", with(jwt.MapClaims{
+			"aud": []string{"other-client", testClientID},
+			"azp": testClientID,
+		})), testNonce, true},
+		{"expired within the leeway", signToken(t, key, "key", with(jwt.MapClaims{
+			"exp": now.Add(-Leeway / 2).Unix(),
+		})), testNonce, true},
+		{"bad signature", signToken(t, generateKey(t), "key", validClaims(now)), testNonce, false},
+		{"unsigned", func() string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims(now)).
+				SignedString(jwt.UnsafeAllowNoneSignatureType)
+			assert.NoError(t, err)
+			return token
+		}(), testNonce, false},
+		{"wrong issuer", signToken(t, key, "key", with(jwt.MapClaims{
+			"iss": "https://other-issuer.test",
+		})), testNonce, false},
+		{"wrong audience", signToken(t, key, "key", with(jwt.MapClaims{
+			"aud": "other-client",
+		})), testNonce, false},
+		{"authorized to another party", signToken(t, key, "key", with(jwt.MapClaims{
+			"aud": []string{"other-client", testClientID},
+			"azp": "other-client",
+		})), testNonce, false},
+		{"expired", signToken(t, key, "key", with(jwt.MapClaims{
+			"exp": now.Add(-2 * Leeway).Unix(),
+		})), testNonce, false},
+		{"without expiry", signToken(t, key, "key", with(jwt.MapClaims{"exp": nil})), testNonce, false},
+		{"issued in the future", signToken(t, key, "key", with(jwt.MapClaims{
+			"iat": now.Add(2 * Leeway).Unix(),
+		})), testNonce, false},
+		{"without subject", signToken(t, key, "key", with(jwt.MapClaims{"sub": nil})), testNonce, false},
+		{"nonce of another login", signToken(t, key, "key", validClaims(now)), "other-nonce", false},
+		{"without nonce", signToken(t, key, "key", with(jwt.MapClaims{"nonce": nil})), "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), test.token, test.nonce)
+			if !test.valid {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				assert.Nil(t, claims)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "12345", claims.Subject)
+			assert.Equal(t, "test@test.com", claims.Email)
+		})
+	}
+}
+
+func TestVerifyRefetchesUnknownKeys(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	key := generateKey(t)
+	rotatedKey := generateKey(t)
+	server := newJWKSServer(t, map[string]*rsa.PrivateKey{"key": key})
+
+	keys := newKeySet(server.Client(), server.URL)
+	keys.now = func() time.Time { return now }
+	verifier := NewVerifier(testIssuer, testClientID, keys)
+	verifier.now = func() time.Time { return now }
+
+	_, err := verifier.Verify(context.Background(), signToken(t, key, "key", validClaims(now)), testNonce)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, server.fetchCount())
+
+	// Known keys are served from the fetched set
+	_, err = verifier.Verify(context.Background(), signToken(t, key, "key", validClaims(now)), testNonce)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, server.fetchCount())
+
+	server.rotate(map[string]*rsa.PrivateKey{"key": key, "rotated": rotatedKey})
+	rotatedToken := signToken(t, rotatedKey, "rotated", validClaims(now))
+
+	// Unknown keys refetch the set at most once per keyRefreshInterval
+	_, err = verifier.Verify(context.Background(), rotatedToken, testNonce)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 1, server.fetchCount())
+
+	now = now.Add(keyRefreshInterval)
+	claims, err := verifier.Verify(context.Background(), rotatedToken, testNonce)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", claims.Subject)
+	assert.Equal(t, 2, server.fetchCount())
+
+	// Keys missing from the refetched set stay unknown
+	now = now.Add(keyRefreshInterval)
+	_, err = verifier.Verify(context.Background(), signToken(t, generateKey(t), "forged", validClaims(now)), testNonce)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 3, server.fetchCount())
+}
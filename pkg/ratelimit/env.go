@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Default requests per minute of each limit
+const (
+	defaultIPRequests   = 600
+	defaultUserRequests = 300
+	defaultAuthRequests = 10
+)
+
+// Limits are the limits of the API: every request counts against its IP and
+// the requests of signed in users against them. Auth is the stricter limit of
+// sign-in and sign-up routes per IP. A limit per SDK key is left to the
+// authentication of SDK keys, no route accepts them yet.
+type Limits struct {
+	IP   Limit
+	User Limit
+	Auth Limit
+}
+
+// NewStoreFromEnv picks the store named by RATE_LIMIT_STORE:
+//
+//   - "mongo" shares counters between instances through the database
+//   - "memory", the default, keeps them in the process
+func NewStoreFromEnv(db *mongo.Database) (Store, error) {
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "mongo":
+		return NewMongoStore(db), nil
+	case "", "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", store)
+	}
+}
+
+// LimitsFromEnv reads the requests per minute of each limit from
+// RATE_LIMIT_IP, RATE_LIMIT_USER and RATE_LIMIT_AUTH.
+func LimitsFromEnv() Limits {
+	return Limits{
+		IP:   limitFromEnv("ip", "RATE_LIMIT_IP", defaultIPRequests),
+		User: limitFromEnv("user", "RATE_LIMIT_USER", defaultUserRequests),
+		Auth: limitFromEnv("auth", "RATE_LIMIT_AUTH", defaultAuthRequests),
+	}
+}
+
+func limitFromEnv(name, variable string, requests int) Limit {
+	if value, err := strconv.Atoi(os.Getenv(variable)); err == nil && value > 0 {
+		requests = value
+	}
+
+	return Limit{
+		Name:     name,
+		Requests: requests,
+		Window:   time.Minute,
+	}
+}
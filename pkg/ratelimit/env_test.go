@@ -0,0 +1,44 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsFromEnvDefaults(t *testing.T) {
+	limits := ratelimit.LimitsFromEnv()
+
+	assert.Equal(t, ratelimit.Limit{Name: "ip", Requests: 600, Window: time.Minute}, limits.IP)
+	assert.Equal(t, ratelimit.Limit{Name: "user", Requests: 300, Window: time.Minute}, limits.User)
+	assert.Equal(t, ratelimit.Limit{Name: "auth", Requests: 10, Window: time.Minute}, limits.Auth)
+}
+
+func TestLimitsFromEnvOverrides(t *testing.T) {
+	t.Setenv("RATE_LIMIT_IP", "100")
+	t.Setenv("RATE_LIMIT_AUTH", "5")
+	t.Setenv("RATE_LIMIT_USER", "not a number")
+
+	limits := ratelimit.LimitsFromEnv()
+
+	assert.Equal(t, 100, limits.IP.Requests)
+	assert.Equal(t, 5, limits.Auth.Requests)
+	assert.Equal(t, 300, limits.User.Requests)
+}
+
+func TestNewStoreFromEnv(t *testing.T) {
+	store, err := ratelimit.NewStoreFromEnv(nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &ratelimit.MemoryStore{}, store)
+
+	t.Setenv("RATE_LIMIT_STORE", "memory")
+	store, err = ratelimit.NewStoreFromEnv(nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &ratelimit.MemoryStore{}, store)
+
+	t.Setenv("RATE_LIMIT_STORE", "redis")
+	_, err = ratelimit.NewStoreFromEnv(nil)
+	assert.Error(t, err)
+}
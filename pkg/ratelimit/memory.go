@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in the process, each instance limits on its own.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	counters  map[string]*counter
+	lastPrune time.Time
+}
+
+type counter struct {
+	hits  int
+	start time.Time
+	reset time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*counter),
+	}
+}
+
+func (ms *MemoryStore) Hit(_ context.Context, key string, window time.Duration, now time.Time) (int, time.Time, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.prune(now)
+
+	start := windowStart(window, now)
+	current, ok := ms.counters[key]
+	if !ok || !current.start.Equal(start) {
+		current = &counter{start: start, reset: start.Add(window)}
+		ms.counters[key] = current
+	}
+	current.hits++
+
+	return current.hits, current.reset, nil
+}
+
+// prune drops the counters of past windows, at most once a minute.
+func (ms *MemoryStore) prune(now time.Time) {
+	if now.Sub(ms.lastPrune) < time.Minute {
+		return
+	}
+
+	ms.lastPrune = now
+	for key, current := range ms.counters {
+		if !now.Before(current.reset) {
+			delete(ms.counters, key)
+		}
+	}
+}
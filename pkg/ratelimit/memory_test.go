@@ -0,0 +1,49 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreCountsHitsPerWindow(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	now := time.Date(2024, time.March, 1, 10, 0, 30, 0, time.UTC)
+
+	hits, reset, err := store.Hit(context.Background(), "key", time.Minute, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hits)
+	assert.Equal(t, time.Date(2024, time.March, 1, 10, 1, 0, 0, time.UTC), reset)
+
+	hits, reset, err = store.Hit(context.Background(), "key", time.Minute, now.Add(20*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, hits)
+	assert.Equal(t, time.Date(2024, time.March, 1, 10, 1, 0, 0, time.UTC), reset)
+
+	hits, _, err = store.Hit(context.Background(), "other-key", time.Minute, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hits)
+}
+
+func TestMemoryStoreStartsOverInTheNextWindow(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	now := time.Date(2024, time.March, 1, 10, 0, 59, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		_, _, err := store.Hit(context.Background(), "key", time.Minute, now)
+		assert.NoError(t, err)
+	}
+
+	hits, reset, err := store.Hit(context.Background(), "key", time.Minute, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hits)
+	assert.Equal(t, time.Date(2024, time.March, 1, 10, 2, 0, 0, time.UTC), reset)
+
+	// Counters pruned after their window still start over
+	hits, _, err = store.Hit(context.Background(), "key", time.Minute, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hits)
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const RateLimitCollectionName = "rate_limit"
+
+// MongoStore keeps counters in the database, shared by every instance. Past
+// windows are removed by the TTL index on expires_at.
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+type counterRecord struct {
+	Key       string             `bson:"_id"`
+	Hits      int                `bson:"hits"`
+	ExpiresAt primitive.DateTime `bson:"expires_at"`
+}
+
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{
+		collection: db.Collection(RateLimitCollectionName),
+	}
+}
+
+func (ms *MongoStore) Hit(ctx context.Context, key string, window time.Duration, now time.Time) (int, time.Time, error) {
+	start := windowStart(window, now)
+	reset := start.Add(window)
+
+	id := key + ":" + strconv.FormatInt(start.Unix(), 10)
+	record := new(counterRecord)
+	err := ms.hit(ctx, id, reset, record)
+	// Concurrent first hits of a window all try to insert its counter, the
+	// ones that lose find it on their next try
+	if mongo.IsDuplicateKeyError(err) {
+		err = ms.hit(ctx, id, reset, record)
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return record.Hits, reset, nil
+}
+
+func (ms *MongoStore) hit(ctx context.Context, id string, reset time.Time, record *counterRecord) error {
+	return ms.collection.FindOneAndUpdate(
+		ctx,
+		bson.D{{Key: "_id", Value: id}},
+		bson.D{
+			{Key: "$inc", Value: bson.M{"hits": 1}},
+			{Key: "$setOnInsert", Value: bson.M{"expires_at": primitive.NewDateTimeFromTime(reset)}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(record)
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Store counts the hits of keys in fixed windows, so limits hold across the
+// instances that share it.
+type Store interface {
+	// Hit counts a hit of key in the window of the given length that contains
+	// now. It returns the hits of that window so far and when it ends.
+	Hit(ctx context.Context, key string, window time.Duration, now time.Time) (int, time.Time, error)
+}
+
+// Limit allows Requests per Window to each key, Name keeps the counters of
+// different limits apart.
+type Limit struct {
+	Name     string
+	Requests int
+	Window   time.Duration
+}
+
+// Allow counts a hit of key and returns how long it has to wait when it went
+// over the limit, zero when it didn't.
+func (l Limit) Allow(ctx context.Context, store Store, key string, now time.Time) (time.Duration, error) {
+	hits, reset, err := store.Hit(ctx, l.Name+":"+key, l.Window, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if hits <= l.Requests {
+		return 0, nil
+	}
+
+	return reset.Sub(now), nil
+}
+
+// windowStart returns the start of the window of the given length that
+// contains now.
+func windowStart(window time.Duration, now time.Time) time.Time {
+	return now.Truncate(window)
+}
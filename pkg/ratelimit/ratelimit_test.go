@@ -0,0 +1,65 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+type storeFunc func(ctx context.Context, key string, window time.Duration, now time.Time) (int, time.Time, error)
+
+func (sf storeFunc) Hit(ctx context.Context, key string, window time.Duration, now time.Time) (int, time.Time, error) {
+	return sf(ctx, key, window, now)
+}
+
+func TestLimitAllow(t *testing.T) {
+	limit := ratelimit.Limit{Name: "test", Requests: 2, Window: time.Minute}
+	store := ratelimit.NewMemoryStore()
+	now := time.Date(2024, time.March, 1, 10, 0, 15, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		retryAfter, err := limit.Allow(context.Background(), store, "key", now)
+		assert.NoError(t, err)
+		assert.Zero(t, retryAfter)
+	}
+
+	retryAfter, err := limit.Allow(context.Background(), store, "key", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Second, retryAfter)
+
+	// Limits with other names keep their own counters
+	other := ratelimit.Limit{Name: "other", Requests: 2, Window: time.Minute}
+	retryAfter, err = other.Allow(context.Background(), store, "key", now)
+	assert.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
+
+func TestLimitAllowCountsTheNamedKey(t *testing.T) {
+	limit := ratelimit.Limit{Name: "test", Requests: 2, Window: time.Minute}
+	var keys []string
+	store := storeFunc(func(_ context.Context, key string, window time.Duration, now time.Time) (int, time.Time, error) {
+		keys = append(keys, key)
+		assert.Equal(t, time.Minute, window)
+		return 1, now.Add(window), nil
+	})
+
+	_, err := limit.Allow(context.Background(), store, "key", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test:key"}, keys)
+}
+
+func TestLimitAllowReturnsStoreErrors(t *testing.T) {
+	limit := ratelimit.Limit{Name: "test", Requests: 2, Window: time.Minute}
+	failure := errors.New("store is down")
+	store := storeFunc(func(context.Context, string, time.Duration, time.Time) (int, time.Time, error) {
+		return 0, time.Time{}, failure
+	})
+
+	retryAfter, err := limit.Allow(context.Background(), store, "key", time.Now())
+	assert.ErrorIs(t, err, failure)
+	assert.Zero(t, retryAfter)
+}
@@ -0,0 +1,306 @@
+// Package scim implements the parts of SCIM 2.0 (RFC 7643 and RFC 7644)
+// identity providers use to provision users and groups: resources, list
+// responses, errors, equality filters and PATCH operations.
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	MediaType = "application/scim+json"
+
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// Error types of RFC 7644 section 3.12, telling clients why a request failed.
+const (
+	InvalidFilter = "invalidFilter"
+	InvalidSyntax = "invalidSyntax"
+	InvalidPath   = "invalidPath"
+	InvalidValue  = "invalidValue"
+	Uniqueness    = "uniqueness"
+	Mutability    = "mutability"
+)
+
+var (
+	ErrInvalidFilter = errors.New("only filters like `attribute eq \"value\"` are supported")
+	ErrInvalidPath   = errors.New("unsupported patch path")
+	ErrInvalidValue  = errors.New("invalid patch value")
+)
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Reference points to another resource, like the groups of a user or the
+// members of a group.
+type Reference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type User struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id,omitempty"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Name       *Name       `json:"name,omitempty"`
+	Emails     []Email     `json:"emails,omitempty"`
+	Active     *bool       `json:"active,omitempty"`
+	Groups     []Reference `json:"groups,omitempty"`
+	Meta       *Meta       `json:"meta,omitempty"`
+}
+
+// Email returns the primary email of the user, falling back to its user name.
+func (u *User) Email() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+
+	if len(u.Emails) > 0 && u.Emails[0].Value != "" {
+		return u.Emails[0].Value
+	}
+
+	return u.UserName
+}
+
+type Group struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []Reference `json:"members"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+func NewListResponse(resources interface{}, total, startIndex, itemsPerPage int) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: itemsPerPage,
+		Resources:    resources,
+	}
+}
+
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ServiceProviderConfig tells clients which optional features of the
+// protocol are available.
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  supported              `json:"bulk"`
+	Filter                filterSupport          `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+}
+
+func NewServiceProviderConfig(maxResults int) *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas: []string{ServiceProviderConfigSchema},
+		Patch:   supported{Supported: true},
+		Filter:  filterSupport{Supported: true, MaxResults: maxResults},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer token",
+			Description: "Provisioning token of the organization",
+		}},
+	}
+}
+
+// Filter is an equality filter, the only kind identity providers use to look
+// up resources before creating them.
+type Filter struct {
+	Attribute string
+	Value     string
+}
+
+// ParseFilter parses `attribute eq "value"`. Attribute names are case
+// insensitive and returned lowercased.
+func ParseFilter(filter string) (*Filter, error) {
+	parts := strings.SplitN(strings.TrimSpace(filter), " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[1], "eq") {
+		return nil, ErrInvalidFilter
+	}
+
+	value, err := strconv.Unquote(strings.TrimSpace(parts[2]))
+	if err != nil {
+		return nil, ErrInvalidFilter
+	}
+
+	return &Filter{Attribute: strings.ToLower(parts[0]), Value: value}, nil
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Operation returns the lowercased op, which clients send in any case.
+func (o *PatchOperation) Operation() string {
+	return strings.ToLower(o.Op)
+}
+
+// Attributes returns the attributes the operation sets, keyed by lowercased
+// name. Operations either set the attribute of their path or, without one,
+// every attribute of their value.
+func (o *PatchOperation) Attributes() (map[string]interface{}, error) {
+	if o.Path != "" {
+		var value interface{}
+		if len(o.Value) > 0 {
+			if err := json.Unmarshal(o.Value, &value); err != nil {
+				return nil, ErrInvalidValue
+			}
+		}
+
+		return map[string]interface{}{strings.ToLower(o.Path): value}, nil
+	}
+
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(o.Value, &values); err != nil {
+		return nil, ErrInvalidValue
+	}
+
+	attributes := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		attributes[strings.ToLower(name)] = value
+	}
+
+	return attributes, nil
+}
+
+// Members returns the IDs of the members a group operation adds, removes or
+// replaces, given either as its value or, for removals, as a filter of its
+// path like `members[value eq "id"]`.
+func (o *PatchOperation) Members() ([]string, error) {
+	path := strings.ToLower(o.Path)
+	if strings.HasPrefix(path, "members[") && strings.HasSuffix(path, "]") {
+		filter, err := ParseFilter(o.Path[len("members[") : len(o.Path)-1])
+		if err != nil || filter.Attribute != "value" {
+			return nil, ErrInvalidPath
+		}
+
+		return []string{filter.Value}, nil
+	}
+
+	if path != "members" {
+		return nil, ErrInvalidPath
+	}
+
+	// Removing every member has no value
+	if len(o.Value) == 0 {
+		return nil, nil
+	}
+
+	var members []Reference
+	if err := json.Unmarshal(o.Value, &members); err != nil {
+		return nil, ErrInvalidValue
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.Value)
+	}
+
+	return ids, nil
+}
+
+// Bool reads a boolean attribute, which some clients send as a string.
+func Bool(value interface{}) (bool, error) {
+	switch value := value.(type) {
+	case bool:
+		return value, nil
+	case string:
+		parsed, err := strconv.ParseBool(strings.ToLower(value))
+		if err != nil {
+			return false, ErrInvalidValue
+		}
+
+		return parsed, nil
+	}
+
+	return false, ErrInvalidValue
+}
+
+// String reads a string attribute.
+func String(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return "", ErrInvalidValue
+	}
+
+	return text, nil
+}
@@ -51,12 +51,199 @@ func (ms *MongoStorage) Init() error {
 				Options: options.Index().SetUnique(true),
 			},
 		},
+		{
+			// Two users can't link the same identity, users without
+			// identities are left out
+			collection: "user",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "identities.subject", Value: 1},
+					{Key: "identities.provider", Value: 1},
+				},
+				Options: options.Index().
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+			},
+		},
 		{
 			collection: "organization",
 			opts: mongo.IndexModel{
 				Keys: bson.D{{Key: "members.user._id", Value: 1}},
 			},
 		},
+		{
+			collection: "organization",
+			opts: mongo.IndexModel{
+				Keys: bson.D{{Key: "scim.token_hash", Value: 1}},
+				Options: options.Index().
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"scim.token_hash": bson.M{"$type": "string"}}),
+			},
+		},
+		{
+			// Soft deleted flags keep their deletion date in the index so their
+			// key can be reused, while every live flag shares a null deleted_at
+			collection: "feature_flag",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "key", Value: 1},
+					{Key: "deleted_at", Value: 1},
+				},
+				Options: options.Index().
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"key": bson.M{"$type": "string"}}),
+			},
+		},
+		{
+			collection: "feature_flag",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "timestamps.created_at", Value: -1},
+				},
+			},
+		},
+		{
+			collection: "feature_flag",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "name", Value: 1},
+				},
+			},
+		},
+		{
+			collection: "feature_flag",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "search_name", Value: 1},
+				},
+			},
+		},
+		{
+			collection: "timeline",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "timestamp", Value: -1},
+					{Key: "_id", Value: -1},
+				},
+			},
+		},
+		{
+			collection: "timeline",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "feature_flag_id", Value: 1},
+					{Key: "timestamp", Value: -1},
+					{Key: "_id", Value: -1},
+				},
+			},
+		},
+		{
+			// Two entries can't claim the same link of a chain, pending entries
+			// have no link to claim yet
+			collection: "audit",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "sequence", Value: -1},
+				},
+				Options: options.Index().
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"sequence": bson.M{"$gt": 0}}),
+			},
+		},
+		{
+			collection: "audit",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "timestamp", Value: 1},
+				},
+			},
+		},
+		{
+			// Pending entries are linked oldest first
+			collection: "audit",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "_id", Value: 1},
+				},
+				Options: options.Index().
+					SetPartialFilterExpression(bson.M{"sequence": 0}),
+			},
+		},
+		{
+			collection: "webhook",
+			opts: mongo.IndexModel{
+				Keys: bson.D{{Key: "organization_id", Value: 1}},
+			},
+		},
+		{
+			collection: "notification_integration",
+			opts: mongo.IndexModel{
+				Keys: bson.D{{Key: "organization_id", Value: 1}},
+			},
+		},
+		{
+			collection: "user_token",
+			opts: mongo.IndexModel{
+				Keys:    bson.D{{Key: "hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		{
+			collection: "user_token",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "user_id", Value: 1},
+					{Key: "purpose", Value: 1},
+					{Key: "created_at", Value: -1},
+				},
+			},
+		},
+		{
+			// Expired tokens are kept a day so resends stay rate limited
+			collection: "user_token",
+			opts: mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+			},
+		},
+		{
+			// Counters of rate limits go away once their window ends
+			collection: "rate_limit",
+			opts: mongo.IndexModel{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		{
+			collection: "webhook_delivery",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "organization_id", Value: 1},
+					{Key: "webhook_id", Value: 1},
+					{Key: "created_at", Value: -1},
+					{Key: "_id", Value: -1},
+				},
+			},
+		},
+		{
+			// Deliveries waiting for a retry
+			collection: "webhook_delivery",
+			opts: mongo.IndexModel{
+				Keys: bson.D{
+					{Key: "status", Value: 1},
+					{Key: "next_attempt_at", Value: 1},
+				},
+			},
+		},
 	}
 
 	for _, index := range indexes {
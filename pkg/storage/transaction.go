@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Error code returned by standalone servers when a session starts a transaction
+const illegalOperationCode = 20
+
+// ErrTransactionsUnsupported is returned when the server can't run
+// transactions and writes without them weren't allowed.
+var ErrTransactionsUnsupported = errors.New(
+	"transactions need a replica set, set MONGO_STANDALONE_WRITES=true to write without them",
+)
+
+// WithTransaction runs fn inside a multi-document transaction so every write
+// performed with the given context is committed or aborted together.
+// Transactions need a replica set, on a standalone instance it fails with
+// ErrTransactionsUnsupported unless MONGO_STANDALONE_WRITES is true, in which
+// case fn is executed without one.
+func WithTransaction(ctx context.Context, db *mongo.Database, fn func(ctx context.Context) error) error {
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		// The driver retries transient errors without looking at the deadline
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, fn(sessionCtx)
+	})
+
+	var serverError mongo.ServerError
+	if errors.As(err, &serverError) && serverError.HasErrorCode(illegalOperationCode) {
+		if os.Getenv("MONGO_STANDALONE_WRITES") != "true" {
+			return fmt.Errorf("%w: %s", ErrTransactionsUnsupported, err)
+		}
+
+		return fn(ctx)
+	}
+
+	return err
+}
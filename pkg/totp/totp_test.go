@@ -0,0 +1,77 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+// Seed of the SHA-1 test vectors of RFC 6238
+var secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// Appendix B lists 8 digit codes, 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := totp.Code(secret, totp.Step(time.Unix(test.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, test.code, code, "at %d", test.unix)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	_, err := totp.Code("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totp.Step(now)
+	code := func(step int64) string {
+		code, err := totp.Code(secret, step)
+		assert.NoError(t, err)
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"previous step", code(current - 1), 0, current - 1, true},
+		{"next step", code(current + 1), 0, current + 1, true},
+		{"two steps behind", code(current - 2), 0, 0, false},
+		{"two steps ahead", code(current + 2), 0, 0, false},
+		{"surrounding spaces", " " + code(current) + " ", 0, current, true},
+		{"wrong length", code(current)[:5], 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"already used step", code(current), current, 0, false},
+		{"step before the used one", code(current - 1), current - 1, 0, false},
+		{"step after the used one", code(current + 1), current, current + 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := totp.Validate(secret, test.code, now, test.lastStep)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.step, step)
+		})
+	}
+}
@@ -1,58 +1,35 @@
 package apiutils
 
 import (
-	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"math"
 	"strconv"
+	"time"
 
+	"github.com/Roll-Play/togglelabs/pkg/config"
 	organizationmodel "github.com/Roll-Play/togglelabs/pkg/models/organization"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/oauth2"
 )
 
-type BaseHTTPClient interface {
-	Get(url string) (*http.Response, error)
-}
-
-type HTTPClient struct{}
-
-func (c *HTTPClient) Get(url string) (*http.Response, error) {
-	return http.Get(url) //nolint
-}
-
-type OAuthClient interface {
-	Exchange(context.Context, string) (*oauth2.Token, error)
-}
-
-type OAuthClientProduction struct {
-	config *oauth2.Config
-}
-
-func NewOAuthClient(config *oauth2.Config) *OAuthClientProduction {
-	return &OAuthClientProduction{config: config}
-}
-
-func (c *OAuthClientProduction) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
-	return c.config.Exchange(ctx, code)
-}
-
+// GetPaginationParams parses the page and page size query values, falling
+// back to the defaults when they are missing or invalid and capping the page
+// size to config.MaxPageSize.
 func GetPaginationParams(page, limit string) (int, int) {
-	if page == "" {
-		page = "1"
+	pageNumber, err := strconv.Atoi(page)
+	if err != nil || pageNumber < 1 {
+		pageNumber = 1
 	}
 
-	if limit == "" {
-		limit = "10"
+	limitNumber, err := strconv.Atoi(limit)
+	if err != nil || limitNumber < 1 {
+		limitNumber = config.DefaultPageSize
 	}
 
-	// Atoi can return an error if the string size is < 1
-	// but because we check it before converting it'll probably
-	// never happen
-	pageNumber, _ := strconv.Atoi(page)
-	limitNumber, _ := strconv.Atoi(limit)
+	if limitNumber > config.MaxPageSize {
+		limitNumber = config.MaxPageSize
+	}
 
 	return pageNumber, limitNumber
 }
@@ -100,6 +77,40 @@ func GetOrganizationFromContext(c echo.Context) (primitive.ObjectID, error) {
 	return organizationID, nil
 }
 
+// GetOrganizationRecordFromContext returns the organization loaded by the
+// permission middlewares of the route.
+func GetOrganizationRecordFromContext(c echo.Context) (*organizationmodel.OrganizationRecord, error) {
+	organization, ok := c.Get("organization_record").(*organizationmodel.OrganizationRecord)
+	if !ok || organization == nil {
+		return nil, ErrNoOrganization
+	}
+
+	return organization, nil
+}
+
+// GetSessionIssuedAtFromContext returns when the session of the request was
+// created, the zero time for sessions that don't tell.
+func GetSessionIssuedAtFromContext(c echo.Context) time.Time {
+	issuedAt, ok := c.Get("session_issued_at").(int64)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(issuedAt, 0)
+}
+
+// GetSessionSecondFactorFromContext tells whether the session of the request
+// was created after the user passed their second factor.
+func GetSessionSecondFactorFromContext(c echo.Context) bool {
+	secondFactor, _ := c.Get("session_second_factor").(bool)
+	return secondFactor
+}
+
+// SetRetryAfter tells the client to wait before retrying, in whole seconds.
+func SetRetryAfter(c echo.Context, wait time.Duration) {
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
 func HandlerErrorLogMessage(err error, c echo.Context) string {
 	return fmt.Sprintf(
 		"[Error]: {\"error\": \"%s\", \"ip\": \"%s\", \"location\": \"%s\"}",
@@ -142,3 +153,56 @@ func UserHasPermission(
 
 	return false
 }
+
+// UserIsAllowed tells whether the user has permission within scope, through
+// their permission level or one of their roles.
+func UserIsAllowed(
+	userID primitive.ObjectID,
+	organization *organizationmodel.OrganizationRecord,
+	permission organizationmodel.Permission,
+	scope organizationmodel.Scope,
+) bool {
+	return userIsAllowed(userID, organization, permission, func(role *organizationmodel.Role) bool {
+		return role.Allows(permission, scope)
+	})
+}
+
+// UserIsAllowedAnywhere tells whether the user has permission in some scope,
+// so requests they can't make anywhere are turned down before loading what
+// they apply to.
+func UserIsAllowedAnywhere(
+	userID primitive.ObjectID,
+	organization *organizationmodel.OrganizationRecord,
+	permission organizationmodel.Permission,
+) bool {
+	return userIsAllowed(userID, organization, permission, func(role *organizationmodel.Role) bool {
+		return role.AllowsAnywhere(permission)
+	})
+}
+
+func userIsAllowed(
+	userID primitive.ObjectID,
+	organization *organizationmodel.OrganizationRecord,
+	permission organizationmodel.Permission,
+	allows func(role *organizationmodel.Role) bool,
+) bool {
+	for _, member := range organization.Members {
+		if member.User.ID != userID {
+			continue
+		}
+
+		if organizationmodel.LevelAllows(member.PermissionLevel, permission) {
+			return true
+		}
+
+		for _, roleID := range member.Roles {
+			if role := organization.Role(roleID); role != nil && allows(role) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
+}
@@ -0,0 +1,32 @@
+package apiutils_test
+
+import (
+	"testing"
+
+	"github.com/Roll-Play/togglelabs/pkg/config"
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPaginationParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  string
+		limit string
+		want  [2]int
+	}{
+		{"defaults", "", "", [2]int{1, config.DefaultPageSize}},
+		{"given values", "3", "25", [2]int{3, 25}},
+		{"invalid values", "first", "many", [2]int{1, config.DefaultPageSize}},
+		{"values below one", "0", "-5", [2]int{1, config.DefaultPageSize}},
+		{"largest page size", "1", "100", [2]int{1, config.MaxPageSize}},
+		{"page size above the maximum", "1", "1000", [2]int{1, config.MaxPageSize}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, limit := apiutils.GetPaginationParams(test.page, test.limit)
+			assert.Equal(t, test.want, [2]int{page, limit})
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package apiutils
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidCursor      = errors.New("invalid pagination cursor")
+	ErrCursorSortMismatch = errors.New("pagination cursor belongs to another sort")
+)
+
+// Cursor points right after the last record of a page. It holds the value of
+// the first sort key and the _id of that record, which is always used as the
+// tiebreaker of the sort, along with the sort it was built for.
+type Cursor struct {
+	Value     interface{}        `bson:"v"`
+	ID        primitive.ObjectID `bson:"id"`
+	Field     string             `bson:"f"`
+	Direction int                `bson:"d"`
+}
+
+// NewCursor builds the opaque cursor of record for the given sort.
+func NewCursor(record interface{}, sort bson.D) (string, error) {
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	id, ok := bson.Raw(raw).Lookup("_id").ObjectIDOK()
+	if !ok {
+		return "", ErrInvalidCursor
+	}
+
+	cursor := Cursor{ID: id, Field: sort[0].Key, Direction: sortDirection(sort)}
+	if sort[0].Key != "_id" {
+		value, err := bson.Raw(raw).LookupErr(strings.Split(sort[0].Key, ".")...)
+		if err != nil {
+			return "", err
+		}
+		cursor.Value = value
+	}
+
+	data, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseCursor decodes cursor, which must have been built for sort.
+func ParseCursor(cursor string, sort bson.D) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parsed := new(Cursor)
+	if err := bson.Unmarshal(data, parsed); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// Documents in the value would be read as query operators by Filter
+	switch parsed.Value.(type) {
+	case primitive.D, primitive.A:
+		return nil, ErrInvalidCursor
+	}
+
+	if parsed.Field != sort[0].Key || parsed.Direction != sortDirection(sort) {
+		return nil, ErrCursorSortMismatch
+	}
+
+	return parsed, nil
+}
+
+// sortDirection returns -1 when the first key of sort is descending, 1
+// otherwise.
+func sortDirection(sort bson.D) int {
+	switch direction := sort[0].Value.(type) {
+	case int:
+		if direction < 0 {
+			return -1
+		}
+	case int32:
+		if direction < 0 {
+			return -1
+		}
+	case int64:
+		if direction < 0 {
+			return -1
+		}
+	}
+
+	return 1
+}
+
+// Filter returns the condition matching the records after the cursor for the
+// given sort, whose last key must be _id. It is meant to be combined with the
+// other conditions of the query through $and.
+func (c *Cursor) Filter(sort bson.D) bson.M {
+	operator := "$gt"
+	if sortDirection(sort) < 0 {
+		operator = "$lt"
+	}
+
+	if sort[0].Key == "_id" {
+		return bson.M{"_id": bson.M{operator: c.ID}}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{sort[0].Key: bson.M{operator: c.Value}},
+		bson.M{sort[0].Key: c.Value, "_id": bson.M{operator: c.ID}},
+	}}
+}
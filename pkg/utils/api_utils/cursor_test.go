@@ -0,0 +1,150 @@
+package apiutils_test
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	apiutils "github.com/Roll-Play/togglelabs/pkg/utils/api_utils"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type cursorRecord struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	Timestamp struct {
+		CreatedAt primitive.DateTime `bson:"created_at"`
+	} `bson:"timestamps"`
+}
+
+var (
+	nameSort    = bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}
+	createdSort = bson.D{{Key: "timestamps.created_at", Value: -1}, {Key: "_id", Value: -1}}
+	idSort      = bson.D{{Key: "_id", Value: -1}}
+)
+
+func encodeCursor(t *testing.T, cursor interface{}) string {
+	data, err := bson.Marshal(cursor)
+	assert.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	record := cursorRecord{ID: primitive.NewObjectID(), Name: "new checkout"}
+	createdAt := primitive.NewDateTimeFromTime(time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC))
+	record.Timestamp.CreatedAt = createdAt
+
+	tests := []struct {
+		name   string
+		sort   bson.D
+		value  interface{}
+		filter bson.M
+	}{
+		{
+			"ascending field",
+			nameSort,
+			"new checkout",
+			bson.M{"$or": bson.A{
+				bson.M{"name": bson.M{"$gt": "new checkout"}},
+				bson.M{"name": "new checkout", "_id": bson.M{"$gt": record.ID}},
+			}},
+		},
+		{
+			"descending nested field",
+			createdSort,
+			createdAt,
+			bson.M{"$or": bson.A{
+				bson.M{"timestamps.created_at": bson.M{"$lt": createdAt}},
+				bson.M{"timestamps.created_at": createdAt, "_id": bson.M{"$lt": record.ID}},
+			}},
+		},
+		{
+			"_id only",
+			idSort,
+			nil,
+			bson.M{"_id": bson.M{"$lt": record.ID}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := apiutils.NewCursor(record, test.sort)
+			assert.NoError(t, err)
+
+			cursor, err := apiutils.ParseCursor(encoded, test.sort)
+			assert.NoError(t, err)
+			assert.Equal(t, record.ID, cursor.ID)
+			assert.Equal(t, test.value, cursor.Value)
+			assert.Equal(t, test.filter, cursor.Filter(test.sort))
+		})
+	}
+}
+
+func TestNewCursorWithoutID(t *testing.T) {
+	_, err := apiutils.NewCursor(bson.M{"name": "new checkout"}, nameSort)
+	assert.ErrorIs(t, err, apiutils.ErrInvalidCursor)
+}
+
+func TestParseCursorRejectsInvalidCursors(t *testing.T) {
+	id := primitive.NewObjectID()
+	valid, err := apiutils.NewCursor(cursorRecord{ID: id, Name: "new checkout"}, nameSort)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		cursor string
+		err    error
+	}{
+		{"not base64", "not a cursor!", apiutils.ErrInvalidCursor},
+		{"padded base64", valid + "==", apiutils.ErrInvalidCursor},
+		{"not bson", base64.RawURLEncoding.EncodeToString([]byte("not bson")), apiutils.ErrInvalidCursor},
+		{"truncated", valid[:len(valid)/2], apiutils.ErrInvalidCursor},
+		{
+			"wrong types",
+			encodeCursor(t, bson.M{"v": "new checkout", "id": "not an id", "f": "name", "d": 1}),
+			apiutils.ErrInvalidCursor,
+		},
+		{
+			"operator in the value",
+			encodeCursor(t, bson.M{"v": bson.M{"$ne": nil}, "id": id, "f": "name", "d": 1}),
+			apiutils.ErrInvalidCursor,
+		},
+		{
+			"array in the value",
+			encodeCursor(t, bson.M{"v": bson.A{"a", "b"}, "id": id, "f": "name", "d": 1}),
+			apiutils.ErrInvalidCursor,
+		},
+		{
+			"other field",
+			encodeCursor(t, bson.M{"v": "new checkout", "id": id, "f": "key", "d": 1}),
+			apiutils.ErrCursorSortMismatch,
+		},
+		{
+			"other direction",
+			encodeCursor(t, bson.M{"v": "new checkout", "id": id, "f": "name", "d": -1}),
+			apiutils.ErrCursorSortMismatch,
+		},
+		{"empty document", encodeCursor(t, bson.M{}), apiutils.ErrCursorSortMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NotPanics(t, func() {
+				cursor, err := apiutils.ParseCursor(test.cursor, nameSort)
+				assert.Nil(t, cursor)
+				assert.ErrorIs(t, err, test.err)
+			})
+		})
+	}
+}
+
+func TestParseCursorOfAnotherSort(t *testing.T) {
+	encoded, err := apiutils.NewCursor(cursorRecord{ID: primitive.NewObjectID(), Name: "new checkout"}, nameSort)
+	assert.NoError(t, err)
+
+	_, err = apiutils.ParseCursor(encoded, createdSort)
+	assert.ErrorIs(t, err, apiutils.ErrCursorSortMismatch)
+}
@@ -1,6 +1,8 @@
 package apiutils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"os"
 	"time"
 
@@ -8,18 +10,34 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// SessionVersionClaim holds the session version of the user when the token
+// was created, tokens of older versions are revoked.
+const SessionVersionClaim = "ver"
+
+// SecondFactorClaim tells the session was created after the user passed their
+// second factor.
+const SecondFactorClaim = "mfa"
+
 func CreateJWT(id primitive.ObjectID, expireAt time.Duration) (string, error) {
+	return CreateSessionJWT(id, 0, false, expireAt)
+}
+
+func CreateSessionJWT(
+	id primitive.ObjectID,
+	sessionVersion int,
+	secondFactor bool,
+	expireAt time.Duration,
+) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"iss": "togglelabs",
-		"sub": id.Hex(),
-		"exp": time.Now().Add(expireAt * time.Millisecond).Unix(),
+		"iss":               "togglelabs",
+		"sub":               id.Hex(),
+		"iat":               time.Now().Unix(),
+		"exp":               time.Now().Add(expireAt * time.Millisecond).Unix(),
+		SessionVersionClaim: sessionVersion,
+		SecondFactorClaim:   secondFactor,
 	})
 
-	key := os.Getenv("JWT_SECRET")
-	if key == "" {
-		key = "your-secret-key"
-	}
-	signedToken, err := token.SignedString([]byte(key))
+	signedToken, err := token.SignedString([]byte(jwtSecret()))
 
 	if err != nil {
 		return "", err
@@ -27,3 +45,20 @@ func CreateJWT(id primitive.ObjectID, expireAt time.Duration) (string, error) {
 
 	return signedToken, nil
 }
+
+// SigningKey derives a key for tokens of another purpose than sessions, so
+// they can't be passed off as one.
+func SigningKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(jwtSecret()))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func jwtSecret() string {
+	key := os.Getenv("JWT_SECRET")
+	if key == "" {
+		key = "your-secret-key"
+	}
+
+	return key
+}
@@ -0,0 +1,344 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/events"
+	webhookmodel "github.com/Roll-Play/togglelabs/pkg/models/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+const (
+	EventHeader     = "X-Togglelabs-Event"
+	DeliveryHeader  = "X-Togglelabs-Delivery"
+	TimestampHeader = "X-Togglelabs-Timestamp"
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the webhook secret.
+	SignatureHeader = "X-Togglelabs-Signature"
+)
+
+// TestEvent is only sent on demand to check a webhook endpoint.
+const TestEvent = "webhook.test"
+
+const (
+	MaxAttempts      = 8
+	RetryInterval    = 30 * time.Second
+	MaxRetryInterval = 6 * time.Hour
+	requestTimeout   = 10 * time.Second
+	// How long an attempt owns a delivery before the worker may retry it
+	claimDuration = 3 * requestTimeout
+	pollInterval  = 5 * time.Second
+	// Response bodies are drained up to this size so connections are reused
+	maxResponseBody = 64 * 1024
+)
+
+var ErrWebhookRemoved = errors.New("webhook removed or disabled")
+
+type HTTPClient interface {
+	Do(request *http.Request) (*http.Response, error)
+}
+
+// Dispatcher delivers events to the webhooks subscribed to them.
+type Dispatcher struct {
+	db     *mongo.Database
+	logger *zap.Logger
+	client HTTPClient
+}
+
+func NewDispatcher(db *mongo.Database, logger *zap.Logger, client HTTPClient) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		logger: logger,
+		client: client,
+	}
+}
+
+func GenerateSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(bytes), nil
+}
+
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait before the attempt following attempt, doubling from
+// RetryInterval up to MaxRetryInterval.
+func Backoff(attempt int) time.Duration {
+	backoff := RetryInterval
+	for i := 1; i < attempt && backoff < MaxRetryInterval; i++ {
+		backoff *= 2
+	}
+
+	if backoff > MaxRetryInterval {
+		return MaxRetryInterval
+	}
+
+	return backoff
+}
+
+// NextAttempt is when the attempt following the failed attempt runs, false
+// when it was the last one of MaxAttempts.
+func NextAttempt(attempt int, failedAt time.Time) (time.Time, bool) {
+	if attempt >= MaxAttempts {
+		return time.Time{}, false
+	}
+
+	return failedAt.Add(Backoff(attempt)), true
+}
+
+// Handle queues event for every subscribed webhook and makes the first
+// attempts in the background.
+func (d *Dispatcher) Handle(event *events.Event) {
+	ctx := context.Background()
+
+	model := webhookmodel.New(d.db)
+	webhooks, err := model.FindSubscribed(ctx, event.OrganizationID, event.Type)
+	if err != nil {
+		d.logger.Error("Unable to find webhooks",
+			zap.Error(err),
+			zap.String("event_id", event.ID.Hex()),
+		)
+		return
+	}
+
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("Unable to encode event",
+			zap.Error(err),
+			zap.String("event_id", event.ID.Hex()),
+		)
+		return
+	}
+
+	for i := range webhooks {
+		webhook := &webhooks[i]
+		delivery, err := d.enqueue(ctx, webhook, event.ID, event.Type, string(payload), nil)
+		if err != nil {
+			d.logger.Error("Unable to queue delivery",
+				zap.Error(err),
+				zap.String("webhook_id", webhook.ID.Hex()),
+				zap.String("event_id", event.ID.Hex()),
+			)
+			continue
+		}
+
+		go d.attempt(context.Background(), webhook, delivery)
+	}
+}
+
+// Send delivers event to webhook right away and returns the delivery with the
+// outcome of its first attempt.
+func (d *Dispatcher) Send(
+	ctx context.Context,
+	webhook *webhookmodel.WebhookRecord,
+	event *events.Event,
+) (*webhookmodel.DeliveryRecord, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := d.enqueue(ctx, webhook, event.ID, event.Type, string(payload), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, d.attempt(ctx, webhook, delivery)
+}
+
+// Redeliver sends the payload of delivery again as a new delivery.
+func (d *Dispatcher) Redeliver(
+	ctx context.Context,
+	webhook *webhookmodel.WebhookRecord,
+	delivery *webhookmodel.DeliveryRecord,
+) (*webhookmodel.DeliveryRecord, error) {
+	redelivery, err := d.enqueue(ctx, webhook, delivery.EventID, delivery.EventType, delivery.Payload, &delivery.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return redelivery, d.attempt(ctx, webhook, redelivery)
+}
+
+// Run retries due deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.retryDue(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) retryDue(ctx context.Context) {
+	deliveryModel := webhookmodel.NewDeliveryModel(d.db)
+	webhookModel := webhookmodel.New(d.db)
+
+	for ctx.Err() == nil {
+		now := time.Now().UTC()
+		delivery, err := deliveryModel.ClaimDue(ctx, now, now.Add(claimDuration))
+		if err != nil {
+			if !errors.Is(err, mongo.ErrNoDocuments) {
+				d.logger.Error("Unable to claim delivery",
+					zap.Error(err),
+				)
+			}
+			return
+		}
+
+		webhook, err := webhookModel.FindByID(ctx, delivery.OrganizationID, delivery.WebhookID)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			d.logger.Error("Unable to find webhook",
+				zap.Error(err),
+				zap.String("delivery_id", delivery.ID.Hex()),
+			)
+			continue
+		}
+
+		if webhook == nil || !webhook.Active {
+			err = deliveryModel.RecordAttempt(ctx, delivery, webhookmodel.DeliveryAttempt{
+				Timestamp: primitive.NewDateTimeFromTime(now),
+				Error:     ErrWebhookRemoved.Error(),
+			}, webhookmodel.Failed, nil)
+			if err != nil {
+				d.logger.Error("Unable to record attempt",
+					zap.Error(err),
+					zap.String("delivery_id", delivery.ID.Hex()),
+				)
+			}
+			continue
+		}
+
+		if err := d.attempt(ctx, webhook, delivery); err != nil {
+			d.logger.Error("Unable to record attempt",
+				zap.Error(err),
+				zap.String("delivery_id", delivery.ID.Hex()),
+			)
+		}
+	}
+}
+
+func (d *Dispatcher) enqueue(
+	ctx context.Context,
+	webhook *webhookmodel.WebhookRecord,
+	eventID primitive.ObjectID,
+	eventType,
+	payload string,
+	redeliveryOf *primitive.ObjectID,
+) (*webhookmodel.DeliveryRecord, error) {
+	delivery := webhookmodel.NewDeliveryRecord(
+		webhook.OrganizationID,
+		webhook.ID,
+		eventID,
+		eventType,
+		payload,
+		time.Now().UTC().Add(claimDuration),
+	)
+	delivery.RedeliveryOf = redeliveryOf
+
+	model := webhookmodel.NewDeliveryModel(d.db)
+	if _, err := model.InsertOne(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// attempt posts the delivery once and records the outcome, scheduling the
+// next attempt when it failed and attempts are left.
+func (d *Dispatcher) attempt(
+	ctx context.Context,
+	webhook *webhookmodel.WebhookRecord,
+	delivery *webhookmodel.DeliveryRecord,
+) error {
+	start := time.Now().UTC()
+	attempt := webhookmodel.DeliveryAttempt{
+		Timestamp: primitive.NewDateTimeFromTime(start),
+	}
+
+	statusCode, err := d.post(ctx, webhook, delivery, start)
+	attempt.Duration = time.Since(start).Milliseconds()
+	attempt.StatusCode = statusCode
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	status := webhookmodel.Succeeded
+	var nextAttemptAt *primitive.DateTime
+	if err != nil || statusCode < http.StatusOK || statusCode >= http.StatusMultipleChoices {
+		status = webhookmodel.Failed
+		if next, ok := NextAttempt(len(delivery.Attempts)+1, start); ok {
+			status = webhookmodel.Pending
+			nextAttempt := primitive.NewDateTimeFromTime(next)
+			nextAttemptAt = &nextAttempt
+		}
+	}
+
+	model := webhookmodel.NewDeliveryModel(d.db)
+	return model.RecordAttempt(ctx, delivery, attempt, status, nextAttemptAt)
+}
+
+func (d *Dispatcher) post(
+	ctx context.Context,
+	webhook *webhookmodel.WebhookRecord,
+	delivery *webhookmodel.DeliveryRecord,
+	now time.Time,
+) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "togglelabs-webhooks")
+	request.Header.Set(EventHeader, delivery.EventType)
+	request.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, maxResponseBody))
+	return response.StatusCode, nil
+}
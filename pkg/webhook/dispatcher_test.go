@@ -0,0 +1,83 @@
+package webhook_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Roll-Play/togglelabs/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"type":"flag.toggled"}`)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		signature string
+	}{
+		{
+			"signs the timestamp and the body",
+			"whsec_test",
+			1700000000,
+			"sha256=9230a5a7c805b3493c824a067940f3feca9231223e310882f37c94b5b3948987",
+		},
+		{
+			"changes with the timestamp",
+			"whsec_test",
+			1700000001,
+			"sha256=46de99529e789b8a50cb5bb9c33845b82f1efb3ca6d8b4c5e88ccc1e59140afc",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.signature, webhook.Sign(test.secret, test.timestamp, payload))
+		})
+	}
+
+	assert.NotEqual(t, tests[0].signature, webhook.Sign("other secret", tests[0].timestamp, payload))
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		backoff time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.backoff, webhook.Backoff(test.attempt), "attempt %d", test.attempt)
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	failedAt := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		attempt int
+		next    time.Time
+		ok      bool
+	}{
+		{1, failedAt.Add(30 * time.Second), true},
+		{4, failedAt.Add(4 * time.Minute), true},
+		{webhook.MaxAttempts - 1, failedAt.Add(32 * time.Minute), true},
+		{webhook.MaxAttempts, time.Time{}, false},
+		{webhook.MaxAttempts + 1, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		next, ok := webhook.NextAttempt(test.attempt, failedAt)
+		assert.Equal(t, test.ok, ok, "attempt %d", test.attempt)
+		assert.Equal(t, test.next, next, "attempt %d", test.attempt)
+	}
+}